@@ -0,0 +1,111 @@
+// Command chirpy-import imports a Chirpy export archive into an account. It
+// reads the server's configuration to reach the database.
+//
+// Usage:
+//
+//	chirpy-import -email ada@example.com [-create] [-dry-run] chirpy-export.zip
+//
+// With -create, an account is made for the email if none exists. It gets a
+// random password, so its owner signs in by resetting it. The import report
+// is printed as JSON.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/charlesaraya/chirpy/internal/api"
+	"github.com/charlesaraya/chirpy/internal/auth"
+	"github.com/charlesaraya/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+func main() {
+	email := flag.String("email", "", "email of the account to import into")
+	create := flag.Bool("create", false, "create the account if it does not exist")
+	dryRun := flag.Bool("dry-run", false, "report what would be imported without importing it")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: chirpy-import -email EMAIL [-create] [-dry-run] ARCHIVE")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *email == "" || flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	file, err := os.Open(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		log.Fatal(err)
+	}
+	archive, err := api.ReadImportArchive(file, info.Size())
+	if err != nil {
+		log.Fatalf("reading %s: %v", flag.Arg(0), err)
+	}
+
+	apiCfg, err := api.Load()
+	if err != nil {
+		log.Fatalf("loading config: %v", err)
+	}
+	ctx := context.Background()
+	user, err := findUser(ctx, apiCfg, *email, *create, *dryRun)
+	if err != nil {
+		log.Fatal(err)
+	}
+	report, err := apiCfg.ImportArchive(ctx, user, archive, *dryRun)
+	if err != nil {
+		log.Fatalf("importing: %v", err)
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// findUser returns the account with the email, creating it if asked to. A
+// dry run stands in an empty account for the one it would create.
+func findUser(ctx context.Context, apiCfg *api.ApiConfig, email string, create, dryRun bool) (database.User, error) {
+	user, err := apiCfg.DBQueries.GetUser(ctx, email)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return database.User{}, err
+	}
+	if !create {
+		return database.User{}, fmt.Errorf("no account has the email %s; use -create to make one", email)
+	}
+	if dryRun {
+		return database.User{ID: uuid.New(), Email: email, CreatedAt: time.Now()}, nil
+	}
+	password, err := auth.MakeRefreshToken()
+	if err != nil {
+		return database.User{}, err
+	}
+	hashedPassword, err := apiCfg.PasswordHasher.Hash(password)
+	if err != nil {
+		return database.User{}, err
+	}
+	user, err = apiCfg.DBQueries.CreateUser(ctx, database.CreateUserParams{
+		Email:          email,
+		HashedPassword: hashedPassword,
+	})
+	if err != nil {
+		return database.User{}, fmt.Errorf("creating account: %w", err)
+	}
+	log.Printf("created account %s for %s; its owner can sign in after resetting the password", user.ID, email)
+	return user, nil
+}
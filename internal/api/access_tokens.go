@@ -0,0 +1,190 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/charlesaraya/chirpy/internal/auth"
+	"github.com/charlesaraya/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	ErrorInsufficientScope  string = "Token does not have the required scope"
+	ErrorInvalidTokenScopes string = "Unknown or missing token scopes"
+	ErrorInvalidTokenName   string = "Token name is required"
+	maxAccessTokenNameLen   int    = 100
+)
+
+type accessTokenPayload struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	Scopes     []string `json:"scopes"`
+	CreatedAt  string   `json:"created_at"`
+	ExpiresAt  string   `json:"expires_at,omitempty"`
+	LastUsedAt string   `json:"last_used_at,omitempty"`
+	// Token is only returned when the token is created.
+	Token string `json:"token,omitempty"`
+}
+
+func newAccessTokenPayload(token database.PersonalAccessToken) accessTokenPayload {
+	payload := accessTokenPayload{
+		ID:        token.ID.String(),
+		Name:      token.Name,
+		Scopes:    token.Scopes,
+		CreatedAt: token.CreatedAt.Format(TimeFormat),
+	}
+	if token.ExpiresAt.Valid {
+		payload.ExpiresAt = token.ExpiresAt.Time.Format(TimeFormat)
+	}
+	if token.LastUsedAt.Valid {
+		payload.LastUsedAt = token.LastUsedAt.Time.Format(TimeFormat)
+	}
+	return payload
+}
+
+// authenticatePersonalAccessToken resolves the owner of a personal access
+// token that grants scope, and records that the token was used. Routes that
+// name no scope are closed to personal access tokens.
+func (cfg *ApiConfig) authenticatePersonalAccessToken(ctx context.Context, token, scope string) (database.User, error) {
+	accessToken, err := cfg.DBQueries.GetPersonalAccessToken(ctx, auth.HashToken(token))
+	if err != nil {
+		return database.User{}, errUnauthenticated
+	}
+	if accessToken.ExpiresAt.Valid && accessToken.ExpiresAt.Time.Before(time.Now()) {
+		return database.User{}, errUnauthenticated
+	}
+	if scope == "" || !auth.HasScope(accessToken.Scopes, scope) {
+		return database.User{}, errInsufficientScope
+	}
+	user, err := cfg.DBQueries.GetUserByID(ctx, accessToken.UserID)
+	if err != nil {
+		return database.User{}, errUnauthenticated
+	}
+	if err := cfg.DBQueries.TouchPersonalAccessToken(ctx, accessToken.ID); err != nil {
+		log.Printf("recording personal access token use: %v", err)
+	}
+	return user, nil
+}
+
+// CreateAccessTokenHandler issues a personal access token for scripts and
+// bots. The token is only shown in this response; just its hash is kept.
+func CreateAccessTokenHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		type reqPayload struct {
+			Name      string   `json:"name"`
+			Scopes    []string `json:"scopes"`
+			ExpiresAt string   `json:"expires_at"`
+		}
+		user, err := apiCfg.authenticate(req)
+		if err != nil {
+			respondWithAuthError(res, err)
+			return
+		}
+		params := reqPayload{}
+		if err := json.NewDecoder(req.Body).Decode(&params); err != nil {
+			http.Error(res, ErrorSomethingWentWrong, http.StatusBadRequest)
+			return
+		}
+		params.Name = strings.TrimSpace(params.Name)
+		if params.Name == "" || len(params.Name) > maxAccessTokenNameLen {
+			http.Error(res, ErrorInvalidTokenName, http.StatusBadRequest)
+			return
+		}
+		if len(params.Scopes) == 0 {
+			http.Error(res, ErrorInvalidTokenScopes, http.StatusBadRequest)
+			return
+		}
+		for _, scope := range params.Scopes {
+			if !auth.IsValidScope(scope) {
+				http.Error(res, ErrorInvalidTokenScopes, http.StatusBadRequest)
+				return
+			}
+		}
+		expiresAt, err := parseNullTime(params.ExpiresAt)
+		if err != nil || (expiresAt.Valid && expiresAt.Time.Before(time.Now())) {
+			http.Error(res, ErrorSomethingWentWrong, http.StatusBadRequest)
+			return
+		}
+		token, err := auth.MakePersonalAccessToken()
+		if err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		tokenParams := database.CreatePersonalAccessTokenParams{
+			UserID:    user.ID,
+			Name:      params.Name,
+			TokenHash: auth.HashToken(token),
+			Scopes:    params.Scopes,
+			ExpiresAt: sql.NullTime{Time: expiresAt.Time, Valid: expiresAt.Valid},
+		}
+		accessToken, err := apiCfg.DBQueries.CreatePersonalAccessToken(req.Context(), tokenParams)
+		if err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		apiCfg.recordAudit(req, auditAccessTokenCreated, user.ID, user.ID, map[string]any{"token_id": accessToken.ID, "scopes": accessToken.Scopes})
+		payload := newAccessTokenPayload(accessToken)
+		payload.Token = token
+		respondWithJSON(res, http.StatusCreated, payload)
+	}
+}
+
+// GetAccessTokensHandler lists the caller's personal access tokens, newest
+// first.
+func GetAccessTokensHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		user, err := apiCfg.authenticate(req)
+		if err != nil {
+			respondWithAuthError(res, err)
+			return
+		}
+		tokens, err := apiCfg.DBQueries.GetPersonalAccessTokens(req.Context(), user.ID)
+		if err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		payload := make([]accessTokenPayload, len(tokens))
+		for i, token := range tokens {
+			payload[i] = newAccessTokenPayload(token)
+		}
+		respondWithJSON(res, http.StatusOK, payload)
+	}
+}
+
+// RevokeAccessTokenHandler deletes one of the caller's personal access
+// tokens.
+func RevokeAccessTokenHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		user, err := apiCfg.authenticate(req)
+		if err != nil {
+			respondWithAuthError(res, err)
+			return
+		}
+		tokenID, err := uuid.Parse(req.PathValue("tokenID"))
+		if err != nil {
+			http.Error(res, ErrorNotFound, http.StatusNotFound)
+			return
+		}
+		params := database.DeletePersonalAccessTokenParams{
+			ID:     tokenID,
+			UserID: user.ID,
+		}
+		deleted, err := apiCfg.DBQueries.DeletePersonalAccessToken(req.Context(), params)
+		if err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		if deleted == 0 {
+			http.Error(res, ErrorNotFound, http.StatusNotFound)
+			return
+		}
+		apiCfg.recordAudit(req, auditAccessTokenRevoked, user.ID, user.ID, map[string]any{"token_id": tokenID})
+		res.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,269 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/charlesaraya/chirpy/internal/database"
+	"github.com/charlesaraya/chirpy/internal/mail"
+	"github.com/google/uuid"
+)
+
+// Deleting an account is not immediate. DELETE /api/users/me schedules the
+// erasure, and until the grace period is over the user can sign in and
+// cancel it. A background job then erases the account as the erasure policy
+// says: everything the user owns goes with them, except chirps, which can be
+// kept under a placeholder author, and audit events, which are kept with
+// their personal data redacted.
+const (
+	DefaultDeletionGracePeriod time.Duration = 30 * 24 * time.Hour
+	erasureInterval            time.Duration = 10 * time.Minute
+	erasureBatchSize           int32         = 100
+	erasureMaxRetryDelay       time.Duration = 24 * time.Hour
+)
+
+// erasedUserID is the suspended placeholder account that anonymized chirps
+// are reassigned to. Migrations create it; its address is on the reserved
+// .invalid domain, which validEmail refuses, so nobody can claim it.
+var erasedUserID = uuid.MustParse("00000000-0000-4000-8000-000000000001")
+
+// Erasure policy options.
+const (
+	EraseChirpsDelete    string = "delete"
+	EraseChirpsAnonymize string = "anonymize"
+	EraseAuditRedact     string = "redact"
+	EraseAuditKeep       string = "keep"
+)
+
+const ErrorNoDeletionScheduled string = "No account deletion is scheduled"
+
+// ErasurePolicy says what happens to a deleted account's data.
+type ErasurePolicy struct {
+	GracePeriod time.Duration
+	// Chirps are deleted, or kept and attributed to a placeholder author.
+	Chirps string
+	// Audit events are redacted of IP addresses, user agents and metadata,
+	// or kept as they are. Either way they keep the user's ID.
+	Audit string
+}
+
+func DefaultErasurePolicy() ErasurePolicy {
+	return ErasurePolicy{
+		GracePeriod: DefaultDeletionGracePeriod,
+		Chirps:      EraseChirpsDelete,
+		Audit:       EraseAuditRedact,
+	}
+}
+
+// loadErasurePolicy reads the erasure policy from
+// ACCOUNT_DELETION_GRACE_PERIOD, ERASE_CHIRPS and ERASE_AUDIT.
+func loadErasurePolicy() (ErasurePolicy, error) {
+	policy := DefaultErasurePolicy()
+	if gracePeriod := os.Getenv("ACCOUNT_DELETION_GRACE_PERIOD"); gracePeriod != "" {
+		duration, err := time.ParseDuration(gracePeriod)
+		if err != nil || duration < 0 {
+			return ErasurePolicy{}, fmt.Errorf("invalid ACCOUNT_DELETION_GRACE_PERIOD %q", gracePeriod)
+		}
+		policy.GracePeriod = duration
+	}
+	if chirps := os.Getenv("ERASE_CHIRPS"); chirps != "" {
+		if chirps != EraseChirpsDelete && chirps != EraseChirpsAnonymize {
+			return ErasurePolicy{}, fmt.Errorf("unknown ERASE_CHIRPS %q", chirps)
+		}
+		policy.Chirps = chirps
+	}
+	if audit := os.Getenv("ERASE_AUDIT"); audit != "" {
+		if audit != EraseAuditRedact && audit != EraseAuditKeep {
+			return ErasurePolicy{}, fmt.Errorf("unknown ERASE_AUDIT %q", audit)
+		}
+		policy.Audit = audit
+	}
+	return policy, nil
+}
+
+type accountDeletionPayload struct {
+	RequestedAt string `json:"requested_at"`
+	EraseAfter  string `json:"erase_after"`
+}
+
+func toAccountDeletionPayload(deletion database.AccountDeletion) accountDeletionPayload {
+	return accountDeletionPayload{
+		RequestedAt: deletion.RequestedAt.Format(TimeFormat),
+		EraseAfter:  deletion.EraseAfter.Format(TimeFormat),
+	}
+}
+
+// DeleteAccountHandler schedules the signed-in user's account for erasure
+// once the grace period is over. It needs the password, and a second factor
+// when the user has one. Asking again does not push the date back.
+func DeleteAccountHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		type reqPayload struct {
+			Password string `json:"password"`
+			secondFactorPayload
+		}
+		user, err := apiCfg.authenticate(req)
+		if err != nil {
+			respondWithAuthError(res, err)
+			return
+		}
+		params := reqPayload{}
+		if err := json.NewDecoder(req.Body).Decode(&params); err != nil {
+			http.Error(res, ErrorSomethingWentWrong, http.StatusBadRequest)
+			return
+		}
+		if !apiCfg.reauthenticate(res, req, user, params.Password, params.secondFactorPayload, true) {
+			return
+		}
+		deletion, err := apiCfg.DBQueries.ScheduleAccountDeletion(req.Context(), database.ScheduleAccountDeletionParams{
+			UserID:     user.ID,
+			EraseAfter: time.Now().Add(apiCfg.ErasurePolicy.GracePeriod),
+		})
+		if err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		apiCfg.recordAudit(req, auditDeletionRequested, user.ID, user.ID, map[string]any{"erase_after": deletion.EraseAfter.Format(TimeFormat)})
+		apiCfg.sendMail(mail.Message{
+			To:      user.Email,
+			Subject: "Your Chirpy account will be deleted",
+			Body: fmt.Sprintf("Your Chirpy account is scheduled to be deleted after %s UTC.\n\n"+
+				"To keep it, sign in and cancel the deletion before then.",
+				deletion.EraseAfter.UTC().Format(time.DateTime)),
+		})
+		respondWithJSON(res, http.StatusAccepted, toAccountDeletionPayload(deletion))
+	}
+}
+
+// GetAccountDeletionHandler tells the signed-in user when their account
+// will be erased, if they have asked for that.
+func GetAccountDeletionHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		user, err := apiCfg.authenticate(req)
+		if err != nil {
+			respondWithAuthError(res, err)
+			return
+		}
+		deletion, err := apiCfg.DBQueries.GetAccountDeletion(req.Context(), user.ID)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(res, ErrorNoDeletionScheduled, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		respondWithJSON(res, http.StatusOK, toAccountDeletionPayload(deletion))
+	}
+}
+
+// CancelAccountDeletionHandler keeps the signed-in user's account.
+func CancelAccountDeletionHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		user, err := apiCfg.authenticate(req)
+		if err != nil {
+			respondWithAuthError(res, err)
+			return
+		}
+		cancelled, err := apiCfg.DBQueries.CancelAccountDeletion(req.Context(), user.ID)
+		if err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		if cancelled == 0 {
+			http.Error(res, ErrorNoDeletionScheduled, http.StatusNotFound)
+			return
+		}
+		apiCfg.recordAudit(req, auditDeletionCancelled, user.ID, user.ID, nil)
+		res.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// eraseAccount carries out a scheduled deletion. Deleting the user row
+// cascades to their chirps, tokens, sessions, second factors, linked
+// identities and OAuth grants, so only what the policy keeps needs handling
+// first. Each step can be repeated, so a failed erasure is retried whole on
+// the next run.
+func (cfg *ApiConfig) eraseAccount(ctx context.Context, userID uuid.UUID) error {
+	if cfg.ErasurePolicy.Chirps == EraseChirpsAnonymize {
+		if _, err := cfg.DBQueries.ReassignUserChirps(ctx, database.ReassignUserChirpsParams{
+			ToUserID:   erasedUserID,
+			FromUserID: userID,
+		}); err != nil {
+			return fmt.Errorf("anonymizing chirps: %w", err)
+		}
+	}
+	if cfg.ErasurePolicy.Audit == EraseAuditRedact {
+		if _, err := cfg.DBQueries.RedactUserAuditEvents(ctx, userID); err != nil {
+			return fmt.Errorf("redacting audit events: %w", err)
+		}
+	}
+	if _, err := cfg.DBQueries.DeleteUser(ctx, userID); err != nil {
+		return fmt.Errorf("deleting user: %w", err)
+	}
+	cfg.recordSystemAudit(ctx, auditUserErased, userID, map[string]any{
+		"chirps": cfg.ErasurePolicy.Chirps,
+		"audit":  cfg.ErasurePolicy.Audit,
+	})
+	return nil
+}
+
+// erasureRetryDelay is how long to wait before retrying an erasure that has
+// failed attempts times, doubling from erasureInterval up to
+// erasureMaxRetryDelay.
+func erasureRetryDelay(attempts int32) time.Duration {
+	delay := erasureInterval
+	for i := int32(1); i < attempts && delay < erasureMaxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, erasureMaxRetryDelay)
+}
+
+// eraseDueAccounts erases the accounts whose grace period is over. An
+// account that fails is logged and put off with a growing delay, so it
+// does not block the ones due after it.
+func (cfg *ApiConfig) eraseDueAccounts(ctx context.Context) error {
+	for {
+		deletions, err := cfg.DBQueries.GetDueAccountDeletions(ctx, erasureBatchSize)
+		if err != nil {
+			return err
+		}
+		for _, deletion := range deletions {
+			err := cfg.eraseAccount(ctx, deletion.UserID)
+			if err == nil {
+				continue
+			}
+			delay := erasureRetryDelay(deletion.Attempts + 1)
+			log.Printf("account erasure: erasing %s (attempt %d, retrying in %s): %v", deletion.UserID, deletion.Attempts+1, delay, err)
+			if err := cfg.DBQueries.RecordAccountDeletionFailure(ctx, database.RecordAccountDeletionFailureParams{
+				RetryAfter: sql.NullTime{Time: time.Now().Add(delay), Valid: true},
+				UserID:     deletion.UserID,
+			}); err != nil {
+				// Without the retry time the same account would be fetched
+				// again straight away.
+				return fmt.Errorf("recording failed erasure of %s: %w", deletion.UserID, err)
+			}
+		}
+		if len(deletions) < int(erasureBatchSize) {
+			return nil
+		}
+	}
+}
+
+// watchErasures runs eraseDueAccounts in the background.
+func (cfg *ApiConfig) watchErasures(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := cfg.eraseDueAccounts(context.Background()); err != nil {
+			log.Printf("account erasure: %v", err)
+		}
+	}
+}
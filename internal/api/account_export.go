@@ -0,0 +1,402 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/charlesaraya/chirpy/internal/auth"
+	"github.com/charlesaraya/chirpy/internal/database"
+	"github.com/charlesaraya/chirpy/internal/export"
+	"github.com/charlesaraya/chirpy/internal/mail"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// Users can download everything Chirpy keeps about them. Asking for an
+// export queues it; the archive is built in the background, kept for a week
+// and downloaded through signed links that work without a session, so they
+// can be opened from the email that says the export is ready.
+const (
+	ExportRetention    time.Duration = 7 * 24 * time.Hour
+	ExportLinkDuration time.Duration = 24 * time.Hour
+	exportInterval     time.Duration = time.Minute
+	// exportStaleAfter is how long a build can run before another instance
+	// assumes it has died and starts over.
+	exportStaleAfter time.Duration = 15 * time.Minute
+	// exportDownloadAudience keeps download links from being accepted as
+	// access tokens, and the other way round.
+	exportDownloadAudience string = "chirpy:export"
+)
+
+const (
+	exportStatusReady  string = "ready"
+	exportStatusFailed string = "failed"
+)
+
+const ErrorInvalidExportLink string = "Invalid or expired download link"
+
+type accountExportPayload struct {
+	ID          string `json:"id"`
+	Status      string `json:"status"`
+	CreatedAt   string `json:"created_at"`
+	CompletedAt string `json:"completed_at,omitempty"`
+	ExpiresAt   string `json:"expires_at,omitempty"`
+	DownloadURL string `json:"download_url,omitempty"`
+}
+
+// toAccountExportPayload describes an export, with a fresh download link
+// once it is ready.
+func (cfg *ApiConfig) toAccountExportPayload(accountExport database.AccountExport) (accountExportPayload, error) {
+	payload := accountExportPayload{
+		ID:        accountExport.ID.String(),
+		Status:    accountExport.Status,
+		CreatedAt: accountExport.CreatedAt.Format(TimeFormat),
+	}
+	if accountExport.CompletedAt.Valid {
+		payload.CompletedAt = accountExport.CompletedAt.Time.Format(TimeFormat)
+	}
+	if accountExport.Status != exportStatusReady {
+		return payload, nil
+	}
+	payload.ExpiresAt = accountExport.ExpiresAt.Time.Format(TimeFormat)
+	link, err := cfg.exportDownloadURL(accountExport)
+	if err != nil {
+		return accountExportPayload{}, err
+	}
+	payload.DownloadURL = link
+	return payload, nil
+}
+
+// exportDownloadURL signs a link to an export's archive. It lasts a day, or
+// until the archive is deleted if that is sooner.
+func (cfg *ApiConfig) exportDownloadURL(accountExport database.AccountExport) (string, error) {
+	now := time.Now()
+	expiresAt := now.Add(ExportLinkDuration)
+	if accountExport.ExpiresAt.Time.Before(expiresAt) {
+		expiresAt = accountExport.ExpiresAt.Time
+	}
+	token, err := cfg.Keys.Sign(&auth.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   accountExport.ID.String(),
+			Audience:  jwt.ClaimStrings{exportDownloadAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/api/exports/%s/download?token=%s", cfg.BaseURL, accountExport.ID, url.QueryEscape(token)), nil
+}
+
+func (cfg *ApiConfig) respondWithAccountExport(res http.ResponseWriter, status int, accountExport database.AccountExport) {
+	payload, err := cfg.toAccountExportPayload(accountExport)
+	if err != nil {
+		http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+		return
+	}
+	respondWithJSON(res, status, payload)
+}
+
+// RequestAccountExportHandler queues an export of the signed-in user's data.
+// While one is queued or building, asking again returns it rather than
+// queueing another.
+func RequestAccountExportHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		user, err := apiCfg.authenticate(req)
+		if err != nil {
+			respondWithAuthError(res, err)
+			return
+		}
+		accountExport, err := apiCfg.DBQueries.GetActiveAccountExport(req.Context(), user.ID)
+		if err == nil {
+			apiCfg.respondWithAccountExport(res, http.StatusAccepted, accountExport)
+			return
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		accountExport, err = apiCfg.DBQueries.CreateAccountExport(req.Context(), user.ID)
+		if err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		apiCfg.recordAudit(req, auditExportRequested, user.ID, user.ID, map[string]any{"export_id": accountExport.ID})
+		go apiCfg.buildAccountExports(context.Background())
+		apiCfg.respondWithAccountExport(res, http.StatusAccepted, accountExport)
+	}
+}
+
+// GetAccountExportHandler reports how an export is getting on. Once it is
+// ready the answer carries a link to download it.
+func GetAccountExportHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		user, err := apiCfg.authenticate(req)
+		if err != nil {
+			respondWithAuthError(res, err)
+			return
+		}
+		exportID, err := uuid.Parse(req.PathValue("exportID"))
+		if err != nil {
+			http.Error(res, ErrorNotFound, http.StatusNotFound)
+			return
+		}
+		accountExport, err := apiCfg.DBQueries.GetAccountExport(req.Context(), database.GetAccountExportParams{
+			ID:     exportID,
+			UserID: user.ID,
+		})
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(res, ErrorNotFound, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		apiCfg.respondWithAccountExport(res, http.StatusOK, accountExport)
+	}
+}
+
+// DownloadAccountExportHandler serves an export's archive to whoever holds a
+// link from exportDownloadURL.
+func DownloadAccountExportHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		claims, err := apiCfg.Keys.Parse(req.URL.Query().Get("token"), exportDownloadAudience)
+		if err != nil || claims.Subject != req.PathValue("exportID") {
+			http.Error(res, ErrorInvalidExportLink, http.StatusUnauthorized)
+			return
+		}
+		exportID, err := uuid.Parse(claims.Subject)
+		if err != nil {
+			http.Error(res, ErrorInvalidExportLink, http.StatusUnauthorized)
+			return
+		}
+		archive, err := apiCfg.DBQueries.GetAccountExportArchive(req.Context(), exportID)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(res, ErrorInvalidExportLink, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		res.Header().Set("Content-Type", "application/zip")
+		res.Header().Set("Content-Disposition", `attachment; filename="chirpy-export.zip"`)
+		res.Header().Set("Cache-Control", "no-store")
+		// The link is a credential; keep it out of Referer headers.
+		res.Header().Set("Referrer-Policy", "no-referrer")
+		res.Write(archive)
+	}
+}
+
+// collectAccountData gathers a user's data into an archive.
+func (cfg *ApiConfig) collectAccountData(ctx context.Context, userID uuid.UUID) (database.User, *export.Archive, error) {
+	user, err := cfg.DBQueries.GetUserByID(ctx, userID)
+	if err != nil {
+		return database.User{}, nil, fmt.Errorf("user: %w", err)
+	}
+	archive := &export.Archive{
+		Manifest: export.Manifest{ExportedAt: time.Now().UTC(), UserID: user.ID.String()},
+		Profile: export.Profile{
+			ID:            user.ID.String(),
+			Email:         user.Email,
+			EmailVerified: user.EmailVerifiedAt.Valid,
+			IsChirpyRed:   user.IsChirpyRed,
+			Role:          user.Role,
+			CreatedAt:     user.CreatedAt,
+			UpdatedAt:     user.UpdatedAt,
+		},
+	}
+
+	chirps, err := cfg.DBQueries.GetChirpsFromUser(ctx, user.ID)
+	if err != nil {
+		return database.User{}, nil, fmt.Errorf("chirps: %w", err)
+	}
+	for _, chirp := range chirps {
+		archive.Chirps = append(archive.Chirps, export.Chirp{
+			ID:        chirp.ID.String(),
+			Body:      chirp.Body,
+			Status:    chirp.Status,
+			CreatedAt: chirp.CreatedAt,
+			UpdatedAt: chirp.UpdatedAt,
+		})
+	}
+
+	sessions, err := cfg.DBQueries.GetUserSessions(ctx, user.ID)
+	if err != nil {
+		return database.User{}, nil, fmt.Errorf("sessions: %w", err)
+	}
+	for _, session := range sessions {
+		archive.Sessions = append(archive.Sessions, export.Session{
+			Device:     describeDevice(session.UserAgent),
+			UserAgent:  session.UserAgent,
+			IP:         session.Ip,
+			SignedInAt: session.SignedInAt,
+			LastUsedAt: session.LastUsedAt,
+			ExpiresAt:  session.ExpiresAt,
+		})
+	}
+
+	identities, err := cfg.DBQueries.GetUserIdentities(ctx, user.ID)
+	if err != nil {
+		return database.User{}, nil, fmt.Errorf("identities: %w", err)
+	}
+	for _, identity := range identities {
+		archive.Identities = append(archive.Identities, export.Identity{
+			Provider: identity.Provider,
+			Subject:  identity.Subject,
+			Email:    identity.Email,
+			LinkedAt: identity.CreatedAt,
+		})
+	}
+
+	accessTokens, err := cfg.DBQueries.GetPersonalAccessTokens(ctx, user.ID)
+	if err != nil {
+		return database.User{}, nil, fmt.Errorf("access tokens: %w", err)
+	}
+	for _, accessToken := range accessTokens {
+		archive.AccessTokens = append(archive.AccessTokens, export.AccessToken{
+			Name:       accessToken.Name,
+			Scopes:     accessToken.Scopes,
+			CreatedAt:  accessToken.CreatedAt,
+			ExpiresAt:  nullTimePtr(accessToken.ExpiresAt),
+			LastUsedAt: nullTimePtr(accessToken.LastUsedAt),
+		})
+	}
+
+	grants, err := cfg.DBQueries.GetUserOAuthGrants(ctx, user.ID)
+	if err != nil {
+		return database.User{}, nil, fmt.Errorf("apps: %w", err)
+	}
+	for _, grant := range grants {
+		archive.Apps = append(archive.Apps, export.App{
+			ClientID:     grant.ClientID.String(),
+			Name:         grant.Name,
+			Scopes:       grant.Scopes,
+			AuthorizedAt: grant.CreatedAt,
+			UpdatedAt:    grant.UpdatedAt,
+		})
+	}
+
+	events, err := cfg.DBQueries.GetUserAuditEvents(ctx, user.ID)
+	if err != nil {
+		return database.User{}, nil, fmt.Errorf("security log: %w", err)
+	}
+	for _, event := range events {
+		entry := export.Event{
+			Action:    event.Action,
+			CreatedAt: event.CreatedAt,
+			Metadata:  event.Metadata,
+		}
+		if event.ActorID.Valid {
+			entry.ActorID = event.ActorID.UUID.String()
+		}
+		if event.TargetID.Valid {
+			entry.TargetID = event.TargetID.UUID.String()
+		}
+		// Where the request came from is the actor's data. When that was
+		// someone else, such as an admin, it stays out of the export.
+		if event.ActorID.UUID == user.ID {
+			entry.IP = event.Ip
+			entry.UserAgent = event.UserAgent
+		}
+		archive.SecurityLog = append(archive.SecurityLog, entry)
+	}
+	return user, archive, nil
+}
+
+func nullTimePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+// buildAccountExport writes a claimed export's archive, marks it ready and
+// emails the user a link to it.
+func (cfg *ApiConfig) buildAccountExport(ctx context.Context, accountExport database.AccountExport) error {
+	user, archive, err := cfg.collectAccountData(ctx, accountExport.UserID)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := archive.Write(&buf); err != nil {
+		return err
+	}
+	if err := cfg.DBQueries.SaveAccountExportArchive(ctx, database.SaveAccountExportArchiveParams{
+		ExportID: accountExport.ID,
+		Data:     buf.Bytes(),
+	}); err != nil {
+		return err
+	}
+	expiresAt := time.Now().Add(ExportRetention)
+	if err := cfg.DBQueries.FinishAccountExport(ctx, database.FinishAccountExportParams{
+		ID:        accountExport.ID,
+		Status:    exportStatusReady,
+		ExpiresAt: sql.NullTime{Time: expiresAt, Valid: true},
+	}); err != nil {
+		return err
+	}
+	accountExport.Status = exportStatusReady
+	accountExport.ExpiresAt = sql.NullTime{Time: expiresAt, Valid: true}
+	link, err := cfg.exportDownloadURL(accountExport)
+	if err != nil {
+		return err
+	}
+	cfg.sendMail(mail.Message{
+		To:      user.Email,
+		Subject: "Your Chirpy data export is ready",
+		Body: fmt.Sprintf("The copy of your Chirpy data you asked for is ready. Download it within the next 24 hours:\n\n%s\n\n"+
+			"After that, sign in to get a new link. The export is deleted after 7 days.", link),
+	})
+	return nil
+}
+
+// buildAccountExports builds queued exports until none are left. Builds
+// claim their export first, so instances can share the queue.
+func (cfg *ApiConfig) buildAccountExports(ctx context.Context) {
+	for {
+		accountExport, err := cfg.DBQueries.ClaimAccountExport(ctx, time.Now().Add(-exportStaleAfter))
+		if errors.Is(err, sql.ErrNoRows) {
+			return
+		}
+		if err != nil {
+			log.Printf("claiming account export: %v", err)
+			return
+		}
+		if err := cfg.buildAccountExport(ctx, accountExport); err != nil {
+			log.Printf("building account export %s: %v", accountExport.ID, err)
+			// Failed exports are cleaned up like finished ones.
+			failParams := database.FinishAccountExportParams{
+				ID:        accountExport.ID,
+				Status:    exportStatusFailed,
+				ExpiresAt: sql.NullTime{Time: time.Now().Add(ExportRetention), Valid: true},
+			}
+			if err := cfg.DBQueries.FinishAccountExport(ctx, failParams); err != nil {
+				log.Printf("failing account export %s: %v", accountExport.ID, err)
+			}
+		}
+	}
+}
+
+// watchExports picks up exports left queued, for instance by a restart, and
+// deletes expired archives.
+func (cfg *ApiConfig) watchExports(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		ctx := context.Background()
+		cfg.buildAccountExports(ctx)
+		if err := cfg.DBQueries.DeleteExpiredAccountExports(ctx); err != nil {
+			log.Printf("deleting expired account exports: %v", err)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/charlesaraya/chirpy/internal/database"
+	"github.com/charlesaraya/chirpy/internal/export"
+	"github.com/charlesaraya/chirpy/internal/spam"
+	"github.com/google/uuid"
+)
+
+// An export archive can be imported into an account, on this instance or
+// another one. Chirps are recreated with their original timestamps; the
+// rest of an archive describes this account's sessions, tokens and logins
+// where it was exported, which mean nothing here.
+const (
+	MaxImportBytes int64 = 32 << 20
+	// maxImportExpandedBytes bounds how much of an archive is decompressed.
+	// JSON compresses well, so it allows for some expansion, but not for a
+	// small upload that inflates into an enormous one.
+	maxImportExpandedBytes int64 = 2 * MaxImportBytes
+	// importClockSkew is how far in the future an imported chirp may be
+	// dated, for archives from a server whose clock runs ahead.
+	importClockSkew time.Duration = 5 * time.Minute
+)
+
+const (
+	ErrorInvalidArchive string = "Not a Chirpy export archive"
+	ErrorArchiveTooBig  string = "Archive is too large"
+)
+
+// importNamespace derives the ID of an imported chirp from the account it
+// goes into and its ID in the archive, so importing the same archive again
+// finds the chirps it already created.
+var importNamespace = uuid.MustParse("6c1d4f0e-2b7a-4c3e-9a58-3f0d2e9b7c41")
+
+// notImported names the archive sections an import leaves out.
+var notImported = []string{
+	export.ProfileFile,
+	export.SessionsFile,
+	export.IdentitiesFile,
+	export.AccessTokensFile,
+	export.AppsFile,
+	export.SecurityLogFile,
+}
+
+// ReadImportArchive reads the parts of an export archive that an import
+// uses: the manifest and the chirps.
+func ReadImportArchive(r io.ReaderAt, size int64) (*export.Archive, error) {
+	return export.Read(r, size, maxImportExpandedBytes, export.ChirpsFile)
+}
+
+// ImportReport says what an import did, or would do on a dry run.
+type ImportReport struct {
+	DryRun      bool             `json:"dry_run"`
+	Chirps      ImportCounts     `json:"chirps"`
+	Conflicts   []ImportConflict `json:"conflicts"`
+	NotImported []string         `json:"not_imported"`
+}
+
+type ImportCounts struct {
+	Total          int `json:"total"`
+	Imported       int `json:"imported"`
+	AlreadyPresent int `json:"already_present"`
+	Held           int `json:"held"`
+	Conflicts      int `json:"conflicts"`
+}
+
+// ImportConflict is an archive entry that cannot be imported.
+type ImportConflict struct {
+	File   string `json:"file"`
+	ID     string `json:"id"`
+	Reason string `json:"reason"`
+}
+
+// importedChirpID is the ID a chirp from an archive gets in an account.
+func importedChirpID(userID uuid.UUID, archiveID string) uuid.UUID {
+	return uuid.NewSHA1(importNamespace, []byte(userID.String()+"/"+archiveID))
+}
+
+// chirpImportConflict explains why an archived chirp cannot be imported, or
+// returns "" if it can.
+func chirpImportConflict(chirp export.Chirp, now time.Time) string {
+	switch {
+	case chirp.ID == "":
+		return "missing id"
+	case chirp.Body == "":
+		return "empty body"
+	case len(chirp.Body) > MaxChirpLen:
+		return fmt.Sprintf("longer than %d characters", MaxChirpLen)
+	case chirp.CreatedAt.IsZero():
+		return "missing created_at"
+	case chirp.CreatedAt.After(now.Add(importClockSkew)):
+		return "created_at is in the future"
+	case chirp.Status == chirpStatusRejected:
+		return "rejected by moderators where it was exported"
+	case chirp.Status != chirpStatusPublished && chirp.Status != chirpStatusHeld:
+		return fmt.Sprintf("unknown status %q", chirp.Status)
+	}
+	return ""
+}
+
+// chirpAlreadyPresent reports whether the user already has an archived
+// chirp: because the archive came from this instance, or because it was
+// imported before.
+func (cfg *ApiConfig) chirpAlreadyPresent(ctx context.Context, userID uuid.UUID, chirp export.Chirp) (bool, error) {
+	ids := []uuid.UUID{importedChirpID(userID, chirp.ID)}
+	if id, err := uuid.Parse(chirp.ID); err == nil {
+		ids = append(ids, id)
+	}
+	for _, id := range ids {
+		existing, err := cfg.DBQueries.GetSingleChirp(ctx, id)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		if existing.UserID == userID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ImportArchive recreates an archive's chirps in the user's account. It can
+// be repeated: chirps the account already has are counted and left alone.
+// Archives are made by users, so everything in them is checked as if it were
+// posted now: chirps the spam filter flags are held for review and the rest
+// are published, even if they were held where the archive came from. A dry
+// run reports the same without changing anything.
+func (cfg *ApiConfig) ImportArchive(ctx context.Context, user database.User, archive *export.Archive, dryRun bool) (ImportReport, error) {
+	report := ImportReport{
+		DryRun:      dryRun,
+		Conflicts:   []ImportConflict{},
+		NotImported: notImported,
+	}
+	report.Chirps.Total = len(archive.Chirps)
+	now := time.Now()
+	seen := make(map[string]bool, len(archive.Chirps))
+	for _, chirp := range archive.Chirps {
+		reason := chirpImportConflict(chirp, now)
+		if reason == "" && seen[chirp.ID] {
+			reason = "appears more than once in the archive"
+		}
+		seen[chirp.ID] = true
+		if reason != "" {
+			report.Chirps.Conflicts++
+			report.Conflicts = append(report.Conflicts, ImportConflict{File: export.ChirpsFile, ID: chirp.ID, Reason: reason})
+			continue
+		}
+		present, err := cfg.chirpAlreadyPresent(ctx, user.ID, chirp)
+		if err != nil {
+			return ImportReport{}, err
+		}
+		if present {
+			report.Chirps.AlreadyPresent++
+			continue
+		}
+		// The author's recent chirps are left out of the score: an import
+		// is a burst of posts by design.
+		spamResult := spam.Score(cfg.SpamConfig, spam.Signals{
+			Body:           chirp.Body,
+			AccountCreated: user.CreatedAt,
+			Now:            now,
+		})
+		status := chirpStatusPublished
+		if spamResult.IsSpam(cfg.SpamConfig) {
+			status = chirpStatusHeld
+		}
+		if !dryRun {
+			id := importedChirpID(user.ID, chirp.ID)
+			updatedAt := chirp.UpdatedAt
+			if updatedAt.Before(chirp.CreatedAt) {
+				updatedAt = chirp.CreatedAt
+			}
+			inserted, err := cfg.importChirp(ctx, database.ImportChirpParams{
+				ID:        id,
+				UserID:    user.ID,
+				CreatedAt: chirp.CreatedAt,
+				UpdatedAt: updatedAt,
+				Body:      chirp.Body,
+				Status:    status,
+			}, spamResult)
+			if err != nil {
+				return ImportReport{}, err
+			}
+			if inserted == 0 {
+				// A concurrent import of the same archive got there first.
+				report.Chirps.AlreadyPresent++
+				continue
+			}
+		}
+		report.Chirps.Imported++
+		if status == chirpStatusHeld {
+			report.Chirps.Held++
+		}
+	}
+	return report, nil
+}
+
+// importChirp stores an imported chirp along with its spam score, if it has
+// one, in one transaction, as createChirp does. It reports how many chirps
+// were inserted: none if the chirp already exists.
+func (cfg *ApiConfig) importChirp(ctx context.Context, params database.ImportChirpParams, result spam.Result) (int64, error) {
+	var inserted int64
+	err := cfg.inTx(ctx, func(queries *database.Queries) error {
+		var err error
+		inserted, err = queries.ImportChirp(ctx, params)
+		if err != nil || inserted == 0 || result.Score <= 0 {
+			return err
+		}
+		_, err = queries.CreateChirpSpamScore(ctx, database.CreateChirpSpamScoreParams{
+			ChirpID: params.ID,
+			Score:   result.Score,
+			Reasons: result.Reasons,
+		})
+		return err
+	})
+	return inserted, err
+}
+
+// ImportAccountHandler imports an export archive, sent as the request body,
+// into the signed-in user's account. With ?dry_run=true it only reports
+// what would be imported and what conflicts.
+func ImportAccountHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		user, err := apiCfg.authenticate(req)
+		if err != nil {
+			respondWithAuthError(res, err)
+			return
+		}
+		if apiCfg.RequireVerifiedEmail && !user.EmailVerifiedAt.Valid {
+			http.Error(res, ErrorEmailNotVerified, http.StatusForbidden)
+			return
+		}
+		data, err := io.ReadAll(http.MaxBytesReader(res, req.Body, MaxImportBytes))
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			http.Error(res, ErrorArchiveTooBig, http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			http.Error(res, ErrorSomethingWentWrong, http.StatusBadRequest)
+			return
+		}
+		archive, err := ReadImportArchive(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			http.Error(res, ErrorInvalidArchive+": "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		dryRun := req.URL.Query().Get("dry_run") == "true"
+		report, err := apiCfg.ImportArchive(req.Context(), user, archive, dryRun)
+		if err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		if !dryRun {
+			apiCfg.recordAudit(req, auditAccountImported, user.ID, user.ID, map[string]any{
+				"source_user_id": archive.Manifest.UserID,
+				"imported":       report.Chirps.Imported,
+				"conflicts":      report.Chirps.Conflicts,
+			})
+		}
+		respondWithJSON(res, http.StatusOK, report)
+	}
+}
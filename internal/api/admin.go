@@ -0,0 +1,487 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charlesaraya/chirpy/internal/auth"
+	"github.com/charlesaraya/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+type adminUserPayload struct {
+	ID                    string `json:"id"`
+	CreatedAt             string `json:"created_at"`
+	UpdatedAt             string `json:"updated_at"`
+	Email                 string `json:"email"`
+	IsChirpyRed           bool   `json:"is_chirpy_red"`
+	SuspendedAt           string `json:"suspended_at,omitempty"`
+	SuspensionReason      string `json:"suspension_reason,omitempty"`
+	IsShadowbanned        bool   `json:"is_shadowbanned"`
+	Role                  string `json:"role"`
+	PasswordResetRequired bool   `json:"password_reset_required"`
+	PendingApproval       bool   `json:"pending_approval"`
+}
+
+func newAdminUserPayload(user database.User) adminUserPayload {
+	payload := adminUserPayload{
+		ID:                    user.ID.String(),
+		CreatedAt:             user.CreatedAt.Format(TimeFormat),
+		UpdatedAt:             user.UpdatedAt.Format(TimeFormat),
+		Email:                 user.Email,
+		IsChirpyRed:           user.IsChirpyRed,
+		SuspensionReason:      user.SuspensionReason.String,
+		IsShadowbanned:        user.IsShadowbanned,
+		Role:                  user.Role,
+		PasswordResetRequired: user.PasswordResetRequired,
+		PendingApproval:       user.PendingApproval,
+	}
+	if user.SuspendedAt.Valid {
+		payload.SuspendedAt = user.SuspendedAt.Time.Format(TimeFormat)
+	}
+	return payload
+}
+
+const (
+	defaultPageLimit int32 = 50
+	maxPageLimit     int32 = 100
+)
+
+// parsePagination reads the limit and offset query parameters, falling back
+// to the first page of defaultPageLimit items.
+func parsePagination(req *http.Request) (int32, int32, error) {
+	limit, offset := defaultPageLimit, int32(0)
+	if raw := req.URL.Query().Get("limit"); raw != "" {
+		n, err := strconv.ParseInt(raw, 10, 32)
+		if err != nil || n <= 0 {
+			return 0, 0, errors.New("invalid limit")
+		}
+		limit = min(int32(n), maxPageLimit)
+	}
+	if raw := req.URL.Query().Get("offset"); raw != "" {
+		n, err := strconv.ParseInt(raw, 10, 32)
+		if err != nil || n < 0 {
+			return 0, 0, errors.New("invalid offset")
+		}
+		offset = int32(n)
+	}
+	return limit, offset, nil
+}
+
+func parseNullTime(raw string) (sql.NullTime, error) {
+	if raw == "" {
+		return sql.NullTime{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return sql.NullTime{}, err
+	}
+	return sql.NullTime{Time: t, Valid: true}, nil
+}
+
+// ListUsersHandler searches users by email substring, creation date range
+// and Chirpy Red status.
+func ListUsersHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+		limit, offset, err := parsePagination(req)
+		if err != nil {
+			http.Error(res, ErrorSomethingWentWrong, http.StatusBadRequest)
+			return
+		}
+		searchParams := database.SearchUsersParams{
+			PageLimit:  limit,
+			PageOffset: offset,
+		}
+		if email := query.Get("email"); email != "" {
+			escaper := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+			searchParams.Email = sql.NullString{String: "%" + escaper.Replace(email) + "%", Valid: true}
+		}
+		if searchParams.CreatedAfter, err = parseNullTime(query.Get("created_after")); err != nil {
+			http.Error(res, ErrorSomethingWentWrong, http.StatusBadRequest)
+			return
+		}
+		if searchParams.CreatedBefore, err = parseNullTime(query.Get("created_before")); err != nil {
+			http.Error(res, ErrorSomethingWentWrong, http.StatusBadRequest)
+			return
+		}
+		if raw := query.Get("is_chirpy_red"); raw != "" {
+			isChirpyRed, err := strconv.ParseBool(raw)
+			if err != nil {
+				http.Error(res, ErrorSomethingWentWrong, http.StatusBadRequest)
+				return
+			}
+			searchParams.IsChirpyRed = sql.NullBool{Bool: isChirpyRed, Valid: true}
+		}
+		users, err := apiCfg.DBQueries.SearchUsers(req.Context(), searchParams)
+		if err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		payload := make([]adminUserPayload, len(users))
+		for i, user := range users {
+			payload[i] = newAdminUserPayload(user)
+		}
+		respondWithJSON(res, http.StatusOK, payload)
+	}
+}
+
+func GetUserHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		userUUID, err := uuid.Parse(req.PathValue("userID"))
+		if err != nil {
+			http.Error(res, ErrorNotFound, http.StatusNotFound)
+			return
+		}
+		user, err := apiCfg.DBQueries.GetUserByID(req.Context(), userUUID)
+		if err != nil {
+			http.Error(res, ErrorNotFound, http.StatusNotFound)
+			return
+		}
+		respondWithJSON(res, http.StatusOK, newAdminUserPayload(user))
+	}
+}
+
+// GetUserChirpsHandler lists every chirp of a user, including held and
+// rejected ones.
+func GetUserChirpsHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		userUUID, err := uuid.Parse(req.PathValue("userID"))
+		if err != nil {
+			http.Error(res, ErrorNotFound, http.StatusNotFound)
+			return
+		}
+		chirps, err := apiCfg.DBQueries.GetChirpsFromUser(req.Context(), userUUID)
+		if err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		payload := make([]chirpPayload, len(chirps))
+		for i, chirp := range chirps {
+			payload[i] = chirpPayload{
+				ID:        chirp.ID.String(),
+				CreatedAt: chirp.CreatedAt.Format(TimeFormat),
+				UpdatedAt: chirp.UpdatedAt.Format(TimeFormat),
+				UserID:    chirp.UserID.String(),
+				Body:      chirp.Body,
+				Status:    chirp.Status,
+			}
+		}
+		respondWithJSON(res, http.StatusOK, payload)
+	}
+}
+
+type sessionPayload struct {
+	ID        string `json:"id"`
+	FamilyID  string `json:"family_id"`
+	UserAgent string `json:"user_agent"`
+	IP        string `json:"ip"`
+	CreatedAt string `json:"created_at"`
+	ExpiresAt string `json:"expires_at"`
+	RotatedAt string `json:"rotated_at,omitempty"`
+	RevokedAt string `json:"revoked_at,omitempty"`
+}
+
+// GetUserSessionsHandler lists a user's refresh tokens. Tokens are stored
+// hashed, so only their metadata is shown.
+func GetUserSessionsHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		userUUID, err := uuid.Parse(req.PathValue("userID"))
+		if err != nil {
+			http.Error(res, ErrorNotFound, http.StatusNotFound)
+			return
+		}
+		tokens, err := apiCfg.DBQueries.GetUserRefreshTokens(req.Context(), userUUID)
+		if err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		payload := make([]sessionPayload, len(tokens))
+		for i, token := range tokens {
+			payload[i] = sessionPayload{
+				ID:        token.ID.String(),
+				FamilyID:  token.FamilyID.String(),
+				UserAgent: token.UserAgent,
+				IP:        token.Ip,
+				CreatedAt: token.CreatedAt.Format(TimeFormat),
+				ExpiresAt: token.ExpiresAt.Format(TimeFormat),
+			}
+			if token.RotatedAt.Valid {
+				payload[i].RotatedAt = token.RotatedAt.Time.Format(TimeFormat)
+			}
+			if token.RevokedAt.Valid {
+				payload[i].RevokedAt = token.RevokedAt.Time.Format(TimeFormat)
+			}
+		}
+		respondWithJSON(res, http.StatusOK, payload)
+	}
+}
+
+// ForcePasswordResetHandler signs the user out everywhere and makes them
+// change their password before they can use the API again.
+func ForcePasswordResetHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		userUUID, err := uuid.Parse(req.PathValue("userID"))
+		if err != nil {
+			http.Error(res, ErrorNotFound, http.StatusNotFound)
+			return
+		}
+		user, err := apiCfg.DBQueries.RequirePasswordReset(req.Context(), userUUID)
+		if err != nil {
+			http.Error(res, ErrorNotFound, http.StatusNotFound)
+			return
+		}
+		if err := apiCfg.signOutEverywhere(req.Context(), user.ID); err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		respondWithJSON(res, http.StatusOK, newAdminUserPayload(user))
+	}
+}
+
+func SetChirpyRedHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		type reqPayload struct {
+			IsChirpyRed bool `json:"is_chirpy_red"`
+		}
+		userUUID, err := uuid.Parse(req.PathValue("userID"))
+		if err != nil {
+			http.Error(res, ErrorNotFound, http.StatusNotFound)
+			return
+		}
+		params := reqPayload{}
+		decoder := json.NewDecoder(req.Body)
+		if err := decoder.Decode(&params); err != nil {
+			http.Error(res, ErrorSomethingWentWrong, http.StatusBadRequest)
+			return
+		}
+		chirpyRedParams := database.SetChirpyRedParams{
+			ID:          userUUID,
+			IsChirpyRed: params.IsChirpyRed,
+		}
+		user, err := apiCfg.DBQueries.SetChirpyRed(req.Context(), chirpyRedParams)
+		if err != nil {
+			http.Error(res, ErrorNotFound, http.StatusNotFound)
+			return
+		}
+		respondWithJSON(res, http.StatusOK, newAdminUserPayload(user))
+	}
+}
+
+func DeleteUserHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		userUUID, err := uuid.Parse(req.PathValue("userID"))
+		if err != nil {
+			http.Error(res, ErrorNotFound, http.StatusNotFound)
+			return
+		}
+		rowsAffected, err := apiCfg.DBQueries.DeleteUser(req.Context(), userUUID)
+		if err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		if rowsAffected == 0 {
+			http.Error(res, ErrorNotFound, http.StatusNotFound)
+			return
+		}
+		res.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// SuspendUserHandler blocks a user from logging in or using the API and
+// revokes their outstanding tokens.
+func SuspendUserHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		type reqPayload struct {
+			Reason string `json:"reason"`
+		}
+		userUUID, err := uuid.Parse(req.PathValue("userID"))
+		if err != nil {
+			http.Error(res, ErrorNotFound, http.StatusNotFound)
+			return
+		}
+		params := reqPayload{}
+		decoder := json.NewDecoder(req.Body)
+		if err := decoder.Decode(&params); err != nil {
+			http.Error(res, ErrorSomethingWentWrong, http.StatusBadRequest)
+			return
+		}
+		suspendParams := database.SuspendUserParams{
+			ID:               userUUID,
+			SuspensionReason: sql.NullString{String: params.Reason, Valid: params.Reason != ""},
+		}
+		user, err := apiCfg.DBQueries.SuspendUser(req.Context(), suspendParams)
+		if err != nil {
+			http.Error(res, ErrorNotFound, http.StatusNotFound)
+			return
+		}
+		if err := apiCfg.signOutEverywhere(req.Context(), user.ID); err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		respondWithJSON(res, http.StatusOK, newAdminUserPayload(user))
+	}
+}
+
+func UnsuspendUserHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		userUUID, err := uuid.Parse(req.PathValue("userID"))
+		if err != nil {
+			http.Error(res, ErrorNotFound, http.StatusNotFound)
+			return
+		}
+		user, err := apiCfg.DBQueries.UnsuspendUser(req.Context(), userUUID)
+		if err != nil {
+			http.Error(res, ErrorNotFound, http.StatusNotFound)
+			return
+		}
+		respondWithJSON(res, http.StatusOK, newAdminUserPayload(user))
+	}
+}
+
+// ShadowbanUserHandler toggles whether a user's chirps are hidden from
+// everyone but themselves.
+func ShadowbanUserHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		type reqPayload struct {
+			Shadowbanned bool `json:"shadowbanned"`
+		}
+		userUUID, err := uuid.Parse(req.PathValue("userID"))
+		if err != nil {
+			http.Error(res, ErrorNotFound, http.StatusNotFound)
+			return
+		}
+		params := reqPayload{}
+		decoder := json.NewDecoder(req.Body)
+		if err := decoder.Decode(&params); err != nil {
+			http.Error(res, ErrorSomethingWentWrong, http.StatusBadRequest)
+			return
+		}
+		shadowbanParams := database.SetUserShadowbanParams{
+			ID:             userUUID,
+			IsShadowbanned: params.Shadowbanned,
+		}
+		user, err := apiCfg.DBQueries.SetUserShadowban(req.Context(), shadowbanParams)
+		if err != nil {
+			http.Error(res, ErrorNotFound, http.StatusNotFound)
+			return
+		}
+		respondWithJSON(res, http.StatusOK, newAdminUserPayload(user))
+	}
+}
+
+// SetUserRoleHandler grants a user the user, moderator or admin role. Callers
+// can only hand out roles below their own, so admins cannot make other
+// admins.
+func SetUserRoleHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		type reqPayload struct {
+			Role string `json:"role"`
+		}
+		caller, err := apiCfg.authenticate(req)
+		if err != nil {
+			respondWithAuthError(res, err)
+			return
+		}
+		userUUID, err := uuid.Parse(req.PathValue("userID"))
+		if err != nil {
+			http.Error(res, ErrorNotFound, http.StatusNotFound)
+			return
+		}
+		params := reqPayload{}
+		decoder := json.NewDecoder(req.Body)
+		if err := decoder.Decode(&params); err != nil || !auth.IsValidRole(params.Role) {
+			http.Error(res, ErrorSomethingWentWrong, http.StatusBadRequest)
+			return
+		}
+		if !auth.Outranks(caller.Role, params.Role) {
+			http.Error(res, ErrorForbidden, http.StatusForbidden)
+			return
+		}
+		roleParams := database.SetUserRoleParams{
+			ID:   userUUID,
+			Role: params.Role,
+		}
+		user, err := apiCfg.DBQueries.SetUserRole(req.Context(), roleParams)
+		if err != nil {
+			http.Error(res, ErrorNotFound, http.StatusNotFound)
+			return
+		}
+		respondWithJSON(res, http.StatusOK, newAdminUserPayload(user))
+	}
+}
+
+type heldChirpPayload struct {
+	chirpPayload
+	SpamScore   float64  `json:"spam_score"`
+	SpamReasons []string `json:"spam_reasons"`
+}
+
+// GetHeldChirpsHandler lists chirps held back by the spam filter along with
+// the score and reasons that held them.
+func GetHeldChirpsHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		chirps, err := apiCfg.DBQueries.GetHeldChirps(req.Context())
+		if err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		payload := make([]heldChirpPayload, len(chirps))
+		for i, chirp := range chirps {
+			payload[i] = heldChirpPayload{
+				chirpPayload: chirpPayload{
+					ID:        chirp.ID.String(),
+					CreatedAt: chirp.CreatedAt.Format(TimeFormat),
+					UpdatedAt: chirp.UpdatedAt.Format(TimeFormat),
+					UserID:    chirp.UserID.String(),
+					Body:      chirp.Body,
+					Status:    chirp.Status,
+				},
+				SpamScore:   chirp.Score,
+				SpamReasons: chirp.Reasons,
+			}
+		}
+		respondWithJSON(res, http.StatusOK, payload)
+	}
+}
+
+func ApproveChirpHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return setChirpStatusHandler(apiCfg, chirpStatusPublished)
+}
+
+func RejectChirpHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return setChirpStatusHandler(apiCfg, chirpStatusRejected)
+}
+
+func setChirpStatusHandler(apiCfg *ApiConfig, status string) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		chirpID, err := uuid.Parse(req.PathValue("chirpID"))
+		if err != nil {
+			http.Error(res, ErrorNotFound, http.StatusNotFound)
+			return
+		}
+		statusParams := database.SetChirpStatusParams{
+			ID:     chirpID,
+			Status: status,
+		}
+		chirp, err := apiCfg.DBQueries.SetChirpStatus(req.Context(), statusParams)
+		if err != nil {
+			http.Error(res, ErrorNotFound, http.StatusNotFound)
+			return
+		}
+		payload := chirpPayload{
+			ID:        chirp.ID.String(),
+			CreatedAt: chirp.CreatedAt.Format(TimeFormat),
+			UpdatedAt: chirp.UpdatedAt.Format(TimeFormat),
+			UserID:    chirp.UserID.String(),
+			Body:      chirp.Body,
+			Status:    chirp.Status,
+		}
+		respondWithJSON(res, http.StatusOK, payload)
+	}
+}
@@ -0,0 +1,238 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/charlesaraya/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	auditLoginSucceeded     string = "login.succeeded"
+	auditLoginFailed        string = "login.failed"
+	auditLoginLocked        string = "login.locked"
+	auditMagicLinkRequested string = "login.magic_link_requested"
+	auditMagicLinkLimited   string = "login.magic_link_limited"
+	auditDeletionRequested  string = "user.deletion_requested"
+	auditDeletionCancelled  string = "user.deletion_cancelled"
+	auditUserErased         string = "user.erased"
+	auditExportRequested    string = "user.export_requested"
+	auditAccountImported    string = "user.imported"
+	auditEmailChanged       string = "user.email_changed"
+	auditEmailChangeAsked   string = "user.email_change_requested"
+	auditEmailVerified      string = "user.email_verified"
+	auditIdentityLinked     string = "user.identity_linked"
+	auditInviteCreated      string = "user.invite_created"
+	auditInviteRevoked      string = "user.invite_revoked"
+	auditInviteUsed         string = "user.invite_used"
+	auditPasswordChanged    string = "user.password_changed"
+	auditPasswordReset      string = "user.password_reset"
+	auditPasswordResetAsked string = "user.password_reset_requested"
+	auditResetLinkLimited   string = "user.password_reset_limited"
+	auditTokenRefreshed     string = "token.refreshed"
+	auditTokenRevoked       string = "token.revoked"
+	auditTokenReuseDetected string = "token.reuse_detected"
+	auditAccessTokenCreated string = "token.pat_created"
+	auditAccessTokenRevoked string = "token.pat_revoked"
+	auditOAuthClientCreated string = "oauth.client_created"
+	auditOAuthClientDeleted string = "oauth.client_deleted"
+	auditOAuthAuthorized    string = "oauth.authorized"
+	auditOAuthRevoked       string = "oauth.revoked"
+	auditOAuthCodeReused    string = "oauth.code_reused"
+	auditDeviceAuthorized   string = "oauth.device_authorized"
+	auditSessionRevoked     string = "session.revoked"
+	auditSessionsRevoked    string = "session.revoked_all"
+	auditLogout             string = "session.logged_out"
+	auditMFAEnabled         string = "mfa.enabled"
+	auditMFADisabled        string = "mfa.disabled"
+	auditMFACodesReset      string = "mfa.recovery_codes_regenerated"
+	auditChirpDeleted       string = "chirp.deleted"
+	auditWebhookUpgrade     string = "webhook.user_upgraded"
+	auditAdminAction        string = "admin.action"
+)
+
+const (
+	auditExportPageSize    int32  = 500
+	auditExportContentType string = "application/x-ndjson"
+)
+
+type auditEventPayload struct {
+	ID        string          `json:"id"`
+	CreatedAt string          `json:"created_at"`
+	Action    string          `json:"action"`
+	ActorID   string          `json:"actor_id,omitempty"`
+	TargetID  string          `json:"target_id,omitempty"`
+	IP        string          `json:"ip"`
+	UserAgent string          `json:"user_agent"`
+	Metadata  json.RawMessage `json:"metadata"`
+}
+
+func newAuditEventPayload(event database.AuditEvent) auditEventPayload {
+	payload := auditEventPayload{
+		ID:        event.ID.String(),
+		CreatedAt: event.CreatedAt.Format(TimeFormat),
+		Action:    event.Action,
+		IP:        event.Ip,
+		UserAgent: event.UserAgent,
+		Metadata:  event.Metadata,
+	}
+	if event.ActorID.Valid {
+		payload.ActorID = event.ActorID.UUID.String()
+	}
+	if event.TargetID.Valid {
+		payload.TargetID = event.TargetID.UUID.String()
+	}
+	return payload
+}
+
+// recordAudit appends a security-relevant event to the audit log. Pass
+// uuid.Nil for an unknown actor or target. Failures are logged rather than
+// failing the request that triggered them.
+func (cfg *ApiConfig) recordAudit(req *http.Request, action string, actorID, targetID uuid.UUID, metadata map[string]any) {
+	cfg.writeAuditEvent(req.Context(), action, actorID, targetID, clientIP(req), req.UserAgent(), metadata)
+}
+
+// recordSystemAudit is recordAudit for events that background jobs cause,
+// which have no actor, IP address or user agent.
+func (cfg *ApiConfig) recordSystemAudit(ctx context.Context, action string, targetID uuid.UUID, metadata map[string]any) {
+	cfg.writeAuditEvent(ctx, action, uuid.Nil, targetID, "", "", metadata)
+}
+
+func (cfg *ApiConfig) writeAuditEvent(ctx context.Context, action string, actorID, targetID uuid.UUID, ip, userAgent string, metadata map[string]any) {
+	if metadata == nil {
+		metadata = map[string]any{}
+	}
+	rawMetadata, err := json.Marshal(metadata)
+	if err != nil {
+		log.Printf("audit %s: encoding metadata: %v", action, err)
+		return
+	}
+	params := database.CreateAuditEventParams{
+		Action:    action,
+		ActorID:   uuid.NullUUID{UUID: actorID, Valid: actorID != uuid.Nil},
+		TargetID:  uuid.NullUUID{UUID: targetID, Valid: targetID != uuid.Nil},
+		Ip:        ip,
+		UserAgent: userAgent,
+		Metadata:  rawMetadata,
+	}
+	if err := cfg.DBQueries.CreateAuditEvent(ctx, params); err != nil {
+		log.Printf("audit %s: %v", action, err)
+	}
+}
+
+func clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// statusRecorder remembers the status code written by a wrapped handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// parseAuditFilters reads the audit query filters shared by the list and
+// export endpoints.
+func parseAuditFilters(req *http.Request) (database.GetAuditEventsParams, error) {
+	query := req.URL.Query()
+	params := database.GetAuditEventsParams{}
+	if action := query.Get("action"); action != "" {
+		params.Action.String, params.Action.Valid = action, true
+	}
+	for name, dest := range map[string]*uuid.NullUUID{"actor_id": &params.ActorID, "target_id": &params.TargetID} {
+		if raw := query.Get(name); raw != "" {
+			id, err := uuid.Parse(raw)
+			if err != nil {
+				return params, err
+			}
+			*dest = uuid.NullUUID{UUID: id, Valid: true}
+		}
+	}
+	var err error
+	if params.Since, err = parseNullTime(query.Get("since")); err != nil {
+		return params, err
+	}
+	if params.Until, err = parseNullTime(query.Get("until")); err != nil {
+		return params, err
+	}
+	return params, nil
+}
+
+// GetAuditEventsHandler lists audit events, newest first, filtered by
+// action, actor_id, target_id and a since/until time range.
+func GetAuditEventsHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		params, err := parseAuditFilters(req)
+		if err != nil {
+			http.Error(res, ErrorSomethingWentWrong, http.StatusBadRequest)
+			return
+		}
+		if params.PageLimit, params.PageOffset, err = parsePagination(req); err != nil {
+			http.Error(res, ErrorSomethingWentWrong, http.StatusBadRequest)
+			return
+		}
+		events, err := apiCfg.DBQueries.GetAuditEvents(req.Context(), params)
+		if err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		payload := make([]auditEventPayload, len(events))
+		for i, event := range events {
+			payload[i] = newAuditEventPayload(event)
+		}
+		respondWithJSON(res, http.StatusOK, payload)
+	}
+}
+
+// ExportAuditEventsHandler streams every matching audit event as JSON lines.
+// Events recorded after the export started are left out so paging stays
+// stable.
+func ExportAuditEventsHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		params, err := parseAuditFilters(req)
+		if err != nil {
+			http.Error(res, ErrorSomethingWentWrong, http.StatusBadRequest)
+			return
+		}
+		if !params.Until.Valid {
+			params.Until = sql.NullTime{Time: time.Now(), Valid: true}
+		}
+		params.PageLimit = auditExportPageSize
+		res.Header().Set("Content-Type", auditExportContentType)
+		res.Header().Set("Content-Disposition", `attachment; filename="audit_events.jsonl"`)
+		encoder := json.NewEncoder(res)
+		for {
+			events, err := apiCfg.DBQueries.GetAuditEvents(req.Context(), params)
+			if err != nil {
+				// Headers are gone by now on later pages; all we can do is stop.
+				if params.PageOffset == 0 {
+					http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+				}
+				return
+			}
+			for _, event := range events {
+				if err := encoder.Encode(newAuditEventPayload(event)); err != nil {
+					return
+				}
+			}
+			if int32(len(events)) < params.PageLimit {
+				return
+			}
+			params.PageOffset += params.PageLimit
+		}
+	}
+}
@@ -1,24 +1,58 @@
 package api
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"sync/atomic"
+	"time"
 
+	"github.com/charlesaraya/chirpy/internal/auth"
 	"github.com/charlesaraya/chirpy/internal/database"
+	"github.com/charlesaraya/chirpy/internal/mail"
+	"github.com/charlesaraya/chirpy/internal/oidc"
+	"github.com/charlesaraya/chirpy/internal/spam"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 )
 
+const (
+	defaultBaseURL  string        = "http://localhost:8080"
+	defaultMailFrom string        = "Chirpy <no-reply@localhost>"
+	mailSendTimeout time.Duration = 30 * time.Second
+)
+
 type ApiConfig struct {
 	ServerHits  atomic.Int32
+	DB          *sql.DB
 	DBQueries   *database.Queries
 	Platform    string
-	TokenSecret string
+	Keys        *auth.Keyring
 	PolkaApiKey string
+	SpamConfig  spam.Config
+	Denylist    auth.Denylist
+	Mailer      mail.Mailer
+	BaseURL     string
+	// RequireVerifiedEmail stops users posting chirps until they have
+	// confirmed their email address.
+	RequireVerifiedEmail bool
+	PasswordPolicy       auth.PasswordPolicy
+	PasswordHasher       auth.PasswordHasher
+	AccountLockout       auth.LockoutPolicy
+	IPLockout            auth.LockoutPolicy
+	MagicLinkLimit       auth.LockoutPolicy
+	MFALockout           auth.LockoutPolicy
+	// OIDCProviders are the identity providers users can sign in with,
+	// keyed by the name used in their login routes.
+	OIDCProviders map[string]*oidc.Provider
+	ErasurePolicy ErasurePolicy
+	Registration  RegistrationPolicy
 }
 
 func (cfg *ApiConfig) GetHits() int32 {
@@ -50,20 +84,188 @@ func Load() (*ApiConfig, error) {
 		return nil, errors.New("error opening the database")
 	}
 
-	return &ApiConfig{
+	// 1. Load JWT signing keys, falling back to TOKEN_SECRET
+	keys := auth.NewHMACKeyring(os.Getenv("TOKEN_SECRET"))
+	if path := os.Getenv("JWT_KEYS_FILE"); path != "" {
+		if keys, err = auth.LoadKeyring(path); err != nil {
+			return nil, fmt.Errorf("error loading jwt keys: %w", err)
+		}
+	}
+
+	// 2. Set up outgoing mail
+	mailer, err := loadMailer()
+	if err != nil {
+		return nil, fmt.Errorf("error setting up mail: %w", err)
+	}
+	baseURL := os.Getenv("APP_BASE_URL")
+	if baseURL == "" {
+		baseURL = defaultBaseURL
+	}
+
+	// 3. Set up the password policy
+	passwordPolicy := auth.DefaultPasswordPolicy()
+	if minLength := os.Getenv("PASSWORD_MIN_LENGTH"); minLength != "" {
+		if passwordPolicy.MinLength, err = strconv.Atoi(minLength); err != nil {
+			return nil, fmt.Errorf("error parsing PASSWORD_MIN_LENGTH: %w", err)
+		}
+	}
+
+	// 4. Set up password hashing
+	passwordHasher := auth.DefaultPasswordHasher()
+	if algorithm := os.Getenv("PASSWORD_HASH"); algorithm != "" {
+		if algorithm != auth.HashArgon2id && algorithm != auth.HashBcrypt {
+			return nil, fmt.Errorf("unknown PASSWORD_HASH %q", algorithm)
+		}
+		passwordHasher.Algorithm = algorithm
+	}
+	if pepper := os.Getenv("PASSWORD_PEPPER"); pepper != "" {
+		passwordHasher.Pepper = []byte(pepper)
+	}
+
+	// 5. Set up sign-in with an external identity provider
+	oidcProviders, err := loadOIDCProviders()
+	if err != nil {
+		return nil, fmt.Errorf("error setting up oidc: %w", err)
+	}
+
+	// 6. Set up what account deletion erases
+	erasurePolicy, err := loadErasurePolicy()
+	if err != nil {
+		return nil, err
+	}
+
+	// 7. Set up who may sign up
+	registration, err := loadRegistrationPolicy()
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &ApiConfig{
+		DB:          db,
 		DBQueries:   database.New(db),
 		Platform:    os.Getenv("PLATFORM"),
-		TokenSecret: os.Getenv("TOKEN_SECRET"),
+		Keys:        keys,
 		PolkaApiKey: os.Getenv("POLKA_API_KEY"),
-	}, nil
+		SpamConfig:  spam.DefaultConfig(),
+		Mailer:      mailer,
+		BaseURL:     strings.TrimSuffix(baseURL, "/"),
+
+		RequireVerifiedEmail: os.Getenv("REQUIRE_VERIFIED_EMAIL") == "true",
+		PasswordPolicy:       passwordPolicy,
+		PasswordHasher:       passwordHasher,
+		AccountLockout:       auth.DefaultAccountLockoutPolicy(),
+		IPLockout:            auth.DefaultIPLockoutPolicy(),
+		MagicLinkLimit:       auth.DefaultMagicLinkPolicy(),
+		MFALockout:           auth.DefaultMFALockoutPolicy(),
+		OIDCProviders:        oidcProviders,
+		ErasurePolicy:        erasurePolicy,
+		Registration:         registration,
+	}
+
+	// 8. Load revoked access tokens and keep them in sync
+	since, err := cfg.syncDenylist(context.Background(), time.Time{})
+	if err != nil {
+		log.Printf("loading access token denylist: %v", err)
+	}
+	go cfg.watchDenylist(since, denylistSyncInterval)
+
+	// 9. Erase deleted accounts once their grace period is over
+	go cfg.watchErasures(erasureInterval)
+
+	// 10. Build queued data exports and delete expired ones
+	go cfg.watchExports(exportInterval)
+
+	return cfg, nil
+}
+
+// loadMailer picks the mailer named by MAILER. The default "log" mailer
+// writes messages to MAIL_LOG_FILE, or stdout, instead of sending them.
+func loadMailer() (mail.Mailer, error) {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = defaultMailFrom
+	}
+	switch os.Getenv("MAILER") {
+	case "smtp":
+		return &mail.SMTPMailer{
+			Addr:     os.Getenv("SMTP_ADDR"),
+			From:     from,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+		}, nil
+	case "", "log":
+		path := os.Getenv("MAIL_LOG_FILE")
+		if path == "" {
+			return mail.NewLogMailer(from, os.Stdout), nil
+		}
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			return nil, err
+		}
+		return mail.NewLogMailer(from, file), nil
+	}
+	return nil, fmt.Errorf("unknown mailer %q", os.Getenv("MAILER"))
+}
+
+// loadOIDCProviders sets up the identity provider named by OIDC_PROVIDER,
+// if any, from OIDC_ISSUER, OIDC_CLIENT_ID and OIDC_CLIENT_SECRET.
+func loadOIDCProviders() (map[string]*oidc.Provider, error) {
+	providers := make(map[string]*oidc.Provider)
+	name := os.Getenv("OIDC_PROVIDER")
+	if name == "" {
+		return providers, nil
+	}
+	if strings.ContainsAny(name, "/?#:") {
+		return nil, fmt.Errorf("invalid OIDC_PROVIDER %q", name)
+	}
+	config := oidc.Config{
+		Name:         name,
+		Issuer:       os.Getenv("OIDC_ISSUER"),
+		ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+	}
+	if config.Issuer == "" || config.ClientID == "" {
+		return nil, errors.New("OIDC_ISSUER and OIDC_CLIENT_ID are required")
+	}
+	providers[name] = oidc.NewProvider(config, nil)
+	return providers, nil
 }
 
 type UserPayload struct {
-	ID           string `json:"id"`
-	CreatedAt    string `json:"created_at"`
-	UpdatedAt    string `json:"updated_at"`
-	Email        string `json:"email"`
-	IsChirpyRed  bool   `json:"is_chirpy_red"`
-	Token        string `json:"token"`
-	RefreshToken string `json:"refresh_token"`
+	ID                    string `json:"id"`
+	CreatedAt             string `json:"created_at"`
+	UpdatedAt             string `json:"updated_at"`
+	Email                 string `json:"email"`
+	IsChirpyRed           bool   `json:"is_chirpy_red"`
+	Token                 string `json:"token"`
+	RefreshToken          string `json:"refresh_token"`
+	PasswordResetRequired bool   `json:"password_reset_required,omitempty"`
+	EmailVerified         bool   `json:"email_verified"`
+	PendingEmail          string `json:"pending_email,omitempty"`
+	PendingApproval       bool   `json:"pending_approval,omitempty"`
+}
+
+// inTx runs fn in a transaction, committing it only if fn succeeds.
+func (cfg *ApiConfig) inTx(ctx context.Context, fn func(queries *database.Queries) error) error {
+	tx, err := cfg.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := fn(cfg.DBQueries.WithTx(tx)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// sendMail delivers msg in the background so response times do not reveal
+// whether an email was sent. Failures are logged.
+func (cfg *ApiConfig) sendMail(msg mail.Message) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), mailSendTimeout)
+		defer cancel()
+		if err := cfg.Mailer.Send(ctx, msg); err != nil {
+			log.Printf("sending %q mail: %v", msg.Subject, err)
+		}
+	}()
 }
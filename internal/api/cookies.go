@@ -0,0 +1,123 @@
+package api
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+
+	"github.com/charlesaraya/chirpy/internal/auth"
+)
+
+// Browsers can keep their session in cookies instead of handing tokens to
+// JavaScript. Logins made with the X-Session-Mode: cookie header answer with
+// HttpOnly cookies rather than tokens in the body. The CSRF cookie is
+// readable by scripts, which echo it in the X-CSRF-Token header of every
+// state-changing request; another site can neither read the cookie nor set
+// the header.
+const (
+	sessionModeHeader  string = "X-Session-Mode"
+	sessionModeCookie  string = "cookie"
+	accessTokenCookie  string = "chirpy_access"
+	refreshTokenCookie string = "chirpy_refresh"
+	csrfCookie         string = "chirpy_csrf"
+	csrfHeader         string = "X-CSRF-Token"
+	// refreshCookiePath keeps the refresh token off every request but the
+	// API's own.
+	refreshCookiePath string = "/api/"
+)
+
+const ErrorInvalidCSRFToken string = "Missing or invalid CSRF token"
+
+var errInvalidCSRFToken = errors.New("missing or mismatched csrf token")
+
+// wantsCookieSession reports whether a login asked for a cookie session.
+func wantsCookieSession(req *http.Request) bool {
+	return req.Header.Get(sessionModeHeader) == sessionModeCookie
+}
+
+// sessionToken returns the token a request authenticates with: the bearer
+// token when there is an Authorization header, and otherwise the named
+// cookie. fromCookie reports which, so responses can answer in kind. Cookies
+// on state-changing requests must come with a matching CSRF header.
+func sessionToken(req *http.Request, cookieName string) (token string, fromCookie bool, err error) {
+	if req.Header.Get("Authorization") != "" {
+		token, err := auth.GetBearerToken(req.Header)
+		if err != nil {
+			return "", false, errUnauthenticated
+		}
+		return token, false, nil
+	}
+	cookie, err := req.Cookie(cookieName)
+	if err != nil || cookie.Value == "" {
+		return "", false, errUnauthenticated
+	}
+	if err := checkCSRF(req); err != nil {
+		return "", true, err
+	}
+	return cookie.Value, true, nil
+}
+
+// checkCSRF checks the double-submitted CSRF token on requests that can
+// change state.
+func checkCSRF(req *http.Request) error {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return nil
+	}
+	cookie, err := req.Cookie(csrfCookie)
+	if err != nil || cookie.Value == "" {
+		return errInvalidCSRFToken
+	}
+	if subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(req.Header.Get(csrfHeader))) != 1 {
+		return errInvalidCSRFToken
+	}
+	return nil
+}
+
+// setSessionCookies hands a browser its tokens, along with a fresh CSRF
+// token. Secure cookies are still sent to http://localhost, so local
+// development works.
+func setSessionCookies(res http.ResponseWriter, accessToken, refreshToken string) error {
+	csrfToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		return err
+	}
+	http.SetCookie(res, &http.Cookie{
+		Name:     accessTokenCookie,
+		Value:    accessToken,
+		Path:     "/",
+		MaxAge:   int(MaxSessionDuration.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	http.SetCookie(res, &http.Cookie{
+		Name:     refreshTokenCookie,
+		Value:    refreshToken,
+		Path:     refreshCookiePath,
+		MaxAge:   int(RefreshTokenDuration.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	http.SetCookie(res, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    csrfToken,
+		Path:     "/",
+		MaxAge:   int(RefreshTokenDuration.Seconds()),
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return nil
+}
+
+// clearSessionCookies signs a browser out.
+func clearSessionCookies(res http.ResponseWriter) {
+	for _, cookie := range []struct{ name, path string }{
+		{accessTokenCookie, "/"},
+		{refreshTokenCookie, refreshCookiePath},
+		{csrfCookie, "/"},
+	} {
+		http.SetCookie(res, &http.Cookie{Name: cookie.name, Path: cookie.path, MaxAge: -1, Secure: true})
+	}
+}
@@ -0,0 +1,283 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/charlesaraya/chirpy/internal/auth"
+	"github.com/charlesaraya/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// The device flow (RFC 8628) signs in clients that cannot show a login
+// form, such as the terminal client. The device shows a short user code,
+// the user approves it on the verification page from a browser where they
+// are signed in, and the device polls the token endpoint until they have.
+const (
+	DeviceCodeDuration     time.Duration = 10 * time.Minute
+	deviceDefaultInterval  time.Duration = 5 * time.Second
+	deviceSlowDownStep     time.Duration = 5 * time.Second
+	deviceVerificationPage string        = "/app/device.html"
+	deviceCodeGrantType    string        = "urn:ietf:params:oauth:grant-type:device_code"
+	maxUserCodeAttempts    int           = 3
+)
+
+const (
+	deviceStatusPending  string = "pending"
+	deviceStatusApproved string = "approved"
+	deviceStatusDenied   string = "denied"
+	deviceStatusUsed     string = "used"
+)
+
+// Error codes from RFC 8628, section 3.5.
+const (
+	oauthAuthorizationPending string = "authorization_pending"
+	oauthSlowDown             string = "slow_down"
+	oauthExpiredToken         string = "expired_token"
+)
+
+const ErrorInvalidUserCode string = "Unknown or expired code"
+
+type deviceAuthorizationPayload struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+type deviceRequestPayload struct {
+	UserCode    string `json:"user_code"`
+	Device      string `json:"device"`
+	UserAgent   string `json:"user_agent"`
+	IP          string `json:"ip"`
+	RequestedAt string `json:"requested_at"`
+	ExpiresAt   string `json:"expires_at"`
+}
+
+// DeviceAuthorizationHandler starts the device flow, handing the device a
+// secret device code to poll with and a user code to show.
+func DeviceAuthorizationHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		if err := apiCfg.DBQueries.DeleteExpiredDeviceAuthorizations(req.Context()); err != nil {
+			log.Printf("deleting expired device authorizations: %v", err)
+		}
+		deviceCode, err := auth.MakeRefreshToken()
+		if err != nil {
+			respondWithOAuthError(res, http.StatusInternalServerError, oauthServerError, "")
+			return
+		}
+		// User codes are short enough to collide now and then.
+		var authorization database.DeviceAuthorization
+		for attempt := 0; ; attempt++ {
+			userCode, err := auth.MakeUserCode()
+			if err != nil {
+				respondWithOAuthError(res, http.StatusInternalServerError, oauthServerError, "")
+				return
+			}
+			authorization, err = apiCfg.DBQueries.CreateDeviceAuthorization(req.Context(), database.CreateDeviceAuthorizationParams{
+				DeviceCodeHash:  auth.HashToken(deviceCode),
+				UserCode:        userCode,
+				UserAgent:       req.UserAgent(),
+				Ip:              clientIP(req),
+				IntervalSeconds: int32(deviceDefaultInterval.Seconds()),
+				ExpiresAt:       time.Now().Add(DeviceCodeDuration),
+			})
+			if err == nil {
+				break
+			}
+			if !isUniqueViolation(err) || attempt == maxUserCodeAttempts-1 {
+				respondWithOAuthError(res, http.StatusInternalServerError, oauthServerError, "")
+				return
+			}
+		}
+		verificationURI := apiCfg.BaseURL + deviceVerificationPage
+		res.Header().Set("Cache-Control", "no-store")
+		respondWithJSON(res, http.StatusOK, deviceAuthorizationPayload{
+			DeviceCode:              deviceCode,
+			UserCode:                authorization.UserCode,
+			VerificationURI:         verificationURI,
+			VerificationURIComplete: verificationURI + "?user_code=" + url.QueryEscape(authorization.UserCode),
+			ExpiresIn:               int(DeviceCodeDuration.Seconds()),
+			Interval:                int(authorization.IntervalSeconds),
+		})
+	}
+}
+
+// exchangeDeviceCode answers a device polling the token endpoint. Until the
+// user decides it is told to keep waiting, and to slow down when it polls
+// faster than its interval, which then grows. Once approved it gets an
+// access and a refresh token, just like a login.
+func exchangeDeviceCode(res http.ResponseWriter, req *http.Request, apiCfg *ApiConfig) {
+	authorization, err := apiCfg.DBQueries.GetDeviceAuthorizationByDeviceCode(req.Context(), auth.HashToken(req.PostFormValue("device_code")))
+	if err != nil {
+		respondWithOAuthError(res, http.StatusBadRequest, oauthInvalidGrant, "unknown device_code")
+		return
+	}
+	if authorization.ExpiresAt.Before(time.Now()) {
+		respondWithOAuthError(res, http.StatusBadRequest, oauthExpiredToken, "")
+		return
+	}
+	switch authorization.Status {
+	case deviceStatusPending:
+		interval, tooFast := devicePollInterval(authorization, time.Now())
+		pollParams := database.PollDeviceAuthorizationParams{
+			ID:              authorization.ID,
+			IntervalSeconds: int32(interval.Seconds()),
+		}
+		if err := apiCfg.DBQueries.PollDeviceAuthorization(req.Context(), pollParams); err != nil {
+			respondWithOAuthError(res, http.StatusInternalServerError, oauthServerError, "")
+			return
+		}
+		if tooFast {
+			respondWithOAuthError(res, http.StatusBadRequest, oauthSlowDown, "")
+			return
+		}
+		respondWithOAuthError(res, http.StatusBadRequest, oauthAuthorizationPending, "")
+		return
+	case deviceStatusDenied:
+		respondWithOAuthError(res, http.StatusBadRequest, oauthAccessDenied, "")
+		return
+	case deviceStatusUsed:
+		respondWithOAuthError(res, http.StatusBadRequest, oauthInvalidGrant, "device_code already used")
+		return
+	}
+	used, err := apiCfg.DBQueries.UseDeviceAuthorization(req.Context(), authorization.ID)
+	if err != nil {
+		respondWithOAuthError(res, http.StatusInternalServerError, oauthServerError, "")
+		return
+	}
+	if used == 0 {
+		respondWithOAuthError(res, http.StatusBadRequest, oauthInvalidGrant, "device_code already used")
+		return
+	}
+	user, err := apiCfg.DBQueries.GetUserByID(req.Context(), authorization.UserID.UUID)
+	if err != nil || user.SuspendedAt.Valid {
+		respondWithOAuthError(res, http.StatusBadRequest, oauthInvalidGrant, "the user cannot be signed in")
+		return
+	}
+	accessToken, err := apiCfg.Keys.MakeJWT(user.ID, user.Role, MaxSessionDuration)
+	if err != nil {
+		respondWithOAuthError(res, http.StatusInternalServerError, oauthServerError, "")
+		return
+	}
+	refreshToken, err := issueRefreshToken(req, apiCfg, user.ID, uuid.New(), time.Now(), accessToken)
+	if err != nil {
+		respondWithOAuthError(res, http.StatusInternalServerError, oauthServerError, "")
+		return
+	}
+	apiCfg.recordAudit(req, auditLoginSucceeded, user.ID, user.ID, map[string]any{"method": loginMethodDevice})
+	res.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(res, http.StatusOK, oauthTokenPayload{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(MaxSessionDuration.Seconds()),
+		RefreshToken: refreshToken,
+	})
+}
+
+// devicePollInterval paces a device polling at now. It reports whether the
+// device came back before its interval was up, in which case the interval it
+// must keep to from then on is deviceSlowDownStep longer.
+func devicePollInterval(authorization database.DeviceAuthorization, now time.Time) (time.Duration, bool) {
+	interval := time.Duration(authorization.IntervalSeconds) * time.Second
+	tooFast := authorization.LastPolledAt.Valid && now.Sub(authorization.LastPolledAt.Time) < interval
+	if tooFast {
+		interval += deviceSlowDownStep
+	}
+	return interval, tooFast
+}
+
+// pendingDeviceAuthorization finds the device request a user code belongs
+// to, if it is still waiting for an answer.
+func (cfg *ApiConfig) pendingDeviceAuthorization(req *http.Request, userCode string) (database.DeviceAuthorization, error) {
+	return cfg.DBQueries.GetDeviceAuthorizationByUserCode(req.Context(), auth.NormalizeUserCode(userCode))
+}
+
+// GetDeviceAuthorizationHandler describes the device behind a user code, so
+// the verification page can show the user what they are about to sign in.
+func GetDeviceAuthorizationHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		if _, err := apiCfg.authenticate(req); err != nil {
+			respondWithAuthError(res, err)
+			return
+		}
+		authorization, err := apiCfg.pendingDeviceAuthorization(req, req.URL.Query().Get("user_code"))
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(res, ErrorInvalidUserCode, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		respondWithJSON(res, http.StatusOK, deviceRequestPayload{
+			UserCode:    authorization.UserCode,
+			Device:      describeDevice(authorization.UserAgent),
+			UserAgent:   authorization.UserAgent,
+			IP:          authorization.Ip,
+			RequestedAt: authorization.CreatedAt.Format(TimeFormat),
+			ExpiresAt:   authorization.ExpiresAt.Format(TimeFormat),
+		})
+	}
+}
+
+// DecideDeviceAuthorizationHandler records the signed-in user's answer to a
+// device's request. An approved device is signed in as the user the next
+// time it polls.
+func DecideDeviceAuthorizationHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		type reqPayload struct {
+			UserCode string `json:"user_code"`
+			Approve  bool   `json:"approve"`
+		}
+		user, err := apiCfg.authenticate(req)
+		if err != nil {
+			respondWithAuthError(res, err)
+			return
+		}
+		params := reqPayload{}
+		if err := json.NewDecoder(req.Body).Decode(&params); err != nil {
+			http.Error(res, ErrorSomethingWentWrong, http.StatusBadRequest)
+			return
+		}
+		authorization, err := apiCfg.pendingDeviceAuthorization(req, params.UserCode)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(res, ErrorInvalidUserCode, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		status := deviceStatusDenied
+		if params.Approve {
+			status = deviceStatusApproved
+		}
+		decided, err := apiCfg.DBQueries.DecideDeviceAuthorization(req.Context(), database.DecideDeviceAuthorizationParams{
+			ID:     authorization.ID,
+			Status: status,
+			UserID: uuid.NullUUID{UUID: user.ID, Valid: true},
+		})
+		if err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		if decided == 0 {
+			http.Error(res, ErrorInvalidUserCode, http.StatusNotFound)
+			return
+		}
+		apiCfg.recordAudit(req, auditDeviceAuthorized, user.ID, user.ID, map[string]any{
+			"approved":  params.Approve,
+			"device":    describeDevice(authorization.UserAgent),
+			"device_ip": authorization.Ip,
+		})
+		res.WriteHeader(http.StatusNoContent)
+	}
+}
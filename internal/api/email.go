@@ -0,0 +1,164 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/charlesaraya/chirpy/internal/auth"
+	"github.com/charlesaraya/chirpy/internal/database"
+	chirpymail "github.com/charlesaraya/chirpy/internal/mail"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const (
+	ErrorInvalidEmail             string        = "Invalid email address"
+	ErrorEmailTaken               string        = "Email already in use"
+	ErrorEmailNotVerified         string        = "Email not verified"
+	ErrorInvalidVerificationToken string        = "Invalid or expired verification token"
+	EmailVerificationDuration     time.Duration = 24 * time.Hour
+)
+
+// validEmail accepts a bare address such as "walt@breakingbad.com". Display
+// names, addresses without a dotted domain and addresses on the reserved
+// .invalid domain, where the erased-user placeholder lives, are rejected.
+func validEmail(email string) bool {
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email || address.Name != "" {
+		return false
+	}
+	at := strings.LastIndex(email, "@")
+	domain := email[at+1:]
+	return at > 0 && strings.Contains(domain, ".") && !strings.HasSuffix(domain, ".") &&
+		!strings.HasSuffix(strings.ToLower(domain), ".invalid")
+}
+
+// sendEmailVerification mails a confirmation link for email, which is either
+// the user's current address or the one they want to change to. Earlier
+// links stop working.
+func (cfg *ApiConfig) sendEmailVerification(req *http.Request, userID uuid.UUID, email string) error {
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		return err
+	}
+	if err := cfg.DBQueries.ExpireUserEmailVerificationTokens(req.Context(), userID); err != nil {
+		return err
+	}
+	params := database.CreateEmailVerificationTokenParams{
+		UserID:    userID,
+		Email:     email,
+		TokenHash: auth.HashToken(token),
+		ExpiresAt: time.Now().Add(EmailVerificationDuration),
+	}
+	if err := cfg.DBQueries.CreateEmailVerificationToken(req.Context(), params); err != nil {
+		return err
+	}
+	link := cfg.BaseURL + "/app/verify-email.html?token=" + url.QueryEscape(token)
+	cfg.sendMail(chirpymail.Message{
+		To:      email,
+		Subject: "Confirm your email for Chirpy",
+		Body: fmt.Sprintf("Please confirm this address for your Chirpy account by opening this link within 24 hours:\n\n%s\n\n"+
+			"If you didn't ask for this, you can ignore this email.", link),
+	})
+	return nil
+}
+
+// VerifyEmailHandler confirms an address using a token from
+// sendEmailVerification. For a pending change, this is when the new address
+// replaces the old one.
+func VerifyEmailHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		type reqPayload struct {
+			Token string `json:"token"`
+		}
+		params := reqPayload{}
+		if err := json.NewDecoder(req.Body).Decode(&params); err != nil {
+			http.Error(res, ErrorSomethingWentWrong, http.StatusBadRequest)
+			return
+		}
+		verification, err := apiCfg.DBQueries.GetEmailVerificationToken(req.Context(), auth.HashToken(params.Token))
+		if err != nil {
+			http.Error(res, ErrorInvalidVerificationToken, http.StatusBadRequest)
+			return
+		}
+		used, err := apiCfg.DBQueries.UseEmailVerificationToken(req.Context(), verification.ID)
+		if err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		if used == 0 {
+			http.Error(res, ErrorInvalidVerificationToken, http.StatusBadRequest)
+			return
+		}
+		currentUser, err := apiCfg.DBQueries.GetUserByID(req.Context(), verification.UserID)
+		if err != nil {
+			http.Error(res, ErrorInvalidVerificationToken, http.StatusBadRequest)
+			return
+		}
+		var user database.User
+		if verification.Email == currentUser.Email {
+			params := database.MarkEmailVerifiedParams{
+				ID:    currentUser.ID,
+				Email: verification.Email,
+			}
+			user, err = apiCfg.DBQueries.MarkEmailVerified(req.Context(), params)
+		} else {
+			params := database.ConfirmEmailChangeParams{
+				ID:    currentUser.ID,
+				Email: verification.Email,
+			}
+			user, err = apiCfg.DBQueries.ConfirmEmailChange(req.Context(), params)
+			if isUniqueViolation(err) {
+				http.Error(res, ErrorEmailTaken, http.StatusConflict)
+				return
+			}
+		}
+		if err != nil {
+			// The address was changed again since the link was sent.
+			http.Error(res, ErrorInvalidVerificationToken, http.StatusBadRequest)
+			return
+		}
+		if user.Email != currentUser.Email {
+			apiCfg.recordAudit(req, auditEmailChanged, user.ID, user.ID, map[string]any{"old_email": currentUser.Email, "new_email": user.Email})
+		}
+		apiCfg.recordAudit(req, auditEmailVerified, user.ID, user.ID, map[string]any{"email": user.Email})
+		res.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ResendEmailVerificationHandler sends a fresh link for the pending address,
+// or for the current one if it is still unverified.
+func ResendEmailVerificationHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		user, err := apiCfg.authenticate(req)
+		if err != nil {
+			respondWithAuthError(res, err)
+			return
+		}
+		email := user.Email
+		if user.PendingEmail.Valid {
+			email = user.PendingEmail.String
+		} else if user.EmailVerifiedAt.Valid {
+			res.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if err := apiCfg.sendEmailVerification(req, user.ID, email); err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		res.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// isUniqueViolation reports whether err is Postgres rejecting a duplicate
+// value, such as an email address that is already taken.
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
@@ -1,8 +1,11 @@
 package api
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"os"
 	"sort"
@@ -23,12 +26,25 @@ const (
 	ErrorUnauthorized        string        = "Unauthorized"
 	ErrorForbidden           string        = "Forbidden"
 	ErrorNotFound            string        = "NotFound"
+	ErrorAccountSuspended    string        = "Account suspended"
 	MetricsTemplatePath      string        = "./templates/metrics.html"
 	allowedPlatform          string        = "dev"
 	TimeFormat               string        = "2006-01-02 15:04:05.000000"
 	MaxSessionDuration       time.Duration = time.Hour
 )
 
+const (
+	ErrorPasswordResetRequired   string = "Password reset required"
+	ErrorPasswordUnchanged       string = "New password must differ from the current one"
+	ErrorCurrentPasswordRequired string = "Changing the email needs the current password"
+)
+
+const (
+	chirpStatusPublished string = "published"
+	chirpStatusHeld      string = "held"
+	chirpStatusRejected  string = "rejected"
+)
+
 var ProfaneWords = []string{"kerfuffle", "sharbert", "fornax"}
 
 type chirpPayload struct {
@@ -37,6 +53,7 @@ type chirpPayload struct {
 	UpdatedAt string `json:"updated_at"`
 	UserID    string `json:"user_id"`
 	Body      string `json:"body"`
+	Status    string `json:"status"`
 }
 
 type loginPayload struct {
@@ -45,7 +62,8 @@ type loginPayload struct {
 }
 
 type tokenPayload struct {
-	AccessToken string `json:"token"`
+	AccessToken  string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
 }
 
 func GetHomeHandler(apiCfg *ApiConfig, name string, prefix string) http.HandlerFunc {
@@ -135,53 +153,97 @@ func GetMetricsHandler(apiCfg *ApiConfig, tmplPath string) http.HandlerFunc {
 	}
 }
 
+// ResetMetricsHandler deletes every user, so besides needing an admin it
+// only works on the dev platform.
 func ResetMetricsHandler(apiCfg *ApiConfig) http.HandlerFunc {
 	return func(res http.ResponseWriter, req *http.Request) {
-		if apiCfg.Platform == allowedPlatform {
-			apiCfg.ResetHits()
-			if err := apiCfg.DBQueries.DeleteUsers(req.Context()); err != nil {
-				http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
-				return
-			}
-			res.WriteHeader(http.StatusOK)
+		if apiCfg.Platform != allowedPlatform {
+			res.WriteHeader(http.StatusForbidden)
 			return
 		}
-		res.WriteHeader(http.StatusForbidden)
+		apiCfg.ResetHits()
+		if err := apiCfg.DBQueries.DeleteUsers(req.Context()); err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		res.WriteHeader(http.StatusOK)
 	}
 }
 
+// CreateUserHandler signs up a user, as far as the registration mode allows.
+// Depending on the mode it asks for an invite code, or creates the account
+// pending approval.
 func CreateUserHandler(apiCfg *ApiConfig) http.HandlerFunc {
 	return func(res http.ResponseWriter, req *http.Request) {
+		type reqPayload struct {
+			loginPayload
+			InviteCode string `json:"invite_code"`
+		}
+		if apiCfg.Registration.Mode == RegistrationClosed {
+			http.Error(res, ErrorRegistrationClosed, http.StatusForbidden)
+			return
+		}
 		decoder := json.NewDecoder(req.Body)
-		params := loginPayload{}
+		params := reqPayload{}
 		if err := decoder.Decode(&params); err != nil {
 			http.Error(res, ErrorSomethingWentWrong, http.StatusBadRequest)
 			return
 		}
-		hashedPassword, err := auth.HashPassword(params.Password)
+		if !validEmail(params.Email) {
+			http.Error(res, ErrorInvalidEmail, http.StatusBadRequest)
+			return
+		}
+		if !apiCfg.checkPassword(res, params.Password, params.Email) {
+			return
+		}
+		hashedPassword, err := apiCfg.PasswordHasher.Hash(params.Password)
 		if err != nil {
 			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
 			return
 		}
-		if err := auth.CheckPasswordHash(hashedPassword, params.Password); err != nil {
+		if err := apiCfg.PasswordHasher.Verify(hashedPassword, params.Password); err != nil {
 			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
 			return
 		}
+		invite, pendingApproval, err := apiCfg.admitSignup(req.Context(), params.InviteCode)
+		if err != nil {
+			respondWithSignupError(res, err)
+			return
+		}
 		userParams := database.CreateUserParams{
-			Email:          params.Email,
-			HashedPassword: hashedPassword,
+			Email:           params.Email,
+			HashedPassword:  hashedPassword,
+			PendingApproval: pendingApproval,
 		}
 		user, err := apiCfg.DBQueries.CreateUser(req.Context(), userParams)
+		if err != nil && invite.ID != uuid.Nil {
+			if err := apiCfg.DBQueries.ReleaseInviteCode(req.Context(), invite.ID); err != nil {
+				log.Printf("releasing invite code %s: %v", invite.ID, err)
+			}
+		}
+		if isUniqueViolation(err) {
+			http.Error(res, ErrorEmailTaken, http.StatusConflict)
+			return
+		}
 		if err != nil {
 			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
 			return
 		}
+		if invite.ID != uuid.Nil {
+			apiCfg.recordAudit(req, auditInviteUsed, user.ID, invite.CreatedBy, map[string]any{"invite_id": invite.ID})
+		}
+		if err := apiCfg.sendEmailVerification(req, user.ID, user.Email); err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
 		resBody := UserPayload{
-			ID:          user.ID.String(),
-			CreatedAt:   user.CreatedAt.String(),
-			UpdatedAt:   user.UpdatedAt.String(),
-			Email:       user.Email,
-			IsChirpyRed: user.IsChirpyRed,
+			ID:              user.ID.String(),
+			CreatedAt:       user.CreatedAt.String(),
+			UpdatedAt:       user.UpdatedAt.String(),
+			Email:           user.Email,
+			IsChirpyRed:     user.IsChirpyRed,
+			EmailVerified:   user.EmailVerifiedAt.Valid,
+			PendingApproval: user.PendingApproval,
 		}
 		data, err := json.Marshal(resBody)
 		if err != nil {
@@ -202,41 +264,111 @@ func UpdateUserHandler(apiCfg *ApiConfig) http.HandlerFunc {
 			http.Error(res, ErrorSomethingWentWrong, http.StatusBadRequest)
 			return
 		}
-		token, err := auth.GetBearerToken(req.Header)
+		token, _, err := sessionToken(req, accessTokenCookie)
 		if err != nil {
-			http.Error(res, ErrorUnauthorized, http.StatusUnauthorized)
+			respondWithAuthError(res, err)
 			return
 		}
-		userUUID, err := auth.ValidateJWT(token, apiCfg.TokenSecret)
+		currentUser, err := apiCfg.authenticateForPasswordChange(req, auth.ScopeProfileWrite)
 		if err != nil {
-			http.Error(res, ErrorUnauthorized, http.StatusUnauthorized)
+			respondWithAuthError(res, err)
+			return
+		}
+		passwordChanged := apiCfg.PasswordHasher.Verify(currentUser.HashedPassword, params.Password) != nil
+		if currentUser.PasswordResetRequired && !passwordChanged {
+			http.Error(res, ErrorPasswordUnchanged, http.StatusBadRequest)
+			return
+		}
+		// A scoped token may not change the password, which would let it
+		// mint a full session. It may change the email only by sending the
+		// current password: otherwise a leaked token could point the account
+		// at its holder's address and take it over with a password reset.
+		emailChanged := params.Email != currentUser.Email
+		if passwordChanged && apiCfg.isScopedToken(token) {
+			if emailChanged {
+				http.Error(res, ErrorCurrentPasswordRequired, http.StatusForbidden)
+				return
+			}
+			http.Error(res, ErrorInsufficientScope, http.StatusForbidden)
 			return
 		}
-		hashedPassword, err := auth.HashPassword(params.Password)
+		if passwordChanged && !apiCfg.checkPassword(res, params.Password, params.Email) {
+			return
+		}
+		// A new email address only takes effect once it has been confirmed.
+		if emailChanged {
+			if !validEmail(params.Email) {
+				http.Error(res, ErrorInvalidEmail, http.StatusBadRequest)
+				return
+			}
+			if _, err := apiCfg.DBQueries.GetUser(req.Context(), params.Email); err == nil {
+				http.Error(res, ErrorEmailTaken, http.StatusConflict)
+				return
+			}
+		}
+		hashedPassword, err := apiCfg.PasswordHasher.Hash(params.Password)
 		if err != nil {
 			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
 			return
 		}
-		if err := auth.CheckPasswordHash(hashedPassword, params.Password); err != nil {
+		if err := apiCfg.PasswordHasher.Verify(hashedPassword, params.Password); err != nil {
 			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
 			return
 		}
 		userParams := database.UpdateUserParams{
-			Email:          params.Email,
+			Email:          currentUser.Email,
 			HashedPassword: hashedPassword,
-			ID:             userUUID,
+			ID:             currentUser.ID,
 		}
 		user, err := apiCfg.DBQueries.UpdateUser(req.Context(), userParams)
 		if err != nil {
 			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		if emailChanged {
+			pendingParams := database.SetPendingEmailParams{
+				ID:           user.ID,
+				PendingEmail: sql.NullString{String: params.Email, Valid: true},
+			}
+			if err := apiCfg.DBQueries.SetPendingEmail(req.Context(), pendingParams); err != nil {
+				http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+				return
+			}
+			if err := apiCfg.sendEmailVerification(req, user.ID, params.Email); err != nil {
+				http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+				return
+			}
+			user.PendingEmail = pendingParams.PendingEmail
+			apiCfg.recordAudit(req, auditEmailChangeAsked, user.ID, user.ID, map[string]any{"old_email": user.Email, "new_email": params.Email})
+		}
+		refreshToken := ""
+		if passwordChanged {
+			// Anyone holding a session from before the change is signed out,
+			// so the caller gets a fresh one.
+			if err := apiCfg.signOutEverywhere(req.Context(), user.ID); err != nil {
+				http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+				return
+			}
+			if token, err = apiCfg.Keys.MakeJWT(user.ID, user.Role, MaxSessionDuration); err != nil {
+				http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+				return
+			}
+			if refreshToken, err = issueRefreshToken(req, apiCfg, user.ID, uuid.New(), time.Now(), token); err != nil {
+				http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+				return
+			}
+			apiCfg.recordAudit(req, auditPasswordChanged, user.ID, user.ID, nil)
 		}
 		payload := UserPayload{
-			ID:          user.ID.String(),
-			CreatedAt:   user.CreatedAt.Format(TimeFormat),
-			UpdatedAt:   user.UpdatedAt.Format(TimeFormat),
-			Email:       user.Email,
-			IsChirpyRed: user.IsChirpyRed,
-			Token:       token,
+			ID:            user.ID.String(),
+			CreatedAt:     user.CreatedAt.Format(TimeFormat),
+			UpdatedAt:     user.UpdatedAt.Format(TimeFormat),
+			Email:         user.Email,
+			IsChirpyRed:   user.IsChirpyRed,
+			Token:         token,
+			RefreshToken:  refreshToken,
+			EmailVerified: user.EmailVerifiedAt.Valid,
+			PendingEmail:  user.PendingEmail.String,
 		}
 		data, err := json.Marshal(payload)
 		if err != nil {
@@ -256,69 +388,126 @@ func LoginUserHandler(apiCfg *ApiConfig) http.HandlerFunc {
 			http.Error(res, ErrorSomethingWentWrong, http.StatusBadRequest)
 			return
 		}
-		user, err := apiCfg.DBQueries.GetUser(req.Context(), params.Email)
+		lockedFor, err := apiCfg.loginLockedFor(req, params.Email)
 		if err != nil {
 			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
 			return
 		}
-		if err := auth.CheckPasswordHash(user.HashedPassword, params.Password); err != nil {
-			http.Error(res, ErrorUnauthorized, http.StatusUnauthorized)
+		if lockedFor > 0 {
+			apiCfg.recordAudit(req, auditLoginFailed, uuid.Nil, uuid.Nil, map[string]any{"email": params.Email, "reason": "locked"})
+			respondWithLoginLocked(res, lockedFor)
 			return
 		}
-		token, err := auth.MakeJWT(user.ID, apiCfg.TokenSecret, MaxSessionDuration)
-		if err != nil {
+		// Unknown emails get the same answer, after the same delay, as wrong
+		// passwords, so logins cannot be used to find out who has signed up.
+		user, err := apiCfg.DBQueries.GetUser(req.Context(), params.Email)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
 			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
 			return
 		}
-		refreshToken, _ := auth.MakeRefreshToken()
-		refereshTokensParams := database.CreateRefreshTokenParams{
-			Token:     refreshToken,
-			UserID:    user.ID,
-			ExpiresAt: time.Now().Add(time.Hour * 24 * 60),
-		}
-		refreshTokenDB, err := apiCfg.DBQueries.CreateRefreshToken(req.Context(), refereshTokensParams)
 		if err != nil {
-			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			apiCfg.verifyDummyPassword(params.Password)
+			apiCfg.recordLoginFailure(req, params.Email, uuid.Nil)
+			apiCfg.recordAudit(req, auditLoginFailed, uuid.Nil, uuid.Nil, map[string]any{"email": params.Email, "reason": "unknown_email"})
+			http.Error(res, ErrorUnauthorized, http.StatusUnauthorized)
 			return
 		}
-		payload := UserPayload{
-			ID:           user.ID.String(),
-			CreatedAt:    user.CreatedAt.Format(TimeFormat),
-			UpdatedAt:    user.UpdatedAt.Format(TimeFormat),
-			Email:        user.Email,
-			IsChirpyRed:  user.IsChirpyRed,
-			Token:        token,
-			RefreshToken: refreshTokenDB.Token,
+		if err := apiCfg.PasswordHasher.Verify(user.HashedPassword, params.Password); err != nil {
+			apiCfg.recordLoginFailure(req, params.Email, user.ID)
+			apiCfg.recordAudit(req, auditLoginFailed, uuid.Nil, user.ID, map[string]any{"reason": "wrong_password"})
+			http.Error(res, ErrorUnauthorized, http.StatusUnauthorized)
+			return
 		}
-		data, err := json.Marshal(payload)
+		apiCfg.clearLoginFailures(req.Context(), params.Email)
+		apiCfg.rehashPassword(req.Context(), user, params.Password)
+		if user.SuspendedAt.Valid {
+			apiCfg.recordAudit(req, auditLoginFailed, user.ID, user.ID, map[string]any{"reason": "suspended"})
+			http.Error(res, ErrorAccountSuspended, http.StatusForbidden)
+			return
+		}
+		if user.PendingApproval {
+			apiCfg.recordAudit(req, auditLoginFailed, user.ID, user.ID, map[string]any{"reason": "pending_approval"})
+			http.Error(res, ErrorAccountPendingApproval, http.StatusForbidden)
+			return
+		}
+		_, mfaEnabled, err := apiCfg.totpCredential(req.Context(), user.ID)
 		if err != nil {
 			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
 			return
 		}
-		res.Header().Set("Content-Type", "application/json")
-		res.Write(data)
+		if mfaEnabled {
+			respondWithMFAChallenge(res, req, apiCfg, user)
+			return
+		}
+		startSession(res, req, apiCfg, user, loginMethodPassword)
 	}
 }
 
+// RefreshTokenHandler exchanges a refresh token for a new access token and a
+// new refresh token. Each refresh token can be used once; presenting one that
+// was already rotated revokes its whole family, since it means the token
+// leaked. A refresh token sent as a cookie is answered with new cookies.
 func RefreshTokenHandler(apiCfg *ApiConfig) http.HandlerFunc {
 	return func(res http.ResponseWriter, req *http.Request) {
-		token, err := auth.GetBearerToken(req.Header)
+		token, fromCookie, err := sessionToken(req, refreshTokenCookie)
+		if err != nil {
+			respondWithAuthError(res, err)
+			return
+		}
+		refreshToken, err := apiCfg.DBQueries.GetRefreshToken(req.Context(), auth.HashToken(token))
+		if err != nil || refreshToken.ExpiresAt.Before(time.Now()) {
+			http.Error(res, ErrorUnauthorized, http.StatusUnauthorized)
+			return
+		}
+		user, err := apiCfg.DBQueries.GetUserByID(req.Context(), refreshToken.UserID)
+		if err != nil {
+			http.Error(res, ErrorUnauthorized, http.StatusUnauthorized)
+			return
+		}
+		if user.SuspendedAt.Valid {
+			http.Error(res, ErrorAccountSuspended, http.StatusForbidden)
+			return
+		}
+		if refreshToken.RevokedAt.Valid {
+			http.Error(res, ErrorUnauthorized, http.StatusUnauthorized)
+			return
+		}
+		rotated, err := apiCfg.DBQueries.RotateRefreshToken(req.Context(), refreshToken.ID)
 		if err != nil {
-			http.Error(res, err.Error(), http.StatusInternalServerError)
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
 			return
 		}
-		refreshToken, err := apiCfg.DBQueries.GetRefreshToken(req.Context(), token)
-		if err != nil || refreshToken.ExpiresAt.Before(time.Now()) || refreshToken.RevokedAt.Valid {
+		if refreshToken.RotatedAt.Valid || rotated == 0 {
+			if err := apiCfg.DBQueries.RevokeRefreshTokenFamily(req.Context(), refreshToken.FamilyID); err != nil {
+				http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+				return
+			}
+			apiCfg.recordAudit(req, auditTokenReuseDetected, uuid.Nil, user.ID, map[string]any{"family_id": refreshToken.FamilyID})
 			http.Error(res, ErrorUnauthorized, http.StatusUnauthorized)
 			return
 		}
-		accessToken, err := auth.MakeJWT(refreshToken.UserID, apiCfg.TokenSecret, MaxSessionDuration)
+		accessToken, err := apiCfg.Keys.MakeJWT(user.ID, user.Role, MaxSessionDuration)
 		if err != nil {
 			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
 			return
 		}
+		newRefreshToken, err := issueRefreshToken(req, apiCfg, user.ID, refreshToken.FamilyID, refreshToken.SignedInAt, accessToken)
+		if err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		apiCfg.recordAudit(req, auditTokenRefreshed, user.ID, user.ID, nil)
+		if fromCookie {
+			if err := setSessionCookies(res, accessToken, newRefreshToken); err != nil {
+				http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+				return
+			}
+			res.WriteHeader(http.StatusNoContent)
+			return
+		}
 		payload := tokenPayload{
-			AccessToken: accessToken,
+			AccessToken:  accessToken,
+			RefreshToken: newRefreshToken,
 		}
 		data, err := json.Marshal(payload)
 		if err != nil {
@@ -329,18 +518,30 @@ func RefreshTokenHandler(apiCfg *ApiConfig) http.HandlerFunc {
 	}
 }
 
+// RevokeTokenHandler ends the session a refresh token belongs to by revoking
+// its whole family.
 func RevokeTokenHandler(apiCfg *ApiConfig) http.HandlerFunc {
 	return func(res http.ResponseWriter, req *http.Request) {
-		token, err := auth.GetBearerToken(req.Header)
+		token, fromCookie, err := sessionToken(req, refreshTokenCookie)
 		if err != nil {
-			http.Error(res, err.Error(), http.StatusInternalServerError)
+			respondWithAuthError(res, err)
 			return
 		}
-		err = apiCfg.DBQueries.RevokeRefreshToken(req.Context(), token)
+		if fromCookie {
+			clearSessionCookies(res)
+		}
+		refreshToken, err := apiCfg.DBQueries.GetRefreshToken(req.Context(), auth.HashToken(token))
+		if err != nil {
+			// Revoking an unknown token is a no-op, as it cannot be used anyway.
+			res.WriteHeader(http.StatusNoContent)
+			return
+		}
+		err = apiCfg.DBQueries.RevokeRefreshTokenFamily(req.Context(), refreshToken.FamilyID)
 		if err != nil {
 			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
 			return
 		}
+		apiCfg.recordAudit(req, auditTokenRevoked, refreshToken.UserID, refreshToken.UserID, nil)
 		res.WriteHeader(http.StatusNoContent)
 	}
 }
@@ -356,21 +557,30 @@ func CreateChirpHandler(apiCfg *ApiConfig) http.HandlerFunc {
 			http.Error(res, ErrorSomethingWentWrong, http.StatusBadRequest)
 			return
 		}
-		token, err := auth.GetBearerToken(req.Header)
+		user, err := apiCfg.authenticateWithScope(req, auth.ScopeChirpsWrite)
 		if err != nil {
-			http.Error(res, err.Error(), http.StatusInternalServerError)
+			respondWithAuthError(res, err)
+			return
+		}
+		if apiCfg.RequireVerifiedEmail && !user.EmailVerifiedAt.Valid {
+			http.Error(res, ErrorEmailNotVerified, http.StatusForbidden)
 			return
 		}
-		userUUID, err := auth.ValidateJWT(token, apiCfg.TokenSecret)
+		spamResult, err := scoreChirp(req.Context(), apiCfg, user, params.Body)
 		if err != nil {
-			http.Error(res, err.Error(), http.StatusUnauthorized)
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
 			return
 		}
+		status := chirpStatusPublished
+		if spamResult.IsSpam(apiCfg.SpamConfig) {
+			status = chirpStatusHeld
+		}
 		chirpParams := database.CreateChirpParams{
-			UserID: userUUID,
+			UserID: user.ID,
 			Body:   params.Body,
+			Status: status,
 		}
-		chirp, err := apiCfg.DBQueries.CreateChirp(req.Context(), chirpParams)
+		chirp, err := apiCfg.createChirp(req.Context(), chirpParams, spamResult)
 		if err != nil {
 			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
 			return
@@ -381,12 +591,18 @@ func CreateChirpHandler(apiCfg *ApiConfig) http.HandlerFunc {
 			UpdatedAt: chirp.UpdatedAt.Format(TimeFormat),
 			UserID:    chirp.UserID.String(),
 			Body:      chirp.Body,
+			Status:    chirp.Status,
 		}
 		data, err := json.Marshal(payload)
 		if err != nil {
 			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
 		}
-		res.WriteHeader(http.StatusCreated)
+		// Held chirps are accepted but not published until a moderator reviews them.
+		if chirp.Status == chirpStatusHeld {
+			res.WriteHeader(http.StatusAccepted)
+		} else {
+			res.WriteHeader(http.StatusCreated)
+		}
 		res.Header().Set("Content-Type", "application/json")
 		res.Write(data)
 	}
@@ -396,6 +612,7 @@ func GetChirpsHandler(apiCfg *ApiConfig) http.HandlerFunc {
 	return func(res http.ResponseWriter, req *http.Request) {
 		var chirps []database.Chirp
 		var err error
+		viewerID := apiCfg.viewerID(req)
 		authorID := req.URL.Query().Get("author_id")
 		if authorID != "" {
 			userUUID, err := uuid.Parse(authorID)
@@ -403,13 +620,17 @@ func GetChirpsHandler(apiCfg *ApiConfig) http.HandlerFunc {
 				http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
 				return
 			}
-			chirps, err = apiCfg.DBQueries.GetChirpsFromUser(req.Context(), userUUID)
+			chirpsParams := database.GetVisibleChirpsFromUserParams{
+				UserID:   userUUID,
+				ViewerID: viewerID,
+			}
+			chirps, err = apiCfg.DBQueries.GetVisibleChirpsFromUser(req.Context(), chirpsParams)
 			if err != nil {
 				http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
 				return
 			}
 		} else {
-			chirps, err = apiCfg.DBQueries.GetChirps(req.Context())
+			chirps, err = apiCfg.DBQueries.GetVisibleChirps(req.Context(), viewerID)
 			if err != nil {
 				http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
 				return
@@ -429,6 +650,7 @@ func GetChirpsHandler(apiCfg *ApiConfig) http.HandlerFunc {
 				UpdatedAt: chirp.UpdatedAt.Format(TimeFormat),
 				UserID:    chirp.UserID.String(),
 				Body:      chirp.Body,
+				Status:    chirp.Status,
 			}
 		}
 		data, err := json.Marshal(payload)
@@ -448,7 +670,11 @@ func GetSingleChirpHandler(apiCfg *ApiConfig) http.HandlerFunc {
 			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
 			return
 		}
-		chirp, err := apiCfg.DBQueries.GetSingleChirp(req.Context(), id)
+		chirpParams := database.GetVisibleChirpParams{
+			ID:       id,
+			ViewerID: apiCfg.viewerID(req),
+		}
+		chirp, err := apiCfg.DBQueries.GetVisibleChirp(req.Context(), chirpParams)
 		if err != nil {
 			http.Error(res, ErrorNotFound, http.StatusNotFound)
 			return
@@ -459,6 +685,7 @@ func GetSingleChirpHandler(apiCfg *ApiConfig) http.HandlerFunc {
 			UpdatedAt: chirp.UpdatedAt.Format(TimeFormat),
 			UserID:    chirp.ID.String(),
 			Body:      chirp.Body,
+			Status:    chirp.Status,
 		}
 		data, err := json.Marshal(payload)
 		if err != nil {
@@ -472,14 +699,9 @@ func GetSingleChirpHandler(apiCfg *ApiConfig) http.HandlerFunc {
 
 func DeleteChirpHandler(apiCfg *ApiConfig) http.HandlerFunc {
 	return func(res http.ResponseWriter, req *http.Request) {
-		token, err := auth.GetBearerToken(req.Header)
-		if err != nil {
-			http.Error(res, ErrorUnauthorized, http.StatusUnauthorized)
-			return
-		}
-		userUUID, err := auth.ValidateJWT(token, apiCfg.TokenSecret)
+		user, err := apiCfg.authenticateWithScope(req, auth.ScopeChirpsWrite)
 		if err != nil {
-			http.Error(res, ErrorUnauthorized, http.StatusUnauthorized)
+			respondWithAuthError(res, err)
 			return
 		}
 		chirpID, err := uuid.Parse(req.PathValue("chirpID"))
@@ -489,7 +711,7 @@ func DeleteChirpHandler(apiCfg *ApiConfig) http.HandlerFunc {
 		}
 		params := database.DeleteChirpParams{
 			ID:     chirpID,
-			UserID: userUUID,
+			UserID: user.ID,
 		}
 		result, err := apiCfg.DBQueries.DeleteChirp(req.Context(), params)
 		if err != nil {
@@ -510,6 +732,7 @@ func DeleteChirpHandler(apiCfg *ApiConfig) http.HandlerFunc {
 			http.Error(res, ErrorForbidden, http.StatusForbidden)
 			return
 		}
+		apiCfg.recordAudit(req, auditChirpDeleted, user.ID, chirpID, nil)
 		res.WriteHeader(http.StatusNoContent)
 	}
 }
@@ -1,7 +1,12 @@
 package api
 
 import (
+	"bytes"
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -9,10 +14,18 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/charlesaraya/chirpy/internal/auth"
 	"github.com/charlesaraya/chirpy/internal/database"
-	_ "github.com/lib/pq"
+	"github.com/charlesaraya/chirpy/internal/export"
+	"github.com/charlesaraya/chirpy/internal/mail"
+	"github.com/charlesaraya/chirpy/internal/oidc"
+	"github.com/charlesaraya/chirpy/internal/spam"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 func loadDB(t *testing.T) (*database.Queries, error) {
@@ -67,6 +80,148 @@ func assertBodyContains(t *testing.T, rec *httptest.ResponseRecorder, expected s
 	}
 }
 
+// fakeDB stands in for Postgres in tests of what handlers do with query
+// results. It answers queries by their sqlc name, fails those it has no
+// answer for, and records every call, including COMMIT and ROLLBACK.
+type fakeDB struct {
+	answers map[string]func(args []driver.Value) ([][]driver.Value, error)
+	mu      sync.Mutex
+	calls   []fakeCall
+}
+
+type fakeCall struct {
+	name string
+	args []driver.Value
+}
+
+func (db *fakeDB) open() *sql.DB {
+	return sql.OpenDB(fakeConnector{db})
+}
+
+func (db *fakeDB) queries() *database.Queries {
+	return database.New(db.open())
+}
+
+func (db *fakeDB) record(name string, args []driver.Value) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.calls = append(db.calls, fakeCall{name: name, args: args})
+}
+
+// called returns the arguments of each call to the named query.
+func (db *fakeDB) called(name string) [][]driver.Value {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var args [][]driver.Value
+	for _, call := range db.calls {
+		if call.name == name {
+			args = append(args, call.args)
+		}
+	}
+	return args
+}
+
+func (db *fakeDB) answer(query string, args []driver.Value) ([][]driver.Value, error) {
+	name := strings.Fields(strings.TrimPrefix(query, "-- name: "))[0]
+	db.record(name, args)
+	answer, ok := db.answers[name]
+	if !ok {
+		return nil, fmt.Errorf("fakeDB: no answer for %s", name)
+	}
+	return answer(args)
+}
+
+type fakeConnector struct{ db *fakeDB }
+
+func (c fakeConnector) Connect(context.Context) (driver.Conn, error) { return fakeConn(c), nil }
+func (c fakeConnector) Driver() driver.Driver                        { return nil }
+
+type fakeConn struct{ db *fakeDB }
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) { return fakeStmt{c.db, query}, nil }
+func (c fakeConn) Close() error                              { return nil }
+func (c fakeConn) Begin() (driver.Tx, error)                 { return fakeTx(c), nil }
+
+type fakeTx struct{ db *fakeDB }
+
+func (tx fakeTx) Commit() error   { tx.db.record("COMMIT", nil); return nil }
+func (tx fakeTx) Rollback() error { tx.db.record("ROLLBACK", nil); return nil }
+
+type fakeStmt struct {
+	db    *fakeDB
+	query string
+}
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return -1 }
+
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	rows, err := s.db.answer(s.query, args)
+	return driver.RowsAffected(len(rows)), err
+}
+
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	rows, err := s.db.answer(s.query, args)
+	return &fakeRows{rows: rows}, err
+}
+
+type fakeRows struct {
+	rows [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	if len(r.rows) == 0 {
+		return nil
+	}
+	return make([]string, len(r.rows[0]))
+}
+
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+// fakeUserRow is user as a row of the users table, in the order the
+// generated queries select it.
+func fakeUserRow(user database.User) []driver.Value {
+	nullTime := func(t sql.NullTime) driver.Value {
+		if !t.Valid {
+			return nil
+		}
+		return t.Time
+	}
+	nullString := func(s sql.NullString) driver.Value {
+		if !s.Valid {
+			return nil
+		}
+		return s.String
+	}
+	return []driver.Value{
+		user.ID.String(), user.CreatedAt, user.UpdatedAt, user.Email, user.HashedPassword,
+		user.IsChirpyRed, nullTime(user.SuspendedAt), nullString(user.SuspensionReason),
+		user.IsShadowbanned, user.Role, user.PasswordResetRequired, nullTime(user.TokensValidAfter),
+		nullTime(user.EmailVerifiedAt), nullString(user.PendingEmail), user.PendingApproval,
+	}
+}
+
+// fakeUsers answers GetUserByID from users.
+func fakeUsers(users ...database.User) func([]driver.Value) ([][]driver.Value, error) {
+	return func(args []driver.Value) ([][]driver.Value, error) {
+		for _, user := range users {
+			if args[0] == user.ID.String() {
+				return [][]driver.Value{fakeUserRow(user)}, nil
+			}
+		}
+		return nil, nil
+	}
+}
+
 func TestHealthHandler(t *testing.T) {
 	t.Run("run health handler", func(t *testing.T) {
 		rec := executeRequest(t, GetHealthHandler, "GET", "/health", nil)
@@ -133,3 +288,1099 @@ func TestValidateChirp(t *testing.T) {
 		assertStatus(t, rec, http.StatusBadRequest)
 	})
 }
+
+func TestAuthErrors(t *testing.T) {
+	cfg := &ApiConfig{}
+	t.Run("create chirp without bearer token", func(t *testing.T) {
+		jsonBody := `{"body":"Hello World!"}`
+		rec := executeRequest(t, CreateChirpHandler(cfg), "POST", "/api/chirps", strings.NewReader(jsonBody))
+		assertStatus(t, rec, http.StatusUnauthorized)
+	})
+	t.Run("revoked access token", func(t *testing.T) {
+		cfg := &ApiConfig{Keys: auth.NewHMACKeyring("testsecret")}
+		token, _ := cfg.Keys.MakeJWT(uuid.New(), auth.RoleUser, time.Hour)
+		claims, _ := cfg.Keys.ParseJWT(token)
+		cfg.Denylist.Add(claims.ID, claims.ExpiresAt.Time)
+		req := httptest.NewRequest("GET", "/api/sessions", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		GetSessionsHandler(cfg).ServeHTTP(rec, req)
+		assertStatus(t, rec, http.StatusUnauthorized)
+	})
+	t.Run("suspended user is forbidden", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		respondWithAuthError(rec, errUserSuspended)
+		assertStatus(t, rec, http.StatusForbidden)
+		assertBodyEqual(t, rec, ErrorAccountSuspended)
+	})
+	t.Run("suspended user's token", func(t *testing.T) {
+		user := database.User{
+			ID:          uuid.New(),
+			Role:        auth.RoleUser,
+			SuspendedAt: sql.NullTime{Time: time.Now(), Valid: true},
+		}
+		db := &fakeDB{answers: map[string]func([]driver.Value) ([][]driver.Value, error){
+			"GetUserByID": fakeUsers(user),
+		}}
+		cfg := &ApiConfig{DBQueries: db.queries(), Keys: auth.NewHMACKeyring("testsecret")}
+		token, _ := cfg.Keys.MakeJWT(user.ID, user.Role, time.Hour)
+		req := httptest.NewRequest("GET", "/api/sessions", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		if _, err := cfg.authenticate(req); !errors.Is(err, errUserSuspended) {
+			t.Errorf("authenticate() error = %v, want %v", err, errUserSuspended)
+		}
+	})
+}
+
+func TestGetChirpsHidesShadowbannedAuthors(t *testing.T) {
+	author := database.User{ID: uuid.New(), Role: auth.RoleUser, IsShadowbanned: true}
+	reader := database.User{ID: uuid.New(), Role: auth.RoleUser}
+	now := time.Now()
+	chirps := []database.Chirp{
+		{ID: uuid.New(), UserID: reader.ID, Body: "from the reader", Status: chirpStatusPublished},
+		{ID: uuid.New(), UserID: author.ID, Body: "from the shadowbanned author", Status: chirpStatusPublished},
+	}
+	db := &fakeDB{answers: map[string]func([]driver.Value) ([][]driver.Value, error){
+		"GetUserByID": fakeUsers(author, reader),
+		// GetVisibleChirps filters as its WHERE clause does.
+		"GetVisibleChirps": func(args []driver.Value) ([][]driver.Value, error) {
+			var rows [][]driver.Value
+			for _, chirp := range chirps {
+				public := chirp.Status == chirpStatusPublished && !(chirp.UserID == author.ID && author.IsShadowbanned)
+				if public || args[0] == chirp.UserID.String() {
+					rows = append(rows, []driver.Value{chirp.ID.String(), chirp.UserID.String(), now, now, chirp.Body, chirp.Status})
+				}
+			}
+			return rows, nil
+		},
+	}}
+	cfg := &ApiConfig{DBQueries: db.queries(), Keys: auth.NewHMACKeyring("testsecret")}
+	tests := []struct {
+		name    string
+		viewer  *database.User
+		wantLen int
+	}{
+		{"anonymous", nil, 1},
+		{"another user", &reader, 1},
+		{"the author", &author, 2},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/chirps", nil)
+			wantViewer := uuid.Nil
+			if tc.viewer != nil {
+				token, _ := cfg.Keys.MakeJWT(tc.viewer.ID, tc.viewer.Role, time.Hour)
+				req.Header.Set("Authorization", "Bearer "+token)
+				wantViewer = tc.viewer.ID
+			}
+			rec := httptest.NewRecorder()
+			GetChirpsHandler(cfg)(rec, req)
+			assertStatus(t, rec, http.StatusOK)
+			var got []chirpPayload
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatalf("decoding chirps: %v", err)
+			}
+			if len(got) != tc.wantLen {
+				t.Errorf("got %d chirps, want %d: %+v", len(got), tc.wantLen, got)
+			}
+			calls := db.called("GetVisibleChirps")
+			if last := calls[len(calls)-1]; last[0] != wantViewer.String() {
+				t.Errorf("GetVisibleChirps viewer = %v, want %s", last[0], wantViewer)
+			}
+		})
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	cfg := &ApiConfig{Keys: auth.NewHMACKeyring("testsecret")}
+	handler := cfg.RequireRole(auth.RoleAdmin, GetHealthHandler)
+	t.Run("missing bearer token", func(t *testing.T) {
+		rec := executeRequest(t, handler, "GET", "/admin/metrics", nil)
+		assertStatus(t, rec, http.StatusUnauthorized)
+	})
+	t.Run("role below required", func(t *testing.T) {
+		token, _ := cfg.Keys.MakeJWT(uuid.New(), auth.RoleModerator, time.Hour)
+		req := httptest.NewRequest("GET", "/admin/metrics", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		assertStatus(t, rec, http.StatusForbidden)
+	})
+	t.Run("oauth client token", func(t *testing.T) {
+		token, _ := cfg.Keys.MakeClientJWT(uuid.New(), uuid.NewString(), []string{auth.ScopeChirpsRead}, time.Hour)
+		req := httptest.NewRequest("GET", "/admin/metrics", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		assertStatus(t, rec, http.StatusForbidden)
+	})
+	t.Run("admin changing another admin's role", func(t *testing.T) {
+		caller := database.User{ID: uuid.New(), Email: "ada@example.com", Role: auth.RoleAdmin}
+		target := database.User{ID: uuid.New(), Email: "grace@example.com", Role: auth.RoleAdmin}
+		db := &fakeDB{answers: map[string]func([]driver.Value) ([][]driver.Value, error){
+			"GetUserByID": fakeUsers(caller, target),
+			"CreateAuditEvent": func([]driver.Value) ([][]driver.Value, error) {
+				return nil, nil
+			},
+		}}
+		cfg := &ApiConfig{Keys: cfg.Keys, DBQueries: db.queries()}
+		token, _ := cfg.Keys.MakeJWT(caller.ID, auth.RoleAdmin, time.Hour)
+		req := httptest.NewRequest("PUT", "/admin/users/"+target.ID.String()+"/role", strings.NewReader(`{"role":"user"}`))
+		req.SetPathValue("userID", target.ID.String())
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		cfg.RequireRoleOver(auth.RoleAdmin, SetUserRoleHandler(cfg)).ServeHTTP(rec, req)
+		assertStatus(t, rec, http.StatusForbidden)
+		if len(db.called("SetUserRole")) != 0 {
+			t.Error("an admin changed another admin's role")
+		}
+	})
+	t.Run("admin granting admin", func(t *testing.T) {
+		caller := database.User{ID: uuid.New(), Email: "ada@example.com", Role: auth.RoleAdmin}
+		target := database.User{ID: uuid.New(), Email: "grace@example.com", Role: auth.RoleUser}
+		db := &fakeDB{answers: map[string]func([]driver.Value) ([][]driver.Value, error){
+			"GetUserByID": fakeUsers(caller, target),
+			"CreateAuditEvent": func([]driver.Value) ([][]driver.Value, error) {
+				return nil, nil
+			},
+		}}
+		cfg := &ApiConfig{Keys: cfg.Keys, DBQueries: db.queries()}
+		token, _ := cfg.Keys.MakeJWT(caller.ID, auth.RoleAdmin, time.Hour)
+		req := httptest.NewRequest("PUT", "/admin/users/"+target.ID.String()+"/role", strings.NewReader(`{"role":"admin"}`))
+		req.SetPathValue("userID", target.ID.String())
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		cfg.RequireRoleOver(auth.RoleAdmin, SetUserRoleHandler(cfg)).ServeHTTP(rec, req)
+		assertStatus(t, rec, http.StatusForbidden)
+		if len(db.called("SetUserRole")) != 0 {
+			t.Error("an admin made another admin")
+		}
+	})
+}
+
+func TestParsePagination(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		wantLimit  int32
+		wantOffset int32
+		wantErr    bool
+	}{
+		{name: "defaults", query: "", wantLimit: defaultPageLimit, wantOffset: 0},
+		{name: "explicit page", query: "?limit=10&offset=20", wantLimit: 10, wantOffset: 20},
+		{name: "limit is capped", query: "?limit=1000", wantLimit: maxPageLimit, wantOffset: 0},
+		{name: "negative offset", query: "?offset=-1", wantErr: true},
+		{name: "non numeric limit", query: "?limit=ten", wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/admin/users"+tc.query, nil)
+			limit, offset, err := parsePagination(req)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected error but got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if limit != tc.wantLimit || offset != tc.wantOffset {
+				t.Errorf("expected limit %d offset %d, got limit %d offset %d", tc.wantLimit, tc.wantOffset, limit, offset)
+			}
+		})
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	req := httptest.NewRequest("POST", "/api/login", nil)
+	req.RemoteAddr = "203.0.113.7:52114"
+	if got := clientIP(req); got != "203.0.113.7" {
+		t.Errorf("expected ip %q, got %q", "203.0.113.7", got)
+	}
+}
+
+func TestDescribeDevice(t *testing.T) {
+	tests := []struct {
+		userAgent string
+		want      string
+	}{
+		{"Mozilla/5.0 (X11; Linux x86_64; rv:128.0) Gecko/20100101 Firefox/128.0", "Firefox on Linux"},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Safari/537.36 Edg/126.0.0.0", "Edge on Windows"},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 17_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.5 Mobile/15E148 Safari/604.1", "Safari on iOS"},
+		{"curl/8.5.0", "curl"},
+		{"", "Unknown device"},
+	}
+	for _, tc := range tests {
+		if got := describeDevice(tc.userAgent); got != tc.want {
+			t.Errorf("describeDevice(%q) = %q, want %q", tc.userAgent, got, tc.want)
+		}
+	}
+}
+
+func TestMFAChallengeTokenAudience(t *testing.T) {
+	cfg := &ApiConfig{Keys: auth.NewHMACKeyring("testsecret")}
+	user := database.User{ID: uuid.New()}
+	rec := httptest.NewRecorder()
+	respondWithMFAChallenge(rec, httptest.NewRequest("POST", "/api/login", nil), cfg, user)
+	assertStatus(t, rec, http.StatusOK)
+	challenge := mfaChallengePayload{}
+	if err := json.NewDecoder(rec.Body).Decode(&challenge); err != nil {
+		t.Fatalf("decoding challenge: %v", err)
+	}
+	if !challenge.MFARequired || challenge.MFAToken == "" {
+		t.Fatalf("expected an mfa challenge, got %+v", challenge)
+	}
+
+	t.Run("challenge token is not an access token", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/sessions", nil)
+		req.Header.Set("Authorization", "Bearer "+challenge.MFAToken)
+		rec := httptest.NewRecorder()
+		GetSessionsHandler(cfg).ServeHTTP(rec, req)
+		assertStatus(t, rec, http.StatusUnauthorized)
+	})
+	t.Run("access token is not a challenge token", func(t *testing.T) {
+		token, _ := cfg.Keys.MakeJWT(user.ID, auth.RoleUser, time.Hour)
+		body := fmt.Sprintf(`{"mfa_token":%q,"code":"123456"}`, token)
+		rec := executeRequest(t, LoginMFAHandler(cfg), "POST", "/api/login/mfa", strings.NewReader(body))
+		assertStatus(t, rec, http.StatusUnauthorized)
+	})
+}
+
+func TestReauthenticateThrottle(t *testing.T) {
+	user := database.User{ID: uuid.New(), HashedPassword: "$2a$10$invalid"}
+	key := mfaThrottleKey(user.ID)
+	tests := []struct {
+		name        string
+		lockedUntil driver.Value
+		wantStatus  int
+		wantCounted bool
+	}{
+		{"locked out", time.Now().Add(time.Minute), http.StatusTooManyRequests, false},
+		{"wrong password", nil, http.StatusUnauthorized, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			db := &fakeDB{answers: map[string]func([]driver.Value) ([][]driver.Value, error){
+				"GetLoginThrottles": func([]driver.Value) ([][]driver.Value, error) {
+					return [][]driver.Value{{key, int64(5), time.Now(), tc.lockedUntil}}, nil
+				},
+				"RecordLoginFailure": func([]driver.Value) ([][]driver.Value, error) {
+					return [][]driver.Value{{key, int64(1), time.Now(), nil}}, nil
+				},
+			}}
+			cfg := &ApiConfig{DBQueries: db.queries(), MFALockout: auth.DefaultMFALockoutPolicy()}
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest("DELETE", "/api/users/me/mfa", nil)
+			if cfg.reauthenticate(rec, req, user, "hunter2", secondFactorPayload{Code: "123456"}, false) {
+				t.Fatal("reauthenticate() = true, want false")
+			}
+			assertStatus(t, rec, tc.wantStatus)
+			counted := db.called("RecordLoginFailure")
+			if (len(counted) == 1 && counted[0][0] == key) != tc.wantCounted {
+				t.Errorf("RecordLoginFailure calls = %v, want counted %v", counted, tc.wantCounted)
+			}
+		})
+	}
+}
+
+func TestUnlockUserClearsMFALock(t *testing.T) {
+	user := database.User{ID: uuid.New(), Email: "Ada@example.com", Role: auth.RoleUser}
+	db := &fakeDB{answers: map[string]func([]driver.Value) ([][]driver.Value, error){
+		"GetUserByID": fakeUsers(user),
+		"ClearLoginThrottle": func([]driver.Value) ([][]driver.Value, error) {
+			return nil, nil
+		},
+	}}
+	cfg := &ApiConfig{DBQueries: db.queries()}
+	req := httptest.NewRequest("POST", "/admin/users/"+user.ID.String()+"/unlock", nil)
+	req.SetPathValue("userID", user.ID.String())
+	rec := httptest.NewRecorder()
+	UnlockUserHandler(cfg)(rec, req)
+	assertStatus(t, rec, http.StatusNoContent)
+	cleared := db.called("ClearLoginThrottle")
+	if len(cleared) != 2 || cleared[0][0] != emailThrottleKey(user.Email) || cleared[1][0] != mfaThrottleKey(user.ID) {
+		t.Errorf("ClearLoginThrottle calls = %v, want the email and mfa keys", cleared)
+	}
+}
+
+func TestValidEmail(t *testing.T) {
+	tests := []struct {
+		email string
+		want  bool
+	}{
+		{"walt@breakingbad.com", true},
+		{"saul.goodman+law@example.co.uk", true},
+		{"", false},
+		{"walt", false},
+		{"walt@localhost", false},
+		{"walt@breakingbad.", false},
+		{"Walter White <walt@breakingbad.com>", false},
+		{" walt@breakingbad.com", false},
+		{"erased-user@chirpy.invalid", false},
+		{"walt@Chirpy.INVALID", false},
+	}
+	for _, tc := range tests {
+		if got := validEmail(tc.email); got != tc.want {
+			t.Errorf("validEmail(%q) = %v, want %v", tc.email, got, tc.want)
+		}
+	}
+}
+
+func TestCreateUserPasswordPolicy(t *testing.T) {
+	cfg := &ApiConfig{PasswordPolicy: auth.DefaultPasswordPolicy()}
+	body := `{"email":"walt@breakingbad.com","password":"qwerty"}`
+	rec := executeRequest(t, CreateUserHandler(cfg), "POST", "/api/users", strings.NewReader(body))
+	assertStatus(t, rec, http.StatusBadRequest)
+	payload := passwordPolicyErrorPayload{}
+	if err := json.NewDecoder(rec.Body).Decode(&payload); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	var codes []string
+	for _, violation := range payload.Violations {
+		codes = append(codes, violation.Code)
+	}
+	if payload.Error != ErrorWeakPassword || len(codes) != 2 || codes[0] != auth.PasswordTooShort || codes[1] != auth.PasswordCommon {
+		t.Errorf("unexpected response %+v", payload)
+	}
+}
+
+func TestValidRedirectURI(t *testing.T) {
+	tests := []struct {
+		uri  string
+		want bool
+	}{
+		{"https://app.example.com/callback", true},
+		{"http://localhost:3000/callback", true},
+		{"http://127.0.0.1/callback", true},
+		{"http://app.example.com/callback", false},
+		{"https://app.example.com/callback#fragment", false},
+		{"/callback", false},
+		{"javascript:alert(1)", false},
+	}
+	for _, tc := range tests {
+		if got := validRedirectURI(tc.uri); got != tc.want {
+			t.Errorf("validRedirectURI(%q) = %v, want %v", tc.uri, got, tc.want)
+		}
+	}
+}
+
+func TestRedirectWith(t *testing.T) {
+	got := redirectWith("https://app.example.com/callback?app=1", map[string]string{"code": "abc", "state": ""})
+	if want := "https://app.example.com/callback?app=1&code=abc"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestOIDCCallbackState(t *testing.T) {
+	cfg := &ApiConfig{OIDCProviders: map[string]*oidc.Provider{"test": oidc.NewProvider(oidc.Config{Name: "test"}, nil)}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/login/oidc/{provider}/callback", OIDCCallbackHandler(cfg))
+	tests := []struct {
+		name   string
+		target string
+		cookie string
+		status int
+	}{
+		{name: "unknown provider", target: "/api/login/oidc/other/callback?state=abc&code=x", cookie: "abc", status: http.StatusNotFound},
+		{name: "no cookie", target: "/api/login/oidc/test/callback?state=abc&code=x", status: http.StatusUnauthorized},
+		{name: "state mismatch", target: "/api/login/oidc/test/callback?state=abc&code=x", cookie: "xyz", status: http.StatusUnauthorized},
+		{name: "no state", target: "/api/login/oidc/test/callback?code=x", cookie: "abc", status: http.StatusUnauthorized},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tc.target, nil)
+			if tc.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: oidcStateCookie, Value: tc.cookie})
+			}
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+			assertStatus(t, rec, tc.status)
+		})
+	}
+}
+
+func TestRedeemMagicLinkRequiresNonce(t *testing.T) {
+	cfg := &ApiConfig{}
+	rec := executeRequest(t, RedeemMagicLinkHandler(cfg), "POST", "/api/login/magic/redeem", strings.NewReader(`{"token":"abc"}`))
+	assertStatus(t, rec, http.StatusUnauthorized)
+	if body := rec.Body.String(); !strings.Contains(body, ErrorInvalidMagicLink) {
+		t.Errorf("expected %q, got %q", ErrorInvalidMagicLink, body)
+	}
+}
+
+func TestMagicLinkThrottleKey(t *testing.T) {
+	if got := magicLinkThrottleKey(" Walt@BreakingBad.com "); got != "magic:walt@breakingbad.com" {
+		t.Errorf("unexpected key %q", got)
+	}
+	if magicLinkThrottleKey("walt@breakingbad.com") == emailThrottleKey("walt@breakingbad.com") {
+		t.Error("expected magic links and logins to be throttled separately")
+	}
+}
+
+func TestForgotPasswordThrottle(t *testing.T) {
+	tests := []struct {
+		name        string
+		lockedUntil driver.Value
+		wantStatus  int
+		wantCounted bool
+	}{
+		{"locked out", time.Now().Add(time.Minute), http.StatusTooManyRequests, false},
+		{"within the limit", nil, http.StatusAccepted, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			db := &fakeDB{answers: map[string]func([]driver.Value) ([][]driver.Value, error){
+				"GetLoginThrottles": func([]driver.Value) ([][]driver.Value, error) {
+					return [][]driver.Value{{passwordResetThrottleKey("ada@example.com"), int64(3), time.Now(), tc.lockedUntil}}, nil
+				},
+				"RecordLoginFailure": func(args []driver.Value) ([][]driver.Value, error) {
+					return [][]driver.Value{{args[0], int64(1), time.Now(), nil}}, nil
+				},
+			}}
+			cfg := &ApiConfig{
+				DBQueries:      db.queries(),
+				MagicLinkLimit: auth.DefaultMagicLinkPolicy(),
+				IPLockout:      auth.DefaultIPLockoutPolicy(),
+			}
+			rec := executeRequest(t, ForgotPasswordHandler(cfg), "POST", "/api/password/forgot", strings.NewReader(`{"email":" Ada@Example.com"}`))
+			assertStatus(t, rec, tc.wantStatus)
+			counted := db.called("RecordLoginFailure")
+			if (len(counted) == 2 && counted[0][0] == passwordResetThrottleKey("ada@example.com")) != tc.wantCounted {
+				t.Errorf("RecordLoginFailure calls = %v, want counted %v", counted, tc.wantCounted)
+			}
+		})
+	}
+}
+
+func TestSendPasswordResetInOneTransaction(t *testing.T) {
+	db := &fakeDB{answers: map[string]func([]driver.Value) ([][]driver.Value, error){
+		"ExpireUserPasswordResetTokens": func([]driver.Value) ([][]driver.Value, error) { return nil, nil },
+		"CreatePasswordResetToken":      func([]driver.Value) ([][]driver.Value, error) { return nil, nil },
+		"CreateAuditEvent":              func([]driver.Value) ([][]driver.Value, error) { return nil, nil },
+	}}
+	cfg := &ApiConfig{DB: db.open(), DBQueries: db.queries(), Mailer: mail.NewLogMailer("chirpy@example.com", io.Discard)}
+	user := database.User{ID: uuid.New(), Email: "ada@example.com"}
+	if err := cfg.sendPasswordReset(context.Background(), user, "192.0.2.1", "test"); err != nil {
+		t.Fatalf("sendPasswordReset() error = %v", err)
+	}
+	var order []string
+	for _, call := range db.calls {
+		order = append(order, call.name)
+	}
+	want := "ExpireUserPasswordResetTokens CreatePasswordResetToken COMMIT"
+	if got := strings.Join(order, " "); !strings.HasPrefix(got, want) {
+		t.Errorf("calls = %q, want them to start with %q", got, want)
+	}
+}
+
+func TestSessionToken(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		header     string
+		cookies    map[string]string
+		csrf       string
+		want       string
+		fromCookie bool
+		err        error
+	}{
+		{name: "bearer token", method: "POST", header: "Bearer abc", want: "abc"},
+		{name: "bearer wins over cookie", method: "POST", header: "Bearer abc", cookies: map[string]string{accessTokenCookie: "xyz"}, want: "abc"},
+		{name: "no credentials", method: "GET", err: errUnauthenticated},
+		{name: "cookie on safe method", method: "GET", cookies: map[string]string{accessTokenCookie: "xyz"}, want: "xyz", fromCookie: true},
+		{name: "cookie with csrf", method: "POST", cookies: map[string]string{accessTokenCookie: "xyz", csrfCookie: "token"}, csrf: "token", want: "xyz", fromCookie: true},
+		{name: "cookie without csrf header", method: "POST", cookies: map[string]string{accessTokenCookie: "xyz", csrfCookie: "token"}, err: errInvalidCSRFToken},
+		{name: "cookie with wrong csrf", method: "DELETE", cookies: map[string]string{accessTokenCookie: "xyz", csrfCookie: "token"}, csrf: "other", err: errInvalidCSRFToken},
+		{name: "cookie without csrf cookie", method: "PUT", cookies: map[string]string{accessTokenCookie: "xyz"}, csrf: "", err: errInvalidCSRFToken},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, "/api/users", nil)
+			if tc.header != "" {
+				req.Header.Set("Authorization", tc.header)
+			}
+			for name, value := range tc.cookies {
+				req.AddCookie(&http.Cookie{Name: name, Value: value})
+			}
+			if tc.csrf != "" {
+				req.Header.Set(csrfHeader, tc.csrf)
+			}
+			token, fromCookie, err := sessionToken(req, accessTokenCookie)
+			if err != tc.err {
+				t.Fatalf("expected error %v, got %v", tc.err, err)
+			}
+			if err == nil && (token != tc.want || fromCookie != tc.fromCookie) {
+				t.Errorf("expected %q (cookie %v), got %q (cookie %v)", tc.want, tc.fromCookie, token, fromCookie)
+			}
+		})
+	}
+}
+
+func TestRevokeSessionAccessTokens(t *testing.T) {
+	userID, familyID := uuid.New(), uuid.New()
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	db := &fakeDB{answers: map[string]func([]driver.Value) ([][]driver.Value, error){
+		"GetSessionAccessTokens": func([]driver.Value) ([][]driver.Value, error) {
+			return [][]driver.Value{{"jti-1", expiresAt}, {"jti-2", expiresAt}}, nil
+		},
+		"RevokeAccessToken": func([]driver.Value) ([][]driver.Value, error) {
+			return nil, nil
+		},
+	}}
+	cfg := &ApiConfig{DBQueries: db.queries()}
+	if err := cfg.revokeSessionAccessTokens(context.Background(), userID, familyID); err != nil {
+		t.Fatalf("revokeSessionAccessTokens() error = %v", err)
+	}
+	lookup := db.called("GetSessionAccessTokens")
+	if len(lookup) != 1 || lookup[0][0] != userID.String() || lookup[0][1] != familyID.String() {
+		t.Errorf("GetSessionAccessTokens calls = %v, want one for the user's session", lookup)
+	}
+	if len(db.called("RevokeAccessToken")) != 2 || !cfg.Denylist.Contains("jti-1") || !cfg.Denylist.Contains("jti-2") {
+		t.Error("the session's access tokens were not denylisted")
+	}
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	user := database.User{ID: uuid.New(), Role: auth.RoleUser}
+	familyID := uuid.New()
+	now := time.Now()
+	// tokens holds refresh_tokens rows by token hash, in GetRefreshToken's
+	// column order.
+	tokens := map[string][]driver.Value{}
+	addToken := func(hash string, familyID driver.Value) {
+		tokens[hash] = []driver.Value{
+			user.ID.String(), now, now, now.Add(time.Hour), nil, uuid.NewString(), hash,
+			familyID, nil, "test", "192.0.2.1", now, now, "", nil,
+		}
+	}
+	oldToken, _ := auth.MakeRefreshToken()
+	addToken(auth.HashToken(oldToken), familyID.String())
+	db := &fakeDB{answers: map[string]func([]driver.Value) ([][]driver.Value, error){
+		"GetUserByID": fakeUsers(user),
+		"GetRefreshToken": func(args []driver.Value) ([][]driver.Value, error) {
+			if row, ok := tokens[args[0].(string)]; ok {
+				return [][]driver.Value{row}, nil
+			}
+			return nil, nil
+		},
+		"RotateRefreshToken": func(args []driver.Value) ([][]driver.Value, error) {
+			for _, row := range tokens {
+				if row[5] == args[0] && row[8] == nil {
+					row[8] = now
+					return [][]driver.Value{{}}, nil
+				}
+			}
+			return nil, nil
+		},
+		"CreateRefreshToken": func(args []driver.Value) ([][]driver.Value, error) {
+			addToken(args[0].(string), args[1])
+			return [][]driver.Value{tokens[args[0].(string)]}, nil
+		},
+		"RevokeRefreshTokenFamily": func([]driver.Value) ([][]driver.Value, error) { return nil, nil },
+		"CreateAuditEvent":         func([]driver.Value) ([][]driver.Value, error) { return nil, nil },
+	}}
+	cfg := &ApiConfig{DBQueries: db.queries(), Keys: auth.NewHMACKeyring("testsecret")}
+	refresh := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/refresh", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		RefreshTokenHandler(cfg)(rec, req)
+		return rec
+	}
+
+	rec := refresh(oldToken)
+	assertStatus(t, rec, http.StatusOK)
+	payload := tokenPayload{}
+	if err := json.NewDecoder(rec.Body).Decode(&payload); err != nil {
+		t.Fatalf("decoding tokens: %v", err)
+	}
+	if payload.AccessToken == "" || payload.RefreshToken == "" || payload.RefreshToken == oldToken {
+		t.Fatalf("tokens = %+v, want a new access and refresh token", payload)
+	}
+	if tokens[auth.HashToken(oldToken)][8] == nil {
+		t.Error("the used refresh token was not marked rotated")
+	}
+	if created := tokens[auth.HashToken(payload.RefreshToken)]; created == nil || created[7] != familyID.String() {
+		t.Errorf("new refresh token = %v, want it stored in family %s", created, familyID)
+	}
+	if revoked := db.called("RevokeRefreshTokenFamily"); len(revoked) != 0 {
+		t.Fatalf("RevokeRefreshTokenFamily calls = %v, want none for a first use", revoked)
+	}
+
+	rec = refresh(oldToken)
+	assertStatus(t, rec, http.StatusUnauthorized)
+	revoked := db.called("RevokeRefreshTokenFamily")
+	if len(revoked) != 1 || revoked[0][0] != familyID.String() {
+		t.Errorf("RevokeRefreshTokenFamily calls = %v, want the family %s revoked", revoked, familyID)
+	}
+}
+
+func TestSetSessionCookies(t *testing.T) {
+	rec := httptest.NewRecorder()
+	if err := setSessionCookies(rec, "access", "refresh"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cookies := map[string]*http.Cookie{}
+	for _, cookie := range rec.Result().Cookies() {
+		cookies[cookie.Name] = cookie
+	}
+	for _, name := range []string{accessTokenCookie, refreshTokenCookie} {
+		cookie := cookies[name]
+		if cookie == nil || !cookie.HttpOnly || !cookie.Secure || cookie.SameSite != http.SameSiteStrictMode {
+			t.Errorf("expected %s to be an HttpOnly, Secure, SameSite=Strict cookie, got %+v", name, cookie)
+		}
+	}
+	if cookie := cookies[csrfCookie]; cookie == nil || cookie.HttpOnly || cookie.Value == "" {
+		t.Errorf("expected a CSRF cookie readable by scripts, got %+v", cookie)
+	}
+}
+
+func TestDevicePollInterval(t *testing.T) {
+	now := time.Now()
+	polledAt := func(ago time.Duration) sql.NullTime {
+		return sql.NullTime{Time: now.Add(-ago), Valid: true}
+	}
+	tests := []struct {
+		name         string
+		interval     int32
+		lastPolledAt sql.NullTime
+		wantInterval time.Duration
+		wantTooFast  bool
+	}{
+		{"first poll", 5, sql.NullTime{}, 5 * time.Second, false},
+		{"on time", 5, polledAt(6 * time.Second), 5 * time.Second, false},
+		{"too fast", 5, polledAt(2 * time.Second), 5*time.Second + deviceSlowDownStep, true},
+		{"too fast after slowing down", 10, polledAt(7 * time.Second), 10*time.Second + deviceSlowDownStep, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			authorization := database.DeviceAuthorization{IntervalSeconds: tc.interval, LastPolledAt: tc.lastPolledAt}
+			interval, tooFast := devicePollInterval(authorization, now)
+			if interval != tc.wantInterval || tooFast != tc.wantTooFast {
+				t.Errorf("devicePollInterval() = %s, %v, want %s, %v", interval, tooFast, tc.wantInterval, tc.wantTooFast)
+			}
+		})
+	}
+}
+
+func TestDeletionReauthentication(t *testing.T) {
+	hasher := auth.PasswordHasher{}
+	hashedPassword, err := hasher.Hash("correct horse battery staple")
+	if err != nil {
+		t.Fatalf("Hash() error = %v", err)
+	}
+	user := database.User{ID: uuid.New(), HashedPassword: hashedPassword}
+	tests := []struct {
+		name     string
+		password string
+		want     bool
+	}{
+		// A wrong password is refused before the second factor is looked
+		// up, even when the caller sends a code.
+		{"no password", "", false},
+		{"wrong password", "hunter2", false},
+		// Without 2FA the password is enough to delete the account.
+		{"right password", "correct horse battery staple", true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			db := &fakeDB{answers: map[string]func([]driver.Value) ([][]driver.Value, error){
+				"GetLoginThrottles": func([]driver.Value) ([][]driver.Value, error) { return nil, nil },
+				"GetTOTPCredential": func([]driver.Value) ([][]driver.Value, error) { return nil, nil },
+				"RecordLoginFailure": func([]driver.Value) ([][]driver.Value, error) {
+					return [][]driver.Value{{mfaThrottleKey(user.ID), int64(1), time.Now(), nil}}, nil
+				},
+				"ClearLoginThrottle": func([]driver.Value) ([][]driver.Value, error) { return nil, nil },
+			}}
+			cfg := &ApiConfig{DBQueries: db.queries(), PasswordHasher: hasher, MFALockout: auth.DefaultMFALockoutPolicy()}
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest("DELETE", "/api/users/me", nil)
+			if got := cfg.reauthenticate(rec, req, user, tc.password, secondFactorPayload{Code: "123456"}, true); got != tc.want {
+				t.Fatalf("reauthenticate() = %v, want %v", got, tc.want)
+			}
+			if !tc.want {
+				assertStatus(t, rec, http.StatusUnauthorized)
+				if len(db.called("GetTOTPCredential")) != 0 || len(db.called("RecordLoginFailure")) != 1 {
+					t.Error("a wrong password was not counted, or the second factor was looked up")
+				}
+			}
+		})
+	}
+}
+
+func TestLoadErasurePolicy(t *testing.T) {
+	tests := []struct {
+		name        string
+		gracePeriod string
+		chirps      string
+		audit       string
+		want        ErasurePolicy
+		wantErr     bool
+	}{
+		{name: "defaults", want: DefaultErasurePolicy()},
+		{
+			name:        "configured",
+			gracePeriod: "168h",
+			chirps:      EraseChirpsAnonymize,
+			audit:       EraseAuditKeep,
+			want:        ErasurePolicy{GracePeriod: 168 * time.Hour, Chirps: EraseChirpsAnonymize, Audit: EraseAuditKeep},
+		},
+		{name: "immediate", gracePeriod: "0s", want: ErasurePolicy{Chirps: EraseChirpsDelete, Audit: EraseAuditRedact}},
+		{name: "negative grace period", gracePeriod: "-1h", wantErr: true},
+		{name: "bad grace period", gracePeriod: "a month", wantErr: true},
+		{name: "unknown chirps option", chirps: "archive", wantErr: true},
+		{name: "unknown audit option", audit: "delete", wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("ACCOUNT_DELETION_GRACE_PERIOD", tc.gracePeriod)
+			t.Setenv("ERASE_CHIRPS", tc.chirps)
+			t.Setenv("ERASE_AUDIT", tc.audit)
+			got, err := loadErasurePolicy()
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("loadErasurePolicy() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if !tc.wantErr && got != tc.want {
+				t.Errorf("loadErasurePolicy() = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestErasureRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempts int32
+		want     time.Duration
+	}{
+		{1, erasureInterval},
+		{2, 2 * erasureInterval},
+		{4, 8 * erasureInterval},
+		{100, erasureMaxRetryDelay},
+	}
+	for _, tc := range tests {
+		if got := erasureRetryDelay(tc.attempts); got != tc.want {
+			t.Errorf("erasureRetryDelay(%d) = %s, want %s", tc.attempts, got, tc.want)
+		}
+	}
+}
+
+func TestExportDownloadURLExpiry(t *testing.T) {
+	cfg := &ApiConfig{Keys: auth.NewHMACKeyring("secret"), BaseURL: defaultBaseURL}
+	linkExpiry := func(t *testing.T, accountExport database.AccountExport) (string, error) {
+		t.Helper()
+		link, err := cfg.exportDownloadURL(accountExport)
+		if err != nil {
+			t.Fatalf("exportDownloadURL() error = %v", err)
+		}
+		token := link[strings.Index(link, "token=")+len("token="):]
+		claims, err := cfg.Keys.Parse(token, exportDownloadAudience)
+		if err != nil {
+			return token, err
+		}
+		if got := claims.ExpiresAt.Time; got.After(accountExport.ExpiresAt.Time) || got.After(time.Now().Add(ExportLinkDuration)) {
+			t.Errorf("link expires at %s, after the export or the link lifetime", got)
+		}
+		return token, nil
+	}
+
+	t.Run("lives at most a day", func(t *testing.T) {
+		accountExport := database.AccountExport{ID: uuid.New(), ExpiresAt: sql.NullTime{Time: time.Now().Add(7 * 24 * time.Hour), Valid: true}}
+		if _, err := linkExpiry(t, accountExport); err != nil {
+			t.Errorf("Parse() error = %v", err)
+		}
+	})
+	t.Run("ends with the export", func(t *testing.T) {
+		accountExport := database.AccountExport{ID: uuid.New(), ExpiresAt: sql.NullTime{Time: time.Now().Add(time.Minute), Valid: true}}
+		if _, err := linkExpiry(t, accountExport); err != nil {
+			t.Errorf("Parse() error = %v", err)
+		}
+	})
+	t.Run("refused once the export has expired", func(t *testing.T) {
+		accountExport := database.AccountExport{ID: uuid.New(), ExpiresAt: sql.NullTime{Time: time.Now().Add(-time.Minute), Valid: true}}
+		token, err := linkExpiry(t, accountExport)
+		if err == nil {
+			t.Fatal("Parse() accepted a link for an expired export")
+		}
+		req := httptest.NewRequest("GET", "/api/exports/"+accountExport.ID.String()+"/download?token="+token, nil)
+		req.SetPathValue("exportID", accountExport.ID.String())
+		rec := httptest.NewRecorder()
+		DownloadAccountExportHandler(cfg)(rec, req)
+		assertStatus(t, rec, http.StatusUnauthorized)
+	})
+}
+
+func TestDownloadAccountExportLink(t *testing.T) {
+	cfg := &ApiConfig{Keys: auth.NewHMACKeyring("secret"), BaseURL: defaultBaseURL}
+	accountExport := database.AccountExport{
+		ID:        uuid.New(),
+		Status:    exportStatusReady,
+		ExpiresAt: sql.NullTime{Time: time.Now().Add(time.Hour), Valid: true},
+	}
+	link, err := cfg.exportDownloadURL(accountExport)
+	if err != nil {
+		t.Fatalf("exportDownloadURL() error = %v", err)
+	}
+	token := link[strings.Index(link, "token=")+len("token="):]
+	accessToken, err := cfg.Keys.MakeJWT(uuid.New(), auth.RoleUser, time.Hour)
+	if err != nil {
+		t.Fatalf("MakeJWT() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		exportID string
+		token    string
+	}{
+		{"no token", accountExport.ID.String(), ""},
+		{"access token", accountExport.ID.String(), accessToken},
+		{"another export", uuid.NewString(), token},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/exports/"+tc.exportID+"/download?token="+tc.token, nil)
+			req.SetPathValue("exportID", tc.exportID)
+			rec := httptest.NewRecorder()
+			DownloadAccountExportHandler(cfg)(rec, req)
+			assertStatus(t, rec, http.StatusUnauthorized)
+		})
+	}
+}
+
+func TestReadImportArchive(t *testing.T) {
+	archive := &export.Archive{
+		Manifest: export.Manifest{ExportedAt: time.Now(), UserID: "user-1"},
+		Profile:  export.Profile{ID: "user-1", Email: "ada@example.com"},
+		Chirps:   []export.Chirp{{ID: "chirp-1", Body: "hello", Status: chirpStatusPublished, CreatedAt: time.Now()}},
+		Sessions: []export.Session{{Device: "Firefox on Linux"}},
+	}
+	var buf bytes.Buffer
+	if err := archive.Write(&buf); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	got, err := ReadImportArchive(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("ReadImportArchive() error = %v", err)
+	}
+	if got.Manifest.UserID != "user-1" || len(got.Chirps) != 1 {
+		t.Errorf("ReadImportArchive() = %+v, want the manifest and chirps", got)
+	}
+	// Sections an import leaves out are not even decompressed.
+	if got.Profile != (export.Profile{}) || len(got.Sessions) != 0 {
+		t.Errorf("ReadImportArchive() read profile %+v and sessions %+v", got.Profile, got.Sessions)
+	}
+}
+
+func TestImportedChirpID(t *testing.T) {
+	userID, otherUserID := uuid.New(), uuid.New()
+	if importedChirpID(userID, "chirp-1") != importedChirpID(userID, "chirp-1") {
+		t.Error("importing the same chirp twice gives it different IDs")
+	}
+	if importedChirpID(userID, "chirp-1") == importedChirpID(otherUserID, "chirp-1") {
+		t.Error("the same chirp imported into two accounts gets the same ID")
+	}
+	if importedChirpID(userID, "chirp-1") == importedChirpID(userID, "chirp-2") {
+		t.Error("two chirps get the same ID")
+	}
+}
+
+func TestImportArchiveConflicts(t *testing.T) {
+	now := time.Now()
+	long := strings.Repeat("a", MaxChirpLen+1)
+	archive := &export.Archive{Chirps: []export.Chirp{
+		{ID: "", Body: "hello", Status: chirpStatusPublished, CreatedAt: now},
+		{ID: "empty", Body: "", Status: chirpStatusPublished, CreatedAt: now},
+		{ID: "long", Body: long, Status: chirpStatusPublished, CreatedAt: now},
+		{ID: "undated", Body: "hello", Status: chirpStatusPublished},
+		{ID: "future", Body: "hello", Status: chirpStatusPublished, CreatedAt: now.Add(time.Hour)},
+		{ID: "rejected", Body: "hello", Status: chirpStatusRejected, CreatedAt: now},
+		{ID: "unknown", Body: "hello", Status: "draft", CreatedAt: now},
+	}}
+	cfg := &ApiConfig{SpamConfig: spam.DefaultConfig()}
+	report, err := cfg.ImportArchive(context.Background(), database.User{ID: uuid.New()}, archive, true)
+	if err != nil {
+		t.Fatalf("ImportArchive() error = %v", err)
+	}
+	if !report.DryRun || report.Chirps.Total != 7 || report.Chirps.Conflicts != 7 || report.Chirps.Imported != 0 {
+		t.Errorf("report = %+v", report)
+	}
+	for i, conflict := range report.Conflicts {
+		if conflict.ID != archive.Chirps[i].ID || conflict.Reason == "" || conflict.File != export.ChirpsFile {
+			t.Errorf("conflict %d = %+v", i, conflict)
+		}
+	}
+}
+
+func TestImportArchiveStoresScoresWithChirps(t *testing.T) {
+	now := time.Now()
+	user := database.User{ID: uuid.New(), CreatedAt: now.Add(-30 * 24 * time.Hour)}
+	archive := &export.Archive{Chirps: []export.Chirp{
+		{ID: "held-elsewhere", Body: "hello", Status: chirpStatusHeld, CreatedAt: now},
+		{ID: "spam", Body: "https://a https://b https://c @a @b @c @d @e @f", Status: chirpStatusPublished, CreatedAt: now},
+	}}
+	db := &fakeDB{answers: map[string]func([]driver.Value) ([][]driver.Value, error){
+		"GetSingleChirp": func([]driver.Value) ([][]driver.Value, error) { return nil, nil },
+		"ImportChirp":    func([]driver.Value) ([][]driver.Value, error) { return [][]driver.Value{{}}, nil },
+		"CreateChirpSpamScore": func(args []driver.Value) ([][]driver.Value, error) {
+			return [][]driver.Value{{args[0], args[1], []byte("{}"), now}}, nil
+		},
+	}}
+	cfg := &ApiConfig{DB: db.open(), DBQueries: db.queries(), SpamConfig: spam.DefaultConfig()}
+
+	report, err := cfg.ImportArchive(context.Background(), user, archive, false)
+	if err != nil {
+		t.Fatalf("ImportArchive() error = %v", err)
+	}
+	if report.Chirps.Imported != 2 || report.Chirps.Held != 1 {
+		t.Errorf("report = %+v, want 2 imported and 1 held", report)
+	}
+	imports := db.called("ImportChirp")
+	if len(imports) != 2 || imports[0][5] != chirpStatusPublished || imports[1][5] != chirpStatusHeld {
+		t.Fatalf("ImportChirp calls = %v, want the unflagged chirp published and the spam held", imports)
+	}
+	scores := db.called("CreateChirpSpamScore")
+	if len(scores) != 1 || scores[0][0] != imports[1][0] {
+		t.Errorf("CreateChirpSpamScore calls = %v, want one for the held chirp", scores)
+	}
+	if commits := db.called("COMMIT"); len(commits) != 2 {
+		t.Errorf("committed %d transactions, want one per chirp", len(commits))
+	}
+}
+
+func TestLoadRegistrationPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    string
+		invites string
+		want    RegistrationPolicy
+		wantErr bool
+	}{
+		{name: "defaults", want: DefaultRegistrationPolicy()},
+		{name: "invite only", mode: RegistrationInviteOnly, invites: "2", want: RegistrationPolicy{Mode: RegistrationInviteOnly, InvitesPerUser: 2}},
+		{name: "admins invite", mode: RegistrationApproval, invites: "0", want: RegistrationPolicy{Mode: RegistrationApproval}},
+		{name: "unknown mode", mode: "invite", wantErr: true},
+		{name: "negative invites", invites: "-1", wantErr: true},
+		{name: "bad invites", invites: "some", wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("REGISTRATION_MODE", tc.mode)
+			t.Setenv("INVITES_PER_USER", tc.invites)
+			got, err := loadRegistrationPolicy()
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("loadRegistrationPolicy() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if !tc.wantErr && got != tc.want {
+				t.Errorf("loadRegistrationPolicy() = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCreateUserClosedRegistration(t *testing.T) {
+	cfg := &ApiConfig{Registration: RegistrationPolicy{Mode: RegistrationClosed}}
+	rec := executeRequest(t, CreateUserHandler(cfg), "POST", "/api/users", strings.NewReader(`{"email":"ada@example.com","password":"correct horse battery staple"}`))
+	assertStatus(t, rec, http.StatusForbidden)
+}
+
+func TestAdmitSignup(t *testing.T) {
+	tests := []struct {
+		mode        string
+		wantPending bool
+		wantErr     error
+	}{
+		{mode: RegistrationOpen},
+		{mode: RegistrationApproval, wantPending: true},
+		{mode: RegistrationInviteOnly, wantErr: errInviteRequired},
+		{mode: RegistrationClosed, wantErr: errRegistrationClosed},
+	}
+	for _, tc := range tests {
+		t.Run(tc.mode, func(t *testing.T) {
+			cfg := &ApiConfig{Registration: RegistrationPolicy{Mode: tc.mode}}
+			invite, pending, err := cfg.admitSignup(context.Background(), "")
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("admitSignup() error = %v, want %v", err, tc.wantErr)
+			}
+			if pending != tc.wantPending || invite.ID != uuid.Nil {
+				t.Errorf("admitSignup() = %v, %v; want no invite, pending %v", invite.ID, pending, tc.wantPending)
+			}
+		})
+	}
+}
+
+func TestSignupInviteUses(t *testing.T) {
+	invite := database.InviteCode{ID: uuid.New(), CreatedBy: uuid.New(), MaxUses: 1}
+	db := &fakeDB{answers: map[string]func([]driver.Value) ([][]driver.Value, error){
+		// Only "good-code" has a use left.
+		"UseInviteCode": func(args []driver.Value) ([][]driver.Value, error) {
+			if args[0] != auth.HashToken("good-code") {
+				return nil, nil
+			}
+			return [][]driver.Value{{invite.ID.String(), invite.CreatedBy.String(), args[0], int64(1), int64(1), time.Now(), nil}}, nil
+		},
+		"CreateUser": func([]driver.Value) ([][]driver.Value, error) {
+			return nil, &pq.Error{Code: "23505"}
+		},
+		"ReleaseInviteCode": func([]driver.Value) ([][]driver.Value, error) {
+			return nil, nil
+		},
+	}}
+	cfg := &ApiConfig{
+		DBQueries:      db.queries(),
+		PasswordPolicy: auth.DefaultPasswordPolicy(),
+		Registration:   RegistrationPolicy{Mode: RegistrationInviteOnly},
+	}
+	signup := func(code string) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"email":"ada@example.com","password":"correct horse battery staple","invite_code":%q}`, code)
+		return executeRequest(t, CreateUserHandler(cfg), "POST", "/api/users", strings.NewReader(body))
+	}
+
+	t.Run("used up code", func(t *testing.T) {
+		rec := signup("spent-code")
+		assertStatus(t, rec, http.StatusForbidden)
+		assertBodyEqual(t, rec, ErrorInvalidInviteCode)
+		if len(db.called("CreateUser")) != 0 || len(db.called("ReleaseInviteCode")) != 0 {
+			t.Error("a signup with a used up code went ahead")
+		}
+	})
+	t.Run("failed signup gives the use back", func(t *testing.T) {
+		rec := signup("good-code")
+		assertStatus(t, rec, http.StatusConflict)
+		released := db.called("ReleaseInviteCode")
+		if len(released) != 1 || released[0][0] != invite.ID.String() {
+			t.Errorf("ReleaseInviteCode calls = %v, want one for %s", released, invite.ID)
+		}
+	})
+}
+
+func TestIsScopedToken(t *testing.T) {
+	cfg := &ApiConfig{Keys: auth.NewHMACKeyring("secret")}
+	userID := uuid.New()
+	session, err := cfg.Keys.MakeJWT(userID, auth.RoleAdmin, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := cfg.Keys.MakeClientJWT(userID, "client-1", []string{auth.ScopeProfileWrite}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pat, err := auth.MakePersonalAccessToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.isScopedToken(session) {
+		t.Error("session token reported as scoped")
+	}
+	if !cfg.isScopedToken(client) {
+		t.Error("OAuth client token not reported as scoped")
+	}
+	if !cfg.isScopedToken(pat) {
+		t.Error("personal access token not reported as scoped")
+	}
+}
+
+func TestResetOnlyOnDevPlatform(t *testing.T) {
+	cfg := &ApiConfig{Platform: "prod"}
+	rec := executeRequest(t, ResetMetricsHandler(cfg), "POST", "/admin/reset", nil)
+	assertStatus(t, rec, http.StatusForbidden)
+}
@@ -0,0 +1,208 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charlesaraya/chirpy/internal/auth"
+	"github.com/charlesaraya/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	ErrorTooManyLoginAttempts     string = "Too many failed login attempts, try again later"
+	ErrorTooManyMFAAttempts       string = "Too many wrong verification codes, try again later"
+	ErrorTooManyReauthentications string = "Too many wrong passwords or codes, try again later"
+)
+
+// Login throttles are keyed by the email tried, whether or not it belongs
+// to an account, and by client IP. Second factor guesses, and wrong
+// passwords or codes given to confirm a sensitive change, are counted per
+// user.
+const (
+	loginThrottleEmail string = "email:"
+	loginThrottleIP    string = "ip:"
+	loginThrottleMFA   string = "mfa:"
+)
+
+// dummyPasswordHash is verified against when a login names an unknown email,
+// so the response takes as long as a wrong password would.
+var dummyPasswordHash struct {
+	once sync.Once
+	hash string
+}
+
+type lockedAccountPayload struct {
+	Email          string `json:"email"`
+	UserID         string `json:"user_id,omitempty"`
+	FailedAttempts int32  `json:"failed_attempts"`
+	LastFailedAt   string `json:"last_failed_at"`
+	LockedUntil    string `json:"locked_until"`
+}
+
+func emailThrottleKey(email string) string {
+	return loginThrottleEmail + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipThrottleKey(req *http.Request) string {
+	return loginThrottleIP + clientIP(req)
+}
+
+func mfaThrottleKey(userID uuid.UUID) string {
+	return loginThrottleMFA + userID.String()
+}
+
+// loginLockedFor returns how long logins for email from the request's client
+// stay locked, or zero if they are allowed.
+func (cfg *ApiConfig) loginLockedFor(req *http.Request, email string) (time.Duration, error) {
+	return cfg.throttleLockedFor(req.Context(), emailThrottleKey(email), ipThrottleKey(req))
+}
+
+// throttleLockedFor returns how long the longest lock among keys lasts.
+func (cfg *ApiConfig) throttleLockedFor(ctx context.Context, keys ...string) (time.Duration, error) {
+	throttles, err := cfg.DBQueries.GetLoginThrottles(ctx, keys)
+	if err != nil {
+		return 0, err
+	}
+	var lockedFor time.Duration
+	for _, throttle := range throttles {
+		if throttle.LockedUntil.Valid {
+			lockedFor = max(lockedFor, time.Until(throttle.LockedUntil.Time))
+		}
+	}
+	return lockedFor, nil
+}
+
+// recordLoginFailure counts a failed login against the email and the
+// client, locking either out once its policy says so. userID is uuid.Nil
+// for an unknown email. Failures are logged, as the login fails either way.
+func (cfg *ApiConfig) recordLoginFailure(req *http.Request, email string, userID uuid.UUID) {
+	if delay := cfg.countLoginFailure(req.Context(), emailThrottleKey(email), cfg.AccountLockout); delay > 0 {
+		cfg.recordAudit(req, auditLoginLocked, uuid.Nil, userID, map[string]any{"email": email, "locked_for": delay.String()})
+	}
+	if delay := cfg.countLoginFailure(req.Context(), ipThrottleKey(req), cfg.IPLockout); delay > 0 {
+		cfg.recordAudit(req, auditLoginLocked, uuid.Nil, uuid.Nil, map[string]any{"ip": clientIP(req), "locked_for": delay.String()})
+	}
+}
+
+func (cfg *ApiConfig) countLoginFailure(ctx context.Context, key string, policy auth.LockoutPolicy) time.Duration {
+	_, delay, err := cfg.recordThrottleFailure(ctx, key, policy)
+	if err != nil {
+		log.Printf("recording login failure: %v", err)
+		return 0
+	}
+	return delay
+}
+
+// recordThrottleFailure counts a failure against key and locks it if policy
+// says so. It returns the failures counted so far and how long key is now
+// locked for.
+func (cfg *ApiConfig) recordThrottleFailure(ctx context.Context, key string, policy auth.LockoutPolicy) (int, time.Duration, error) {
+	params := database.RecordLoginFailureParams{
+		Key:         key,
+		ResetBefore: time.Now().Add(-policy.ResetAfter),
+	}
+	throttle, err := cfg.DBQueries.RecordLoginFailure(ctx, params)
+	if err != nil {
+		return 0, 0, err
+	}
+	failures := int(throttle.FailedAttempts)
+	delay := policy.Delay(failures)
+	if delay == 0 {
+		return failures, 0, nil
+	}
+	lockParams := database.LockLoginParams{
+		Key:         key,
+		LockedUntil: sql.NullTime{Time: time.Now().Add(delay), Valid: true},
+	}
+	if err := cfg.DBQueries.LockLogin(ctx, lockParams); err != nil {
+		return failures, 0, err
+	}
+	return failures, delay, nil
+}
+
+// clearLoginFailures forgets the failed logins for email after a successful
+// one. The client's count is kept, so one valid account cannot be used to
+// reset it while guessing at others.
+func (cfg *ApiConfig) clearLoginFailures(ctx context.Context, email string) {
+	if _, err := cfg.DBQueries.ClearLoginThrottle(ctx, emailThrottleKey(email)); err != nil {
+		log.Printf("clearing login failures: %v", err)
+	}
+}
+
+// verifyDummyPassword spends as long as verifying a real password would.
+func (cfg *ApiConfig) verifyDummyPassword(password string) {
+	dummyPasswordHash.once.Do(func() {
+		hash, err := cfg.PasswordHasher.Hash(uuid.NewString())
+		if err != nil {
+			log.Printf("hashing dummy password: %v", err)
+		}
+		dummyPasswordHash.hash = hash
+	})
+	cfg.PasswordHasher.Verify(dummyPasswordHash.hash, password)
+}
+
+func respondWithLoginLocked(res http.ResponseWriter, lockedFor time.Duration) {
+	respondWithTooManyRequests(res, lockedFor, ErrorTooManyLoginAttempts)
+}
+
+func respondWithTooManyRequests(res http.ResponseWriter, retryAfter time.Duration, message string) {
+	res.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	http.Error(res, message, http.StatusTooManyRequests)
+}
+
+// GetLockedAccountsHandler lists the emails currently locked out after
+// too many failed logins.
+func GetLockedAccountsHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		locked, err := apiCfg.DBQueries.GetLockedAccounts(req.Context())
+		if err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		payload := make([]lockedAccountPayload, len(locked))
+		for i, account := range locked {
+			payload[i] = lockedAccountPayload{
+				Email:          strings.TrimPrefix(account.Key, loginThrottleEmail),
+				FailedAttempts: account.FailedAttempts,
+				LastFailedAt:   account.LastFailedAt.Format(TimeFormat),
+				LockedUntil:    account.LockedUntil.Time.Format(TimeFormat),
+			}
+			if account.UserID.Valid {
+				payload[i].UserID = account.UserID.UUID.String()
+			}
+		}
+		respondWithJSON(res, http.StatusOK, payload)
+	}
+}
+
+// UnlockUserHandler lifts a lockout on a user's account and forgets its
+// failed logins and wrong second factor codes.
+func UnlockUserHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		userUUID, err := uuid.Parse(req.PathValue("userID"))
+		if err != nil {
+			http.Error(res, ErrorNotFound, http.StatusNotFound)
+			return
+		}
+		user, err := apiCfg.DBQueries.GetUserByID(req.Context(), userUUID)
+		if err != nil {
+			http.Error(res, ErrorNotFound, http.StatusNotFound)
+			return
+		}
+		for _, key := range []string{emailThrottleKey(user.Email), mfaThrottleKey(user.ID)} {
+			if _, err := apiCfg.DBQueries.ClearLoginThrottle(req.Context(), key); err != nil {
+				http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+				return
+			}
+		}
+		res.WriteHeader(http.StatusNoContent)
+	}
+}
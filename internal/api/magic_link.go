@@ -0,0 +1,186 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/charlesaraya/chirpy/internal/auth"
+	"github.com/charlesaraya/chirpy/internal/database"
+	"github.com/charlesaraya/chirpy/internal/mail"
+	"github.com/google/uuid"
+)
+
+const (
+	MagicLinkDuration time.Duration = 15 * time.Minute
+	// magicLinkNonceCookie holds a secret given to the browser that asked
+	// for a link. A link only works alongside it, so a leaked or forwarded
+	// email cannot be used from another browser.
+	magicLinkNonceCookie string = "chirpy_magic_nonce"
+	magicLinkCookiePath  string = "/api/login/magic"
+	// Magic link requests share the login throttle table, counted per
+	// email whether or not it belongs to an account.
+	magicLinkThrottle string = "magic:"
+)
+
+const (
+	ErrorInvalidMagicLink  string = "Invalid or expired sign-in link; open it in the browser you requested it from"
+	ErrorTooManyMagicLinks string = "Too many sign-in links requested, try again later"
+)
+
+func magicLinkThrottleKey(email string) string {
+	return magicLinkThrottle + strings.ToLower(strings.TrimSpace(email))
+}
+
+// RequestMagicLinkHandler emails a single-use sign-in link. Like
+// ForgotPasswordHandler it answers the same way whether or not the address
+// belongs to an account, and the rate limit applies to unknown addresses too.
+func RequestMagicLinkHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		type reqPayload struct {
+			Email string `json:"email"`
+		}
+		params := reqPayload{}
+		if err := json.NewDecoder(req.Body).Decode(&params); err != nil {
+			http.Error(res, ErrorSomethingWentWrong, http.StatusBadRequest)
+			return
+		}
+		key := magicLinkThrottleKey(params.Email)
+		lockedFor, err := apiCfg.throttleLockedFor(req.Context(), key)
+		if err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		if lockedFor <= 0 {
+			lockedFor = apiCfg.countLoginFailure(req.Context(), key, apiCfg.MagicLinkLimit)
+			if lockedFor > 0 {
+				apiCfg.recordAudit(req, auditMagicLinkLimited, uuid.Nil, uuid.Nil, map[string]any{"email": params.Email, "locked_for": lockedFor.String()})
+			}
+		}
+		if lockedFor > 0 {
+			respondWithTooManyRequests(res, lockedFor, ErrorTooManyMagicLinks)
+			return
+		}
+		// Every request gets a nonce, so the response does not reveal
+		// whether a link was sent.
+		nonce, err := auth.MakeRefreshToken()
+		if err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		http.SetCookie(res, &http.Cookie{
+			Name:     magicLinkNonceCookie,
+			Value:    nonce,
+			Path:     magicLinkCookiePath,
+			MaxAge:   int(MagicLinkDuration.Seconds()),
+			HttpOnly: true,
+			Secure:   strings.HasPrefix(apiCfg.BaseURL, "https://"),
+			SameSite: http.SameSiteStrictMode,
+		})
+		user, err := apiCfg.DBQueries.GetUser(req.Context(), params.Email)
+		if err != nil || user.SuspendedAt.Valid || user.PendingApproval {
+			res.WriteHeader(http.StatusAccepted)
+			return
+		}
+		token, err := auth.MakeRefreshToken()
+		if err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		// Only the newest link works.
+		if err := apiCfg.DBQueries.ExpireUserMagicLinkTokens(req.Context(), user.ID); err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		tokenParams := database.CreateMagicLinkTokenParams{
+			UserID:    user.ID,
+			TokenHash: auth.HashToken(token),
+			NonceHash: auth.HashToken(nonce),
+			ExpiresAt: time.Now().Add(MagicLinkDuration),
+		}
+		if err := apiCfg.DBQueries.CreateMagicLinkToken(req.Context(), tokenParams); err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		link := apiCfg.BaseURL + "/app/magic-login.html?token=" + url.QueryEscape(token)
+		apiCfg.sendMail(mail.Message{
+			To:      user.Email,
+			Subject: "Your Chirpy sign-in link",
+			Body: fmt.Sprintf("Someone asked to sign in to your Chirpy account.\n\n"+
+				"To sign in, open this link in the same browser within the next 15 minutes:\n\n%s\n\n"+
+				"If this wasn't you, you can ignore this email.", link),
+		})
+		apiCfg.recordAudit(req, auditMagicLinkRequested, uuid.Nil, user.ID, nil)
+		res.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// RedeemMagicLinkHandler signs in with a token from RequestMagicLinkHandler
+// and answers like LoginUserHandler. The request must carry the nonce cookie
+// set when the link was requested.
+func RedeemMagicLinkHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		type reqPayload struct {
+			Token string `json:"token"`
+		}
+		params := reqPayload{}
+		if err := json.NewDecoder(req.Body).Decode(&params); err != nil {
+			http.Error(res, ErrorSomethingWentWrong, http.StatusBadRequest)
+			return
+		}
+		nonce, err := req.Cookie(magicLinkNonceCookie)
+		if err != nil {
+			http.Error(res, ErrorInvalidMagicLink, http.StatusUnauthorized)
+			return
+		}
+		magicLink, err := apiCfg.DBQueries.GetMagicLinkToken(req.Context(), auth.HashToken(params.Token))
+		if err != nil {
+			http.Error(res, ErrorInvalidMagicLink, http.StatusUnauthorized)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(magicLink.NonceHash), []byte(auth.HashToken(nonce.Value))) != 1 {
+			apiCfg.recordAudit(req, auditLoginFailed, uuid.Nil, magicLink.UserID, map[string]any{"reason": "magic_link_other_browser"})
+			http.Error(res, ErrorInvalidMagicLink, http.StatusUnauthorized)
+			return
+		}
+		user, err := apiCfg.DBQueries.GetUserByID(req.Context(), magicLink.UserID)
+		if err != nil {
+			http.Error(res, ErrorInvalidMagicLink, http.StatusUnauthorized)
+			return
+		}
+		used, err := apiCfg.DBQueries.UseMagicLinkToken(req.Context(), magicLink.ID)
+		if err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		if used == 0 {
+			http.Error(res, ErrorInvalidMagicLink, http.StatusUnauthorized)
+			return
+		}
+		http.SetCookie(res, &http.Cookie{Name: magicLinkNonceCookie, Path: magicLinkCookiePath, MaxAge: -1})
+		if user.SuspendedAt.Valid {
+			apiCfg.recordAudit(req, auditLoginFailed, user.ID, user.ID, map[string]any{"reason": "suspended"})
+			http.Error(res, ErrorAccountSuspended, http.StatusForbidden)
+			return
+		}
+		if user.PendingApproval {
+			apiCfg.recordAudit(req, auditLoginFailed, user.ID, user.ID, map[string]any{"reason": "pending_approval"})
+			http.Error(res, ErrorAccountPendingApproval, http.StatusForbidden)
+			return
+		}
+		_, mfaEnabled, err := apiCfg.totpCredential(req.Context(), user.ID)
+		if err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		if mfaEnabled {
+			respondWithMFAChallenge(res, req, apiCfg, user)
+			return
+		}
+		startSession(res, req, apiCfg, user, loginMethodMagicLink)
+	}
+}
@@ -0,0 +1,475 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/charlesaraya/chirpy/internal/auth"
+	"github.com/charlesaraya/chirpy/internal/database"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	ErrorInvalidMFACode    string = "Invalid verification code"
+	ErrorMFAAlreadyEnabled string = "Two-factor authentication is already enabled"
+	ErrorMFANotEnabled     string = "Two-factor authentication is not enabled"
+)
+
+const (
+	// mfaChallengeAudience keeps challenge tokens from being accepted as
+	// access tokens, and the other way round.
+	mfaChallengeAudience string        = "chirpy:mfa"
+	MFAChallengeDuration time.Duration = 5 * time.Minute
+	totpIssuer           string        = "Chirpy"
+	recoveryCodeCount    int           = 10
+	// maxMFAChallengeFailures is how many wrong codes a user may send before
+	// each further one also voids the challenge, so guessing on means
+	// giving the password again.
+	maxMFAChallengeFailures int = 3
+)
+
+type mfaChallengePayload struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+}
+
+type recoveryCodesPayload struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// secondFactorPayload carries either a TOTP code or a recovery code.
+type secondFactorPayload struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// totpCredential returns the user's TOTP credential and whether it has been
+// confirmed, which is what turns two-factor login on.
+func (cfg *ApiConfig) totpCredential(ctx context.Context, userID uuid.UUID) (database.TotpCredential, bool, error) {
+	credential, err := cfg.DBQueries.GetTOTPCredential(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return credential, false, nil
+	}
+	if err != nil {
+		return credential, false, err
+	}
+	return credential, credential.ConfirmedAt.Valid, nil
+}
+
+// checkTOTP accepts a code at most once, even within its time step.
+func (cfg *ApiConfig) checkTOTP(ctx context.Context, credential database.TotpCredential, code string) (bool, error) {
+	step, ok := auth.ValidateTOTP(credential.Secret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+	params := database.UseTOTPStepParams{
+		UserID:       credential.UserID,
+		LastUsedStep: step,
+	}
+	used, err := cfg.DBQueries.UseTOTPStep(ctx, params)
+	if err != nil {
+		return false, err
+	}
+	return used == 1, nil
+}
+
+// checkRecoveryCode consumes one of the user's unused recovery codes. They
+// are random enough to be looked up by a fast hash. Codes issued before that
+// carry a password hash and are checked one by one, until they are used or
+// replaced.
+func (cfg *ApiConfig) checkRecoveryCode(ctx context.Context, userID uuid.UUID, code string) (bool, error) {
+	code = auth.NormalizeRecoveryCode(code)
+	used, err := cfg.DBQueries.UseRecoveryCodeByHash(ctx, database.UseRecoveryCodeByHashParams{
+		UserID:   userID,
+		CodeHash: auth.HashToken(code),
+	})
+	if err != nil {
+		return false, err
+	}
+	if used == 1 {
+		return true, nil
+	}
+	codes, err := cfg.DBQueries.GetUnusedLegacyRecoveryCodes(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	for _, recoveryCode := range codes {
+		if cfg.PasswordHasher.Verify(recoveryCode.CodeHash, code) != nil {
+			continue
+		}
+		used, err := cfg.DBQueries.UseRecoveryCode(ctx, recoveryCode.ID)
+		if err != nil {
+			return false, err
+		}
+		return used == 1, nil
+	}
+	return false, nil
+}
+
+// checkSecondFactor verifies a TOTP code or, failing that, a recovery code,
+// and returns the login method it amounts to.
+func (cfg *ApiConfig) checkSecondFactor(ctx context.Context, credential database.TotpCredential, params secondFactorPayload) (string, bool, error) {
+	if params.RecoveryCode != "" {
+		ok, err := cfg.checkRecoveryCode(ctx, credential.UserID, params.RecoveryCode)
+		return loginMethodRecoveryCode, ok, err
+	}
+	ok, err := cfg.checkTOTP(ctx, credential, params.Code)
+	return loginMethodTOTP, ok, err
+}
+
+// replaceRecoveryCodes discards the user's recovery codes and returns a new
+// set. Only their hashes are kept.
+func (cfg *ApiConfig) replaceRecoveryCodes(ctx context.Context, userID uuid.UUID) ([]string, error) {
+	codes, err := auth.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.DBQueries.DeleteRecoveryCodes(ctx, userID); err != nil {
+		return nil, err
+	}
+	for _, code := range codes {
+		params := database.CreateRecoveryCodeParams{
+			UserID:   userID,
+			CodeHash: auth.HashToken(auth.NormalizeRecoveryCode(code)),
+		}
+		if err := cfg.DBQueries.CreateRecoveryCode(ctx, params); err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+// reauthenticate asks for the password and a second factor again before a
+// sensitive change. With mfaOptional, users without two-factor
+// authentication only need the password; otherwise they cannot pass. Wrong
+// answers count against the user's second factor throttle, so a stolen
+// access token cannot be used to guess them. When the answers are wrong or
+// the user is locked out it writes the error and returns false.
+func (cfg *ApiConfig) reauthenticate(res http.ResponseWriter, req *http.Request, user database.User, password string, factor secondFactorPayload, mfaOptional bool) bool {
+	key := mfaThrottleKey(user.ID)
+	lockedFor, err := cfg.throttleLockedFor(req.Context(), key)
+	if err != nil {
+		http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+		return false
+	}
+	if lockedFor > 0 {
+		respondWithTooManyRequests(res, lockedFor, ErrorTooManyReauthentications)
+		return false
+	}
+	ok, err := cfg.checkOwnerAnswers(req.Context(), user, password, factor, mfaOptional)
+	if err != nil {
+		http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+		return false
+	}
+	if !ok {
+		_, delay, err := cfg.recordThrottleFailure(req.Context(), key, cfg.MFALockout)
+		if err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return false
+		}
+		if delay > 0 {
+			cfg.recordAudit(req, auditLoginLocked, uuid.Nil, user.ID, map[string]any{"reason": "wrong_reauthentication", "locked_for": delay.String()})
+		}
+		http.Error(res, ErrorUnauthorized, http.StatusUnauthorized)
+		return false
+	}
+	if _, err := cfg.DBQueries.ClearLoginThrottle(req.Context(), key); err != nil {
+		log.Printf("clearing reauthentication failures: %v", err)
+	}
+	return true
+}
+
+// checkOwnerAnswers checks the password and a second factor, which
+// mfaOptional waives for users without two-factor authentication.
+func (cfg *ApiConfig) checkOwnerAnswers(ctx context.Context, user database.User, password string, factor secondFactorPayload, mfaOptional bool) (bool, error) {
+	if cfg.PasswordHasher.Verify(user.HashedPassword, password) != nil {
+		return false, nil
+	}
+	credential, enabled, err := cfg.totpCredential(ctx, user.ID)
+	if err != nil {
+		return false, err
+	}
+	if !enabled {
+		return mfaOptional, nil
+	}
+	_, ok, err := cfg.checkSecondFactor(ctx, credential, factor)
+	return ok, err
+}
+
+// respondWithMFAChallenge answers a correct password with a short-lived
+// token that LoginMFAHandler trades, along with a code, for a session.
+func respondWithMFAChallenge(res http.ResponseWriter, req *http.Request, apiCfg *ApiConfig, user database.User) {
+	now := time.Now()
+	token, err := apiCfg.Keys.Sign(&auth.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   user.ID.String(),
+			Audience:  jwt.ClaimStrings{mfaChallengeAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(MFAChallengeDuration)),
+		},
+	})
+	if err != nil {
+		http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+		return
+	}
+	respondWithJSON(res, http.StatusOK, mfaChallengePayload{MFARequired: true, MFAToken: token})
+}
+
+// recordMFAFailure counts a wrong second factor against the user, locking
+// two-step logins once MFALockout says so, and voids the challenge after
+// maxMFAChallengeFailures.
+func (cfg *ApiConfig) recordMFAFailure(req *http.Request, userID uuid.UUID, challenge *auth.Claims) error {
+	failures, delay, err := cfg.recordThrottleFailure(req.Context(), mfaThrottleKey(userID), cfg.MFALockout)
+	if err != nil {
+		return err
+	}
+	if delay > 0 {
+		cfg.recordAudit(req, auditLoginLocked, uuid.Nil, userID, map[string]any{"reason": "wrong_mfa_code", "locked_for": delay.String()})
+	}
+	if failures >= maxMFAChallengeFailures {
+		return cfg.revokeAccessToken(req.Context(), userID, challenge)
+	}
+	return nil
+}
+
+// LoginMFAHandler completes a two-step login. Each challenge token works
+// once, and wrong codes are throttled per user.
+func LoginMFAHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		type reqPayload struct {
+			MFAToken string `json:"mfa_token"`
+			secondFactorPayload
+		}
+		params := reqPayload{}
+		if err := json.NewDecoder(req.Body).Decode(&params); err != nil {
+			http.Error(res, ErrorSomethingWentWrong, http.StatusBadRequest)
+			return
+		}
+		claims, err := apiCfg.Keys.Parse(params.MFAToken, mfaChallengeAudience)
+		if err != nil || apiCfg.Denylist.Contains(claims.ID) {
+			http.Error(res, ErrorUnauthorized, http.StatusUnauthorized)
+			return
+		}
+		userUUID, err := uuid.Parse(claims.Subject)
+		if err != nil {
+			http.Error(res, ErrorUnauthorized, http.StatusUnauthorized)
+			return
+		}
+		user, err := apiCfg.DBQueries.GetUserByID(req.Context(), userUUID)
+		if err != nil {
+			http.Error(res, ErrorUnauthorized, http.StatusUnauthorized)
+			return
+		}
+		if user.SuspendedAt.Valid {
+			http.Error(res, ErrorAccountSuspended, http.StatusForbidden)
+			return
+		}
+		lockedFor, err := apiCfg.throttleLockedFor(req.Context(), mfaThrottleKey(user.ID))
+		if err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		if lockedFor > 0 {
+			respondWithTooManyRequests(res, lockedFor, ErrorTooManyMFAAttempts)
+			return
+		}
+		credential, enabled, err := apiCfg.totpCredential(req.Context(), user.ID)
+		if err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		if !enabled {
+			http.Error(res, ErrorUnauthorized, http.StatusUnauthorized)
+			return
+		}
+		method, ok, err := apiCfg.checkSecondFactor(req.Context(), credential, params.secondFactorPayload)
+		if err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			apiCfg.recordAudit(req, auditLoginFailed, uuid.Nil, user.ID, map[string]any{"reason": "wrong_mfa_code"})
+			if err := apiCfg.recordMFAFailure(req, user.ID, claims); err != nil {
+				http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+				return
+			}
+			http.Error(res, ErrorInvalidMFACode, http.StatusUnauthorized)
+			return
+		}
+		if err := apiCfg.revokeAccessToken(req.Context(), user.ID, claims); err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		if _, err := apiCfg.DBQueries.ClearLoginThrottle(req.Context(), mfaThrottleKey(user.ID)); err != nil {
+			log.Printf("clearing mfa failures: %v", err)
+		}
+		startSession(res, req, apiCfg, user, method)
+	}
+}
+
+// EnrollTOTPHandler starts two-factor enrollment by generating a secret for
+// the user's authenticator app. Nothing changes at login until the secret is
+// confirmed with a code.
+func EnrollTOTPHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		type resPayload struct {
+			Secret     string `json:"secret"`
+			OTPAuthURI string `json:"otpauth_uri"`
+		}
+		user, err := apiCfg.authenticate(req)
+		if err != nil {
+			respondWithAuthError(res, err)
+			return
+		}
+		_, enabled, err := apiCfg.totpCredential(req.Context(), user.ID)
+		if err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		if enabled {
+			http.Error(res, ErrorMFAAlreadyEnabled, http.StatusConflict)
+			return
+		}
+		secret, err := auth.GenerateTOTPSecret()
+		if err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		params := database.CreateTOTPCredentialParams{
+			UserID: user.ID,
+			Secret: secret,
+		}
+		if _, err := apiCfg.DBQueries.CreateTOTPCredential(req.Context(), params); err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		payload := resPayload{
+			Secret:     secret,
+			OTPAuthURI: auth.TOTPURI(totpIssuer, user.Email, secret),
+		}
+		respondWithJSON(res, http.StatusOK, payload)
+	}
+}
+
+// ConfirmTOTPHandler turns two-factor login on once the user proves their
+// authenticator app works, and hands out recovery codes. This is the only
+// time the codes are shown.
+func ConfirmTOTPHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		user, err := apiCfg.authenticate(req)
+		if err != nil {
+			respondWithAuthError(res, err)
+			return
+		}
+		params := secondFactorPayload{}
+		if err := json.NewDecoder(req.Body).Decode(&params); err != nil {
+			http.Error(res, ErrorSomethingWentWrong, http.StatusBadRequest)
+			return
+		}
+		credential, enabled, err := apiCfg.totpCredential(req.Context(), user.ID)
+		if err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		if enabled {
+			http.Error(res, ErrorMFAAlreadyEnabled, http.StatusConflict)
+			return
+		}
+		if credential.Secret == "" {
+			http.Error(res, ErrorMFANotEnabled, http.StatusBadRequest)
+			return
+		}
+		ok, err := apiCfg.checkTOTP(req.Context(), credential, params.Code)
+		if err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(res, ErrorInvalidMFACode, http.StatusBadRequest)
+			return
+		}
+		if err := apiCfg.DBQueries.ConfirmTOTPCredential(req.Context(), user.ID); err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		codes, err := apiCfg.replaceRecoveryCodes(req.Context(), user.ID)
+		if err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		apiCfg.recordAudit(req, auditMFAEnabled, user.ID, user.ID, nil)
+		respondWithJSON(res, http.StatusOK, recoveryCodesPayload{RecoveryCodes: codes})
+	}
+}
+
+// DisableTOTPHandler turns two-factor login off. It needs the password and a
+// current code, not just an access token.
+func DisableTOTPHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		type reqPayload struct {
+			Password string `json:"password"`
+			secondFactorPayload
+		}
+		user, err := apiCfg.authenticate(req)
+		if err != nil {
+			respondWithAuthError(res, err)
+			return
+		}
+		params := reqPayload{}
+		if err := json.NewDecoder(req.Body).Decode(&params); err != nil {
+			http.Error(res, ErrorSomethingWentWrong, http.StatusBadRequest)
+			return
+		}
+		if !apiCfg.reauthenticate(res, req, user, params.Password, params.secondFactorPayload, false) {
+			return
+		}
+		if err := apiCfg.DBQueries.DeleteTOTPCredential(req.Context(), user.ID); err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		if err := apiCfg.DBQueries.DeleteRecoveryCodes(req.Context(), user.ID); err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		apiCfg.recordAudit(req, auditMFADisabled, user.ID, user.ID, nil)
+		res.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// RegenerateRecoveryCodesHandler replaces the user's recovery codes, for
+// when they are lost or running out. It needs the password and a current
+// code.
+func RegenerateRecoveryCodesHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		type reqPayload struct {
+			Password string `json:"password"`
+			secondFactorPayload
+		}
+		user, err := apiCfg.authenticate(req)
+		if err != nil {
+			respondWithAuthError(res, err)
+			return
+		}
+		params := reqPayload{}
+		if err := json.NewDecoder(req.Body).Decode(&params); err != nil {
+			http.Error(res, ErrorSomethingWentWrong, http.StatusBadRequest)
+			return
+		}
+		if !apiCfg.reauthenticate(res, req, user, params.Password, params.secondFactorPayload, false) {
+			return
+		}
+		codes, err := apiCfg.replaceRecoveryCodes(req.Context(), user.ID)
+		if err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		apiCfg.recordAudit(req, auditMFACodesReset, user.ID, user.ID, nil)
+		respondWithJSON(res, http.StatusOK, recoveryCodesPayload{RecoveryCodes: codes})
+	}
+}
@@ -0,0 +1,234 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/charlesaraya/chirpy/internal/auth"
+	"github.com/charlesaraya/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+var (
+	errUnauthenticated       = errors.New("unauthenticated")
+	errUserSuspended         = errors.New("user is suspended")
+	errPasswordResetRequired = errors.New("password reset required")
+	errInsufficientScope     = errors.New("token lacks the required scope")
+)
+
+// authenticate resolves the user behind the request's bearer token. Revoked
+// tokens are rejected with errUnauthenticated, suspended users with
+// errUserSuspended, and users who were asked to reset their password with
+// errPasswordResetRequired. Personal access tokens are rejected with
+// errInsufficientScope; routes open to them use authenticateWithScope.
+func (cfg *ApiConfig) authenticate(req *http.Request) (database.User, error) {
+	return cfg.authenticateWithScope(req, "")
+}
+
+// authenticateWithScope is authenticate for routes that also accept personal
+// access tokens carrying scope. Session tokens are not scoped.
+func (cfg *ApiConfig) authenticateWithScope(req *http.Request, scope string) (database.User, error) {
+	user, err := cfg.authenticateForPasswordChange(req, scope)
+	if err != nil {
+		return database.User{}, err
+	}
+	if user.PasswordResetRequired {
+		return database.User{}, errPasswordResetRequired
+	}
+	return user, nil
+}
+
+// authenticateForPasswordChange is authenticateWithScope for the one route a
+// user with a pending password reset may still call.
+func (cfg *ApiConfig) authenticateForPasswordChange(req *http.Request, scope string) (database.User, error) {
+	token, _, err := sessionToken(req, accessTokenCookie)
+	if err != nil {
+		return database.User{}, err
+	}
+	var user database.User
+	if auth.IsPersonalAccessToken(token) {
+		user, err = cfg.authenticatePersonalAccessToken(req.Context(), token, scope)
+	} else {
+		user, err = cfg.authenticateJWT(req.Context(), token, scope)
+	}
+	if err != nil {
+		return database.User{}, err
+	}
+	if user.SuspendedAt.Valid {
+		return database.User{}, errUserSuspended
+	}
+	return user, nil
+}
+
+// isScopedToken reports whether an authenticated token was issued to a
+// script or app with limited scopes: a personal access token, or an access
+// token issued to an OAuth client.
+func (cfg *ApiConfig) isScopedToken(token string) bool {
+	if auth.IsPersonalAccessToken(token) {
+		return true
+	}
+	claims, err := cfg.Keys.ParseJWT(token)
+	return err != nil || claims.ClientID != ""
+}
+
+// authenticateJWT resolves the user behind an access token. Tokens issued to
+// OAuth clients must also carry scope.
+func (cfg *ApiConfig) authenticateJWT(ctx context.Context, token, scope string) (database.User, error) {
+	user, claims, err := cfg.verifyJWT(ctx, token)
+	if err != nil {
+		return database.User{}, err
+	}
+	if claims.ClientID != "" && (scope == "" || !auth.HasScope(claims.Scopes(), scope)) {
+		return database.User{}, errInsufficientScope
+	}
+	return user, nil
+}
+
+// verifyJWT checks that an access token is valid and has not been revoked,
+// and returns its user and claims.
+func (cfg *ApiConfig) verifyJWT(ctx context.Context, token string) (database.User, *auth.Claims, error) {
+	claims, err := cfg.Keys.ParseJWT(token)
+	if err != nil || cfg.Denylist.Contains(claims.ID) {
+		return database.User{}, nil, errUnauthenticated
+	}
+	userUUID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return database.User{}, nil, errUnauthenticated
+	}
+	user, err := cfg.DBQueries.GetUserByID(ctx, userUUID)
+	if err != nil {
+		return database.User{}, nil, errUnauthenticated
+	}
+	if user.TokensValidAfter.Valid && claims.IssuedBefore(user.TokensValidAfter.Time) {
+		return database.User{}, nil, errUnauthenticated
+	}
+	if claims.ClientID != "" && !cfg.clientStillAuthorized(ctx, user.ID, claims) {
+		return database.User{}, nil, errUnauthenticated
+	}
+	return user, claims, nil
+}
+
+// viewerID returns the ID of the user making the request, or uuid.Nil for
+// anonymous requests. It is meant for public routes whose output depends on
+// who is looking.
+func (cfg *ApiConfig) viewerID(req *http.Request) uuid.UUID {
+	if _, _, err := sessionToken(req, accessTokenCookie); err != nil {
+		return uuid.Nil
+	}
+	user, err := cfg.authenticateWithScope(req, auth.ScopeChirpsRead)
+	if err != nil {
+		return uuid.Nil
+	}
+	return user.ID
+}
+
+// RequireRole only lets requests through when the access token carries a role
+// of at least the required rank. The role stored for the user is checked too,
+// so demotions apply before the token expires.
+func (cfg *ApiConfig) RequireRole(role string, next http.HandlerFunc) http.HandlerFunc {
+	return cfg.requireRole(role, false, next)
+}
+
+// RequireRoleOver is RequireRole for routes that act on the {userID} user.
+// It also refuses callers whose role is not above that user's, so
+// moderators cannot act on admins, nor on each other.
+func (cfg *ApiConfig) RequireRoleOver(role string, next http.HandlerFunc) http.HandlerFunc {
+	return cfg.requireRole(role, true, next)
+}
+
+func (cfg *ApiConfig) requireRole(role string, overTarget bool, next http.HandlerFunc) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		token, _, err := sessionToken(req, accessTokenCookie)
+		if err != nil {
+			respondWithAuthError(res, err)
+			return
+		}
+		claims, err := cfg.Keys.ParseJWT(token)
+		if err != nil {
+			http.Error(res, ErrorUnauthorized, http.StatusUnauthorized)
+			return
+		}
+		if !auth.HasRole(claims.Role, role) {
+			http.Error(res, ErrorForbidden, http.StatusForbidden)
+			return
+		}
+		user, err := cfg.authenticate(req)
+		if err != nil {
+			respondWithAuthError(res, err)
+			return
+		}
+		if !auth.HasRole(user.Role, role) {
+			http.Error(res, ErrorForbidden, http.StatusForbidden)
+			return
+		}
+		recorder := &statusRecorder{ResponseWriter: res, status: http.StatusOK}
+		if overTarget && !cfg.outranksTarget(req, user) {
+			http.Error(recorder, ErrorForbidden, http.StatusForbidden)
+		} else {
+			next.ServeHTTP(recorder, req)
+		}
+		metadata := map[string]any{
+			"method": req.Method,
+			"route":  req.Pattern,
+			"status": recorder.status,
+		}
+		cfg.recordAudit(req, auditAdminAction, user.ID, adminTargetID(req), metadata)
+	}
+}
+
+// outranksTarget reports whether actor's role is above that of the {userID}
+// user. A target that cannot be found is left to the handler to report.
+func (cfg *ApiConfig) outranksTarget(req *http.Request, actor database.User) bool {
+	targetID, err := uuid.Parse(req.PathValue("userID"))
+	if err != nil {
+		return true
+	}
+	target, err := cfg.DBQueries.GetUserByID(req.Context(), targetID)
+	if err != nil {
+		return true
+	}
+	return auth.Outranks(actor.Role, target.Role)
+}
+
+// adminTargetID returns the user or chirp an admin route acts on, if any.
+func adminTargetID(req *http.Request) uuid.UUID {
+	for _, name := range []string{"userID", "chirpID"} {
+		if id, err := uuid.Parse(req.PathValue(name)); err == nil {
+			return id
+		}
+	}
+	return uuid.Nil
+}
+
+func respondWithAuthError(res http.ResponseWriter, err error) {
+	if errors.Is(err, errUserSuspended) {
+		http.Error(res, ErrorAccountSuspended, http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, errPasswordResetRequired) {
+		http.Error(res, ErrorPasswordResetRequired, http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, errInsufficientScope) {
+		http.Error(res, ErrorInsufficientScope, http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, errInvalidCSRFToken) {
+		http.Error(res, ErrorInvalidCSRFToken, http.StatusForbidden)
+		return
+	}
+	http.Error(res, ErrorUnauthorized, http.StatusUnauthorized)
+}
+
+func respondWithJSON(res http.ResponseWriter, status int, payload any) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+		return
+	}
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(status)
+	res.Write(data)
+}
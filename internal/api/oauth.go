@@ -0,0 +1,671 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/charlesaraya/chirpy/internal/auth"
+	"github.com/charlesaraya/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	OAuthCodeDuration        time.Duration = 5 * time.Minute
+	OAuthAccessTokenDuration time.Duration = time.Hour
+	// oauthConsentPage shows the user which client is asking for what, and
+	// lets them approve or deny it.
+	oauthConsentPage      string = "/app/authorize.html"
+	maxOAuthClientNameLen int    = 100
+)
+
+// Error codes from RFC 6749, sections 4.1.2.1 and 5.2.
+const (
+	oauthInvalidRequest       string = "invalid_request"
+	oauthInvalidClient        string = "invalid_client"
+	oauthInvalidGrant         string = "invalid_grant"
+	oauthInvalidScope         string = "invalid_scope"
+	oauthAccessDenied         string = "access_denied"
+	oauthUnsupportedGrantType string = "unsupported_grant_type"
+	oauthUnsupportedResponse  string = "unsupported_response_type"
+	oauthServerError          string = "server_error"
+	oauthUnavailable          string = "temporarily_unavailable"
+)
+
+const (
+	ErrorInvalidOAuthClient  string = "Unknown client or redirect URI"
+	ErrorInvalidClientName   string = "Client name is required"
+	ErrorInvalidRedirectURIs string = "Redirect URIs must be https, or http on localhost, without a fragment"
+)
+
+type oauthErrorPayload struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+type oauthClientPayload struct {
+	ClientID     string   `json:"client_id"`
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirect_uris"`
+	Public       bool     `json:"public"`
+	CreatedAt    string   `json:"created_at"`
+	// ClientSecret is only returned when a confidential client is created.
+	ClientSecret string `json:"client_secret,omitempty"`
+}
+
+func newOAuthClientPayload(client database.OauthClient) oauthClientPayload {
+	return oauthClientPayload{
+		ClientID:     client.ID.String(),
+		Name:         client.Name,
+		RedirectURIs: client.RedirectUris,
+		Public:       !client.SecretHash.Valid,
+		CreatedAt:    client.CreatedAt.Format(TimeFormat),
+	}
+}
+
+type oauthAppPayload struct {
+	ClientID  string   `json:"client_id"`
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	GrantedAt string   `json:"granted_at"`
+	UpdatedAt string   `json:"updated_at"`
+}
+
+type oauthConsentPayload struct {
+	ClientID       string   `json:"client_id"`
+	ClientName     string   `json:"client_name"`
+	Scopes         []string `json:"scopes"`
+	AlreadyGranted bool     `json:"already_granted"`
+}
+
+type oauthTokenPayload struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	Scope        string `json:"scope,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+type oauthIntrospectionPayload struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	JTI       string `json:"jti,omitempty"`
+}
+
+// authorizationRequest is a validated request for an authorization code.
+type authorizationRequest struct {
+	Client        database.OauthClient
+	RedirectURI   string
+	Scopes        []string
+	State         string
+	CodeChallenge string
+}
+
+// authorizationError is a rejected authorization request. Until the client
+// and redirect URI check out, errors are shown to the user rather than sent
+// to the redirect URI, which could belong to anyone.
+type authorizationError struct {
+	Code        string
+	Description string
+	RedirectTo  string
+}
+
+// validRedirectURI accepts absolute https URIs, and http ones on the
+// loopback interface for apps running on the user's machine.
+func validRedirectURI(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || !u.IsAbs() || u.Host == "" || u.Fragment != "" {
+		return false
+	}
+	switch u.Scheme {
+	case "https":
+		return true
+	case "http":
+		host := u.Hostname()
+		return host == "localhost" || host == "127.0.0.1" || host == "::1"
+	}
+	return false
+}
+
+// redirectWith appends params to a registered redirect URI.
+func redirectWith(redirectURI string, params map[string]string) string {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		return redirectURI
+	}
+	query := u.Query()
+	for name, value := range params {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// parseAuthorizationRequest validates the query of an authorization request.
+// Only the S256 PKCE method is supported, and it is required of every client.
+func (cfg *ApiConfig) parseAuthorizationRequest(ctx context.Context, query url.Values) (authorizationRequest, *authorizationError) {
+	clientID, err := uuid.Parse(query.Get("client_id"))
+	if err != nil {
+		return authorizationRequest{}, &authorizationError{Code: oauthInvalidClient, Description: ErrorInvalidOAuthClient}
+	}
+	client, err := cfg.DBQueries.GetOAuthClient(ctx, clientID)
+	if err != nil {
+		return authorizationRequest{}, &authorizationError{Code: oauthInvalidClient, Description: ErrorInvalidOAuthClient}
+	}
+	redirectURI := query.Get("redirect_uri")
+	if !slices.Contains(client.RedirectUris, redirectURI) {
+		return authorizationRequest{}, &authorizationError{Code: oauthInvalidClient, Description: ErrorInvalidOAuthClient}
+	}
+	request := authorizationRequest{
+		Client:        client,
+		RedirectURI:   redirectURI,
+		State:         query.Get("state"),
+		CodeChallenge: query.Get("code_challenge"),
+	}
+	fail := func(code, description string) (authorizationRequest, *authorizationError) {
+		redirectTo := redirectWith(redirectURI, map[string]string{
+			"error":             code,
+			"error_description": description,
+			"state":             request.State,
+		})
+		return authorizationRequest{}, &authorizationError{Code: code, Description: description, RedirectTo: redirectTo}
+	}
+	if query.Get("response_type") != "code" {
+		return fail(oauthUnsupportedResponse, "response_type must be code")
+	}
+	if request.CodeChallenge == "" || query.Get("code_challenge_method") != auth.PKCEMethodS256 {
+		return fail(oauthInvalidRequest, "a code_challenge using the S256 method is required")
+	}
+	scopes, ok := auth.ParseScope(query.Get("scope"))
+	if !ok {
+		return fail(oauthInvalidScope, "scope is missing or unknown")
+	}
+	request.Scopes = scopes
+	return request, nil
+}
+
+func respondWithAuthorizationError(res http.ResponseWriter, authErr *authorizationError) {
+	respondWithJSON(res, http.StatusBadRequest, struct {
+		oauthErrorPayload
+		RedirectTo string `json:"redirect_to,omitempty"`
+	}{
+		oauthErrorPayload: oauthErrorPayload{Error: authErr.Code, Description: authErr.Description},
+		RedirectTo:        authErr.RedirectTo,
+	})
+}
+
+func respondWithOAuthError(res http.ResponseWriter, status int, code, description string) {
+	res.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(res, status, oauthErrorPayload{Error: code, Description: description})
+}
+
+// authenticateOAuthClient identifies the client calling the token,
+// introspection or revocation endpoint, by HTTP Basic auth or by
+// client_id and client_secret form fields. Public clients only send their ID.
+func (cfg *ApiConfig) authenticateOAuthClient(req *http.Request) (database.OauthClient, bool) {
+	rawID, secret, ok := req.BasicAuth()
+	if !ok {
+		rawID, secret = req.PostFormValue("client_id"), req.PostFormValue("client_secret")
+	}
+	clientID, err := uuid.Parse(rawID)
+	if err != nil {
+		return database.OauthClient{}, false
+	}
+	client, err := cfg.DBQueries.GetOAuthClient(req.Context(), clientID)
+	if err != nil {
+		return database.OauthClient{}, false
+	}
+	if !client.SecretHash.Valid {
+		return client, secret == ""
+	}
+	match := subtle.ConstantTimeCompare([]byte(auth.HashToken(secret)), []byte(client.SecretHash.String)) == 1
+	return client, match
+}
+
+// clientStillAuthorized reports whether the user still grants the client of
+// an OAuth access token access. Revoking an app, or deleting the client,
+// rejects every token issued to it before then.
+func (cfg *ApiConfig) clientStillAuthorized(ctx context.Context, userID uuid.UUID, claims *auth.Claims) bool {
+	clientID, err := uuid.Parse(claims.ClientID)
+	if err != nil {
+		return false
+	}
+	grant, err := cfg.DBQueries.GetOAuthGrant(ctx, database.GetOAuthGrantParams{UserID: userID, ClientID: clientID})
+	if err != nil {
+		return false
+	}
+	return !claims.IssuedBefore(grant.CreatedAt)
+}
+
+// revokeOAuthGrant removes a user's grant to a client, which also rejects
+// every access token the client holds for them.
+func (cfg *ApiConfig) revokeOAuthGrant(ctx context.Context, userID, clientID uuid.UUID) (int64, error) {
+	return cfg.DBQueries.DeleteOAuthGrant(ctx, database.DeleteOAuthGrantParams{UserID: userID, ClientID: clientID})
+}
+
+// CreateOAuthClientHandler registers a third-party app owned by the caller.
+// Confidential clients get a secret, shown only in this response; public
+// clients, such as mobile and single-page apps, rely on PKCE alone.
+func CreateOAuthClientHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		type reqPayload struct {
+			Name         string   `json:"name"`
+			RedirectURIs []string `json:"redirect_uris"`
+			Public       bool     `json:"public"`
+		}
+		user, err := apiCfg.authenticate(req)
+		if err != nil {
+			respondWithAuthError(res, err)
+			return
+		}
+		params := reqPayload{}
+		if err := json.NewDecoder(req.Body).Decode(&params); err != nil {
+			http.Error(res, ErrorSomethingWentWrong, http.StatusBadRequest)
+			return
+		}
+		params.Name = strings.TrimSpace(params.Name)
+		if params.Name == "" || len(params.Name) > maxOAuthClientNameLen {
+			http.Error(res, ErrorInvalidClientName, http.StatusBadRequest)
+			return
+		}
+		if len(params.RedirectURIs) == 0 {
+			http.Error(res, ErrorInvalidRedirectURIs, http.StatusBadRequest)
+			return
+		}
+		for _, redirectURI := range params.RedirectURIs {
+			if !validRedirectURI(redirectURI) {
+				http.Error(res, ErrorInvalidRedirectURIs, http.StatusBadRequest)
+				return
+			}
+		}
+		clientParams := database.CreateOAuthClientParams{
+			OwnerID:      user.ID,
+			Name:         params.Name,
+			RedirectUris: params.RedirectURIs,
+		}
+		secret := ""
+		if !params.Public {
+			if secret, err = auth.MakeRefreshToken(); err != nil {
+				http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+				return
+			}
+			clientParams.SecretHash = sql.NullString{String: auth.HashToken(secret), Valid: true}
+		}
+		client, err := apiCfg.DBQueries.CreateOAuthClient(req.Context(), clientParams)
+		if err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		apiCfg.recordAudit(req, auditOAuthClientCreated, user.ID, user.ID, map[string]any{"client_id": client.ID})
+		payload := newOAuthClientPayload(client)
+		payload.ClientSecret = secret
+		respondWithJSON(res, http.StatusCreated, payload)
+	}
+}
+
+// GetOAuthClientsHandler lists the apps the caller has registered.
+func GetOAuthClientsHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		user, err := apiCfg.authenticate(req)
+		if err != nil {
+			respondWithAuthError(res, err)
+			return
+		}
+		clients, err := apiCfg.DBQueries.GetOAuthClientsByOwner(req.Context(), user.ID)
+		if err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		payload := make([]oauthClientPayload, len(clients))
+		for i, client := range clients {
+			payload[i] = newOAuthClientPayload(client)
+		}
+		respondWithJSON(res, http.StatusOK, payload)
+	}
+}
+
+// DeleteOAuthClientHandler removes one of the caller's apps, along with
+// every grant users gave it.
+func DeleteOAuthClientHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		user, err := apiCfg.authenticate(req)
+		if err != nil {
+			respondWithAuthError(res, err)
+			return
+		}
+		clientID, err := uuid.Parse(req.PathValue("clientID"))
+		if err != nil {
+			http.Error(res, ErrorNotFound, http.StatusNotFound)
+			return
+		}
+		params := database.DeleteOAuthClientParams{
+			ID:      clientID,
+			OwnerID: user.ID,
+		}
+		deleted, err := apiCfg.DBQueries.DeleteOAuthClient(req.Context(), params)
+		if err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		if deleted == 0 {
+			http.Error(res, ErrorNotFound, http.StatusNotFound)
+			return
+		}
+		apiCfg.recordAudit(req, auditOAuthClientDeleted, user.ID, user.ID, map[string]any{"client_id": clientID})
+		res.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// AuthorizeHandler starts the authorization code flow. A valid request is
+// sent on to the consent page; one naming an unknown client or redirect URI
+// is refused outright.
+func AuthorizeHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		_, authErr := apiCfg.parseAuthorizationRequest(req.Context(), req.URL.Query())
+		if authErr != nil && authErr.RedirectTo == "" {
+			http.Error(res, ErrorInvalidOAuthClient, http.StatusBadRequest)
+			return
+		}
+		if authErr != nil {
+			http.Redirect(res, req, authErr.RedirectTo, http.StatusFound)
+			return
+		}
+		http.Redirect(res, req, oauthConsentPage+"?"+req.URL.RawQuery, http.StatusFound)
+	}
+}
+
+// GetConsentHandler describes an authorization request to the signed-in
+// user, so the consent page can ask them about it.
+func GetConsentHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		user, err := apiCfg.authenticate(req)
+		if err != nil {
+			respondWithAuthError(res, err)
+			return
+		}
+		request, authErr := apiCfg.parseAuthorizationRequest(req.Context(), req.URL.Query())
+		if authErr != nil {
+			respondWithAuthorizationError(res, authErr)
+			return
+		}
+		payload := oauthConsentPayload{
+			ClientID:   request.Client.ID.String(),
+			ClientName: request.Client.Name,
+			Scopes:     request.Scopes,
+		}
+		grant, err := apiCfg.DBQueries.GetOAuthGrant(req.Context(), database.GetOAuthGrantParams{UserID: user.ID, ClientID: request.Client.ID})
+		if err == nil {
+			payload.AlreadyGranted = !slices.ContainsFunc(request.Scopes, func(scope string) bool {
+				return !auth.HasScope(grant.Scopes, scope)
+			})
+		}
+		respondWithJSON(res, http.StatusOK, payload)
+	}
+}
+
+// ConsentHandler records the signed-in user's answer to an authorization
+// request and returns where to send them next: back to the client with an
+// authorization code, or with an access_denied error.
+func ConsentHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		type reqPayload struct {
+			Approve bool `json:"approve"`
+		}
+		type resPayload struct {
+			RedirectTo string `json:"redirect_to"`
+		}
+		user, err := apiCfg.authenticate(req)
+		if err != nil {
+			respondWithAuthError(res, err)
+			return
+		}
+		params := reqPayload{}
+		if err := json.NewDecoder(req.Body).Decode(&params); err != nil {
+			http.Error(res, ErrorSomethingWentWrong, http.StatusBadRequest)
+			return
+		}
+		request, authErr := apiCfg.parseAuthorizationRequest(req.Context(), req.URL.Query())
+		if authErr != nil {
+			respondWithAuthorizationError(res, authErr)
+			return
+		}
+		if !params.Approve {
+			respondWithJSON(res, http.StatusOK, resPayload{RedirectTo: redirectWith(request.RedirectURI, map[string]string{
+				"error": oauthAccessDenied,
+				"state": request.State,
+			})})
+			return
+		}
+		// Scopes granted earlier are kept, so approving a narrower request
+		// does not take anything away from the app.
+		scopes := slices.Clone(request.Scopes)
+		grantParams := database.GetOAuthGrantParams{UserID: user.ID, ClientID: request.Client.ID}
+		if grant, err := apiCfg.DBQueries.GetOAuthGrant(req.Context(), grantParams); err == nil {
+			for _, scope := range grant.Scopes {
+				if !slices.Contains(scopes, scope) {
+					scopes = append(scopes, scope)
+				}
+			}
+		}
+		upsertParams := database.UpsertOAuthGrantParams{
+			UserID:   user.ID,
+			ClientID: request.Client.ID,
+			Scopes:   scopes,
+		}
+		if _, err := apiCfg.DBQueries.UpsertOAuthGrant(req.Context(), upsertParams); err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		code, err := auth.MakeRefreshToken()
+		if err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		codeParams := database.CreateOAuthAuthorizationCodeParams{
+			CodeHash:      auth.HashToken(code),
+			ClientID:      request.Client.ID,
+			UserID:        user.ID,
+			RedirectUri:   request.RedirectURI,
+			Scopes:        request.Scopes,
+			CodeChallenge: request.CodeChallenge,
+			ExpiresAt:     time.Now().Add(OAuthCodeDuration),
+		}
+		if err := apiCfg.DBQueries.CreateOAuthAuthorizationCode(req.Context(), codeParams); err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		apiCfg.recordAudit(req, auditOAuthAuthorized, user.ID, user.ID, map[string]any{"client_id": request.Client.ID, "scopes": request.Scopes})
+		respondWithJSON(res, http.StatusOK, resPayload{RedirectTo: redirectWith(request.RedirectURI, map[string]string{
+			"code":  code,
+			"state": request.State,
+		})})
+	}
+}
+
+// OAuthTokenHandler trades an authorization code, with the PKCE verifier it
+// was requested with, for an access token scoped to what the user approved.
+// A code presented twice is treated as stolen and revokes the user's grant
+// to the client. Devices polling with a device code are handed to
+// exchangeDeviceCode.
+func OAuthTokenHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		if req.PostFormValue("grant_type") == deviceCodeGrantType {
+			exchangeDeviceCode(res, req, apiCfg)
+			return
+		}
+		client, ok := apiCfg.authenticateOAuthClient(req)
+		if !ok {
+			respondWithOAuthError(res, http.StatusUnauthorized, oauthInvalidClient, "client authentication failed")
+			return
+		}
+		if req.PostFormValue("grant_type") != "authorization_code" {
+			respondWithOAuthError(res, http.StatusBadRequest, oauthUnsupportedGrantType, "grant_type must be authorization_code")
+			return
+		}
+		code, err := apiCfg.DBQueries.GetOAuthAuthorizationCode(req.Context(), auth.HashToken(req.PostFormValue("code")))
+		if err != nil || code.ClientID != client.ID || code.RedirectUri != req.PostFormValue("redirect_uri") {
+			respondWithOAuthError(res, http.StatusBadRequest, oauthInvalidGrant, "unknown code or redirect_uri")
+			return
+		}
+		if !auth.VerifyPKCE(req.PostFormValue("code_verifier"), code.CodeChallenge) {
+			respondWithOAuthError(res, http.StatusBadRequest, oauthInvalidGrant, "code_verifier does not match")
+			return
+		}
+		used, err := apiCfg.DBQueries.UseOAuthAuthorizationCode(req.Context(), code.ID)
+		if err != nil {
+			respondWithOAuthError(res, http.StatusInternalServerError, oauthServerError, "")
+			return
+		}
+		if used == 0 {
+			if code.UsedAt.Valid {
+				if _, err := apiCfg.revokeOAuthGrant(req.Context(), code.UserID, client.ID); err != nil {
+					respondWithOAuthError(res, http.StatusInternalServerError, oauthServerError, "")
+					return
+				}
+				apiCfg.recordAudit(req, auditOAuthCodeReused, uuid.Nil, code.UserID, map[string]any{"client_id": client.ID})
+			}
+			respondWithOAuthError(res, http.StatusBadRequest, oauthInvalidGrant, "code is expired or already used")
+			return
+		}
+		user, err := apiCfg.DBQueries.GetUserByID(req.Context(), code.UserID)
+		if err != nil || user.SuspendedAt.Valid {
+			respondWithOAuthError(res, http.StatusBadRequest, oauthInvalidGrant, "the user cannot be signed in")
+			return
+		}
+		accessToken, err := apiCfg.Keys.MakeClientJWT(user.ID, client.ID.String(), code.Scopes, OAuthAccessTokenDuration)
+		if err != nil {
+			respondWithOAuthError(res, http.StatusInternalServerError, oauthServerError, "")
+			return
+		}
+		apiCfg.recordAudit(req, auditLoginSucceeded, user.ID, user.ID, map[string]any{"method": loginMethodOAuth, "client_id": client.ID})
+		res.Header().Set("Cache-Control", "no-store")
+		respondWithJSON(res, http.StatusOK, oauthTokenPayload{
+			AccessToken: accessToken,
+			TokenType:   "Bearer",
+			ExpiresIn:   int(OAuthAccessTokenDuration.Seconds()),
+			Scope:       auth.FormatScope(code.Scopes),
+		})
+	}
+}
+
+// IntrospectHandler tells a confidential client whether one of its access
+// tokens is still active, as described in RFC 7662. Tokens issued to other
+// clients are reported as inactive.
+func IntrospectHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		client, ok := apiCfg.authenticateOAuthClient(req)
+		if !ok || !client.SecretHash.Valid {
+			respondWithOAuthError(res, http.StatusUnauthorized, oauthInvalidClient, "client authentication failed")
+			return
+		}
+		res.Header().Set("Cache-Control", "no-store")
+		user, claims, err := apiCfg.verifyJWT(req.Context(), req.PostFormValue("token"))
+		if err != nil || claims.ClientID != client.ID.String() || user.SuspendedAt.Valid {
+			respondWithJSON(res, http.StatusOK, oauthIntrospectionPayload{Active: false})
+			return
+		}
+		respondWithJSON(res, http.StatusOK, oauthIntrospectionPayload{
+			Active:    true,
+			Scope:     claims.Scope,
+			ClientID:  claims.ClientID,
+			Subject:   claims.Subject,
+			TokenType: "Bearer",
+			ExpiresAt: claims.ExpiresAt.Unix(),
+			IssuedAt:  claims.IssuedAt.Unix(),
+			JTI:       claims.ID,
+		})
+	}
+}
+
+// OAuthRevokeHandler lets a client give up one of its access tokens, as
+// described in RFC 7009. Unknown tokens and tokens of other clients are
+// ignored, so the answer is always the same.
+func OAuthRevokeHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		client, ok := apiCfg.authenticateOAuthClient(req)
+		if !ok {
+			respondWithOAuthError(res, http.StatusUnauthorized, oauthInvalidClient, "client authentication failed")
+			return
+		}
+		claims, err := apiCfg.Keys.ParseJWT(req.PostFormValue("token"))
+		if err == nil && claims.ClientID == client.ID.String() {
+			userID, err := uuid.Parse(claims.Subject)
+			if err == nil {
+				if err := apiCfg.revokeAccessToken(req.Context(), userID, claims); err != nil {
+					respondWithOAuthError(res, http.StatusServiceUnavailable, oauthUnavailable, "")
+					return
+				}
+				apiCfg.recordAudit(req, auditTokenRevoked, userID, userID, map[string]any{"client_id": client.ID})
+			}
+		}
+		res.WriteHeader(http.StatusOK)
+	}
+}
+
+// GetAppsHandler lists the third-party apps the caller has authorized.
+func GetAppsHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		user, err := apiCfg.authenticate(req)
+		if err != nil {
+			respondWithAuthError(res, err)
+			return
+		}
+		grants, err := apiCfg.DBQueries.GetUserOAuthGrants(req.Context(), user.ID)
+		if err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		payload := make([]oauthAppPayload, len(grants))
+		for i, grant := range grants {
+			payload[i] = oauthAppPayload{
+				ClientID:  grant.ClientID.String(),
+				Name:      grant.Name,
+				Scopes:    grant.Scopes,
+				GrantedAt: grant.CreatedAt.Format(TimeFormat),
+				UpdatedAt: grant.UpdatedAt.Format(TimeFormat),
+			}
+		}
+		respondWithJSON(res, http.StatusOK, payload)
+	}
+}
+
+// RevokeAppHandler withdraws the caller's authorization of an app. Its
+// access tokens stop working straight away.
+func RevokeAppHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		user, err := apiCfg.authenticate(req)
+		if err != nil {
+			respondWithAuthError(res, err)
+			return
+		}
+		clientID, err := uuid.Parse(req.PathValue("clientID"))
+		if err != nil {
+			http.Error(res, ErrorNotFound, http.StatusNotFound)
+			return
+		}
+		revoked, err := apiCfg.revokeOAuthGrant(req.Context(), user.ID, clientID)
+		if err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		if revoked == 0 {
+			http.Error(res, ErrorNotFound, http.StatusNotFound)
+			return
+		}
+		apiCfg.recordAudit(req, auditOAuthRevoked, user.ID, user.ID, map[string]any{"client_id": clientID})
+		res.WriteHeader(http.StatusNoContent)
+	}
+}
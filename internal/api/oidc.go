@@ -0,0 +1,271 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/charlesaraya/chirpy/internal/auth"
+	"github.com/charlesaraya/chirpy/internal/database"
+	"github.com/charlesaraya/chirpy/internal/oidc"
+	"github.com/google/uuid"
+)
+
+const (
+	OIDCLoginDuration time.Duration = 10 * time.Minute
+	// oidcStateCookie ties the provider's redirect back to the browser that
+	// started the login, so a login started by someone else cannot be
+	// completed in the victim's browser.
+	oidcStateCookie string = "chirpy_oidc_state"
+	oidcCookiePath  string = "/api/login/oidc/"
+)
+
+const (
+	ErrorInvalidOIDCState     string = "Invalid or expired login attempt"
+	ErrorOIDCLoginFailed      string = "Could not sign in with the identity provider"
+	ErrorOIDCEmailNotVerified string = "The identity provider has not verified this email address"
+	ErrorOIDCAccountConflict  string = "An account with this email already exists; log in and verify its email first"
+)
+
+var (
+	// errOIDCAccountConflict is returned when the identity's email belongs to
+	// a Chirpy account that has not proven it owns the address either.
+	errOIDCAccountConflict  = errors.New("oidc: unverified account with the same email")
+	errOIDCEmailNotVerified = errors.New("oidc: email not verified by the provider")
+)
+
+func (cfg *ApiConfig) oidcRedirectURI(provider string) string {
+	return cfg.BaseURL + oidcCookiePath + provider + "/callback"
+}
+
+// findOIDCUser returns the user an external identity belongs to. Unknown
+// identities are linked to the account with the same email when both sides
+// have verified it, and otherwise get a new account. Linking on an
+// unverified address would let whoever registered it first take over the
+// other side's account.
+func (cfg *ApiConfig) findOIDCUser(req *http.Request, provider string, claims *oidc.Claims) (database.User, error) {
+	ctx := req.Context()
+	identity, err := cfg.DBQueries.GetUserIdentity(ctx, database.GetUserIdentityParams{
+		Provider: provider,
+		Subject:  claims.Subject,
+	})
+	if err == nil {
+		return cfg.DBQueries.GetUserByID(ctx, identity.UserID)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return database.User{}, err
+	}
+	if !claims.EmailVerified || !validEmail(claims.Email) {
+		return database.User{}, errOIDCEmailNotVerified
+	}
+	user, err := cfg.DBQueries.GetUser(ctx, claims.Email)
+	created := false
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		if user, err = cfg.createOIDCUser(ctx, claims.Email); err != nil {
+			return database.User{}, err
+		}
+		created = true
+	case err != nil:
+		return database.User{}, err
+	case !user.EmailVerifiedAt.Valid:
+		return database.User{}, errOIDCAccountConflict
+	}
+	_, err = cfg.DBQueries.CreateUserIdentity(ctx, database.CreateUserIdentityParams{
+		Provider: provider,
+		Subject:  claims.Subject,
+		UserID:   user.ID,
+		Email:    claims.Email,
+	})
+	if isUniqueViolation(err) {
+		return database.User{}, errOIDCAccountConflict
+	}
+	if err != nil {
+		return database.User{}, err
+	}
+	cfg.recordAudit(req, auditIdentityLinked, user.ID, user.ID, map[string]any{
+		"provider":     provider,
+		"subject":      claims.Subject,
+		"user_created": created,
+	})
+	return user, nil
+}
+
+// createOIDCUser signs up a user whose email the identity provider has
+// verified. Their password is random, so until they set one with a password
+// reset they can only sign in through the provider. There is no invite code
+// to give, so this is only possible when anyone may sign up or ask to.
+func (cfg *ApiConfig) createOIDCUser(ctx context.Context, email string) (database.User, error) {
+	switch cfg.Registration.Mode {
+	case RegistrationClosed:
+		return database.User{}, errRegistrationClosed
+	case RegistrationInviteOnly:
+		return database.User{}, errInviteRequired
+	}
+	password, err := auth.MakeRefreshToken()
+	if err != nil {
+		return database.User{}, err
+	}
+	hashedPassword, err := cfg.PasswordHasher.Hash(password)
+	if err != nil {
+		return database.User{}, err
+	}
+	user, err := cfg.DBQueries.CreateUser(ctx, database.CreateUserParams{
+		Email:           email,
+		HashedPassword:  hashedPassword,
+		PendingApproval: cfg.Registration.Mode == RegistrationApproval,
+	})
+	if isUniqueViolation(err) {
+		return database.User{}, errOIDCAccountConflict
+	}
+	if err != nil {
+		return database.User{}, err
+	}
+	return cfg.DBQueries.MarkEmailVerified(ctx, database.MarkEmailVerifiedParams{
+		ID:    user.ID,
+		Email: user.Email,
+	})
+}
+
+// OIDCLoginHandler starts signing in with an identity provider. It stores
+// the state, nonce and PKCE verifier for the callback and sends the browser
+// to the provider.
+func OIDCLoginHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		name := req.PathValue("provider")
+		provider, ok := apiCfg.OIDCProviders[name]
+		if !ok {
+			http.Error(res, ErrorNotFound, http.StatusNotFound)
+			return
+		}
+		if err := apiCfg.DBQueries.DeleteExpiredOIDCLoginStates(req.Context()); err != nil {
+			log.Printf("deleting expired oidc login states: %v", err)
+		}
+		// Refresh tokens are 64 hex characters, which also makes a valid
+		// PKCE verifier.
+		var values [3]string
+		for i := range values {
+			value, err := auth.MakeRefreshToken()
+			if err != nil {
+				http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+				return
+			}
+			values[i] = value
+		}
+		state, nonce, verifier := values[0], values[1], values[2]
+		authURL, err := provider.AuthCodeURL(req.Context(), apiCfg.oidcRedirectURI(name), state, nonce, auth.PKCEChallenge(verifier))
+		if err != nil {
+			log.Printf("oidc %s: %v", name, err)
+			http.Error(res, ErrorOIDCLoginFailed, http.StatusBadGateway)
+			return
+		}
+		params := database.CreateOIDCLoginStateParams{
+			StateHash:    auth.HashToken(state),
+			Provider:     name,
+			Nonce:        nonce,
+			CodeVerifier: verifier,
+			ExpiresAt:    time.Now().Add(OIDCLoginDuration),
+		}
+		if err := apiCfg.DBQueries.CreateOIDCLoginState(req.Context(), params); err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		http.SetCookie(res, &http.Cookie{
+			Name:     oidcStateCookie,
+			Value:    state,
+			Path:     oidcCookiePath,
+			MaxAge:   int(OIDCLoginDuration.Seconds()),
+			HttpOnly: true,
+			Secure:   strings.HasPrefix(apiCfg.BaseURL, "https://"),
+			// Lax still sends the cookie on the provider's top-level
+			// redirect back to the callback.
+			SameSite: http.SameSiteLaxMode,
+		})
+		http.Redirect(res, req, authURL, http.StatusFound)
+	}
+}
+
+// OIDCCallbackHandler finishes signing in with an identity provider and
+// answers like LoginUserHandler: with tokens, or with an MFA challenge when
+// the user has two-factor authentication on.
+func OIDCCallbackHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		name := req.PathValue("provider")
+		provider, ok := apiCfg.OIDCProviders[name]
+		if !ok {
+			http.Error(res, ErrorNotFound, http.StatusNotFound)
+			return
+		}
+		http.SetCookie(res, &http.Cookie{Name: oidcStateCookie, Path: oidcCookiePath, MaxAge: -1})
+		query := req.URL.Query()
+		cookie, err := req.Cookie(oidcStateCookie)
+		if err != nil || query.Get("state") == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(query.Get("state"))) != 1 {
+			http.Error(res, ErrorInvalidOIDCState, http.StatusUnauthorized)
+			return
+		}
+		loginState, err := apiCfg.DBQueries.ConsumeOIDCLoginState(req.Context(), database.ConsumeOIDCLoginStateParams{
+			StateHash: auth.HashToken(cookie.Value),
+			Provider:  name,
+		})
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(res, ErrorInvalidOIDCState, http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		if query.Get("error") != "" || query.Get("code") == "" {
+			apiCfg.recordAudit(req, auditLoginFailed, uuid.Nil, uuid.Nil, map[string]any{"provider": name, "reason": "provider_error", "error": query.Get("error")})
+			http.Error(res, ErrorOIDCLoginFailed, http.StatusUnauthorized)
+			return
+		}
+		claims, err := provider.Exchange(req.Context(), query.Get("code"), apiCfg.oidcRedirectURI(name), loginState.CodeVerifier, loginState.Nonce)
+		if err != nil {
+			log.Printf("oidc %s: %v", name, err)
+			apiCfg.recordAudit(req, auditLoginFailed, uuid.Nil, uuid.Nil, map[string]any{"provider": name, "reason": "invalid_id_token"})
+			http.Error(res, ErrorOIDCLoginFailed, http.StatusUnauthorized)
+			return
+		}
+		user, err := apiCfg.findOIDCUser(req, name, claims)
+		switch {
+		case errors.Is(err, errOIDCAccountConflict):
+			http.Error(res, ErrorOIDCAccountConflict, http.StatusConflict)
+			return
+		case errors.Is(err, errOIDCEmailNotVerified):
+			http.Error(res, ErrorOIDCEmailNotVerified, http.StatusForbidden)
+			return
+		case errors.Is(err, errRegistrationClosed), errors.Is(err, errInviteRequired):
+			respondWithSignupError(res, err)
+			return
+		case err != nil:
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		if user.SuspendedAt.Valid {
+			apiCfg.recordAudit(req, auditLoginFailed, user.ID, user.ID, map[string]any{"reason": "suspended"})
+			http.Error(res, ErrorAccountSuspended, http.StatusForbidden)
+			return
+		}
+		if user.PendingApproval {
+			apiCfg.recordAudit(req, auditLoginFailed, user.ID, user.ID, map[string]any{"reason": "pending_approval"})
+			http.Error(res, ErrorAccountPendingApproval, http.StatusForbidden)
+			return
+		}
+		_, mfaEnabled, err := apiCfg.totpCredential(req.Context(), user.ID)
+		if err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		if mfaEnabled {
+			respondWithMFAChallenge(res, req, apiCfg, user)
+			return
+		}
+		startSession(res, req, apiCfg, user, loginMethodOIDC+":"+name)
+	}
+}
@@ -0,0 +1,232 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/charlesaraya/chirpy/internal/auth"
+	"github.com/charlesaraya/chirpy/internal/database"
+	"github.com/charlesaraya/chirpy/internal/mail"
+	"github.com/google/uuid"
+)
+
+const (
+	ErrorInvalidResetToken     string        = "Invalid or expired reset token"
+	ErrorWeakPassword          string        = "Password does not meet the requirements"
+	ErrorTooManyPasswordResets string        = "Too many password resets requested, try again later"
+	PasswordResetDuration      time.Duration = time.Hour
+)
+
+// Reset requests share the login throttle table, counted per email whether
+// or not it belongs to an account, as magic links are, and per client IP so
+// one client cannot mail many addresses.
+const (
+	passwordResetThrottle   string = "reset:"
+	passwordResetThrottleIP string = "reset_ip:"
+)
+
+func passwordResetThrottleKey(email string) string {
+	return passwordResetThrottle + strings.ToLower(strings.TrimSpace(email))
+}
+
+func passwordResetThrottleIPKey(req *http.Request) string {
+	return passwordResetThrottleIP + clientIP(req)
+}
+
+type passwordPolicyErrorPayload struct {
+	Error      string                   `json:"error"`
+	Violations []auth.PasswordViolation `json:"violations"`
+}
+
+// checkPassword enforces the password policy for a new password. When the
+// password is rejected it writes a 400 listing every rule that failed and
+// returns false.
+func (cfg *ApiConfig) checkPassword(res http.ResponseWriter, password, email string) bool {
+	violations := cfg.PasswordPolicy.Check(password, email)
+	if len(violations) == 0 {
+		return true
+	}
+	respondWithJSON(res, http.StatusBadRequest, passwordPolicyErrorPayload{
+		Error:      ErrorWeakPassword,
+		Violations: violations,
+	})
+	return false
+}
+
+// rehashPassword upgrades the stored hash of a password that was just
+// verified if it was made with an outdated algorithm, parameters or pepper.
+// The update only applies while the hash is unchanged, so it cannot undo a
+// concurrent password change. Failures are logged, as the login goes ahead
+// either way.
+func (cfg *ApiConfig) rehashPassword(ctx context.Context, user database.User, password string) {
+	if !cfg.PasswordHasher.NeedsRehash(user.HashedPassword) {
+		return
+	}
+	hashedPassword, err := cfg.PasswordHasher.Hash(password)
+	if err != nil {
+		log.Printf("rehashing password: %v", err)
+		return
+	}
+	params := database.RehashUserPasswordParams{
+		ID:               user.ID,
+		HashedPassword:   user.HashedPassword,
+		HashedPassword_2: hashedPassword,
+	}
+	if _, err := cfg.DBQueries.RehashUserPassword(ctx, params); err != nil {
+		log.Printf("rehashing password: %v", err)
+	}
+}
+
+// ForgotPasswordHandler emails a password reset link. It answers the same
+// way whether or not the address belongs to an account, so it cannot be used
+// to find out who has signed up: the link is made and sent after the
+// response, so known addresses take no longer to answer than unknown ones.
+// Like RequestMagicLinkHandler, the rate limit applies to unknown addresses
+// too.
+func ForgotPasswordHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		type reqPayload struct {
+			Email string `json:"email"`
+		}
+		params := reqPayload{}
+		if err := json.NewDecoder(req.Body).Decode(&params); err != nil {
+			http.Error(res, ErrorSomethingWentWrong, http.StatusBadRequest)
+			return
+		}
+		emailKey, ipKey := passwordResetThrottleKey(params.Email), passwordResetThrottleIPKey(req)
+		lockedFor, err := apiCfg.throttleLockedFor(req.Context(), emailKey, ipKey)
+		if err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		if lockedFor <= 0 {
+			lockedFor = max(
+				apiCfg.countLoginFailure(req.Context(), emailKey, apiCfg.MagicLinkLimit),
+				apiCfg.countLoginFailure(req.Context(), ipKey, apiCfg.IPLockout),
+			)
+			if lockedFor > 0 {
+				apiCfg.recordAudit(req, auditResetLinkLimited, uuid.Nil, uuid.Nil, map[string]any{"email": params.Email, "locked_for": lockedFor.String()})
+			}
+		}
+		if lockedFor > 0 {
+			respondWithTooManyRequests(res, lockedFor, ErrorTooManyPasswordResets)
+			return
+		}
+		user, err := apiCfg.DBQueries.GetUser(req.Context(), params.Email)
+		if err == nil && !user.SuspendedAt.Valid {
+			ip, userAgent := clientIP(req), req.UserAgent()
+			go func() {
+				if err := apiCfg.sendPasswordReset(context.Background(), user, ip, userAgent); err != nil {
+					log.Printf("password reset for %s: %v", user.ID, err)
+				}
+			}()
+		}
+		res.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// sendPasswordReset replaces the user's reset link with a new one and mails
+// it. Only the newest link works: earlier ones are expired in the same
+// transaction that stores it, so concurrent requests cannot leave two.
+func (cfg *ApiConfig) sendPasswordReset(ctx context.Context, user database.User, ip, userAgent string) error {
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		return err
+	}
+	err = cfg.inTx(ctx, func(queries *database.Queries) error {
+		if err := queries.ExpireUserPasswordResetTokens(ctx, user.ID); err != nil {
+			return fmt.Errorf("expiring earlier links: %w", err)
+		}
+		tokenParams := database.CreatePasswordResetTokenParams{
+			UserID:    user.ID,
+			TokenHash: auth.HashToken(token),
+			ExpiresAt: time.Now().Add(PasswordResetDuration),
+		}
+		if err := queries.CreatePasswordResetToken(ctx, tokenParams); err != nil {
+			return fmt.Errorf("creating link: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	link := cfg.BaseURL + "/app/reset-password.html?token=" + url.QueryEscape(token)
+	cfg.sendMail(mail.Message{
+		To:      user.Email,
+		Subject: "Reset your Chirpy password",
+		Body: fmt.Sprintf("Someone asked to reset the password for your Chirpy account.\n\n"+
+			"To choose a new password, open this link within the next hour:\n\n%s\n\n"+
+			"If this wasn't you, you can ignore this email.", link),
+	})
+	cfg.writeAuditEvent(ctx, auditPasswordResetAsked, uuid.Nil, user.ID, ip, userAgent, nil)
+	return nil
+}
+
+// ResetPasswordHandler sets a new password using a token from
+// ForgotPasswordHandler. Tokens work once, and a successful reset signs the
+// user out everywhere.
+func ResetPasswordHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		type reqPayload struct {
+			Token    string `json:"token"`
+			Password string `json:"password"`
+		}
+		params := reqPayload{}
+		if err := json.NewDecoder(req.Body).Decode(&params); err != nil {
+			http.Error(res, ErrorSomethingWentWrong, http.StatusBadRequest)
+			return
+		}
+		resetToken, err := apiCfg.DBQueries.GetPasswordResetToken(req.Context(), auth.HashToken(params.Token))
+		if err != nil {
+			http.Error(res, ErrorInvalidResetToken, http.StatusBadRequest)
+			return
+		}
+		user, err := apiCfg.DBQueries.GetUserByID(req.Context(), resetToken.UserID)
+		if err != nil {
+			http.Error(res, ErrorInvalidResetToken, http.StatusBadRequest)
+			return
+		}
+		if user.SuspendedAt.Valid {
+			http.Error(res, ErrorAccountSuspended, http.StatusForbidden)
+			return
+		}
+		// Checked before the token is used up, so the user can try again.
+		if !apiCfg.checkPassword(res, params.Password, user.Email) {
+			return
+		}
+		used, err := apiCfg.DBQueries.UsePasswordResetToken(req.Context(), resetToken.ID)
+		if err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		if used == 0 {
+			http.Error(res, ErrorInvalidResetToken, http.StatusBadRequest)
+			return
+		}
+		hashedPassword, err := apiCfg.PasswordHasher.Hash(params.Password)
+		if err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		passwordParams := database.SetUserPasswordParams{
+			ID:             user.ID,
+			HashedPassword: hashedPassword,
+		}
+		if err := apiCfg.DBQueries.SetUserPassword(req.Context(), passwordParams); err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		if err := apiCfg.signOutEverywhere(req.Context(), user.ID); err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		apiCfg.recordAudit(req, auditPasswordReset, user.ID, user.ID, nil)
+		res.WriteHeader(http.StatusNoContent)
+	}
+}
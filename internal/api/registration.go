@@ -0,0 +1,430 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/charlesaraya/chirpy/internal/auth"
+	"github.com/charlesaraya/chirpy/internal/database"
+	"github.com/charlesaraya/chirpy/internal/mail"
+	"github.com/google/uuid"
+)
+
+// The registration mode says who may sign up. Invite codes let someone in
+// when the mode asks for one, and skip the approval queue when it does not.
+// Any user may mint a few codes; admins may mint as many as they like.
+const (
+	RegistrationOpen       string = "open"
+	RegistrationInviteOnly string = "invite-only"
+	RegistrationApproval   string = "approval-required"
+	RegistrationClosed     string = "closed"
+)
+
+const (
+	DefaultInvitesPerUser int           = 5
+	DefaultInviteDuration time.Duration = 7 * 24 * time.Hour
+	// Codes minted by users who are not admins are bounded, so a handful
+	// of accounts cannot open the door to everyone.
+	maxUserInviteUses     int32         = 10
+	maxUserInviteDuration time.Duration = 30 * 24 * time.Hour
+)
+
+const (
+	ErrorRegistrationClosed     string = "Registration is closed"
+	ErrorInviteRequired         string = "An invite code is required to sign up"
+	ErrorInvalidInviteCode      string = "Invite code is invalid, used up or expired"
+	ErrorInvitesNotUsed         string = "Invite codes are not used for signing up"
+	ErrorInviteLimitReached     string = "You cannot create more invite codes"
+	ErrorInvalidInvite          string = "Invalid invite code uses or expiry"
+	ErrorAccountPendingApproval string = "Account is awaiting approval"
+)
+
+var (
+	errRegistrationClosed = errors.New("registration is closed")
+	errInviteRequired     = errors.New("invite code required")
+	errInvalidInviteCode  = errors.New("invalid invite code")
+)
+
+// RegistrationPolicy says who may sign up and who may invite them.
+type RegistrationPolicy struct {
+	Mode string
+	// InvitesPerUser is how many usable invite codes a user who is not an
+	// admin may have at once. Zero stops them minting any.
+	InvitesPerUser int
+}
+
+func DefaultRegistrationPolicy() RegistrationPolicy {
+	return RegistrationPolicy{
+		Mode:           RegistrationOpen,
+		InvitesPerUser: DefaultInvitesPerUser,
+	}
+}
+
+// usesInvites reports whether invite codes do anything in the policy's mode.
+func (p RegistrationPolicy) usesInvites() bool {
+	return p.Mode == RegistrationInviteOnly || p.Mode == RegistrationApproval
+}
+
+// loadRegistrationPolicy reads the registration policy from
+// REGISTRATION_MODE and INVITES_PER_USER.
+func loadRegistrationPolicy() (RegistrationPolicy, error) {
+	policy := DefaultRegistrationPolicy()
+	if mode := os.Getenv("REGISTRATION_MODE"); mode != "" {
+		switch mode {
+		case RegistrationOpen, RegistrationInviteOnly, RegistrationApproval, RegistrationClosed:
+			policy.Mode = mode
+		default:
+			return RegistrationPolicy{}, fmt.Errorf("unknown REGISTRATION_MODE %q", mode)
+		}
+	}
+	if invites := os.Getenv("INVITES_PER_USER"); invites != "" {
+		n, err := strconv.Atoi(invites)
+		if err != nil || n < 0 {
+			return RegistrationPolicy{}, fmt.Errorf("invalid INVITES_PER_USER %q", invites)
+		}
+		policy.InvitesPerUser = n
+	}
+	return policy, nil
+}
+
+// admitSignup decides whether a signup may go ahead, using up its invite
+// code if it needs one. It returns the code used, if any, and whether the
+// account has to wait for approval. A code that was used must be released
+// if the account is not created after all.
+func (cfg *ApiConfig) admitSignup(ctx context.Context, inviteCode string) (database.InviteCode, bool, error) {
+	switch cfg.Registration.Mode {
+	case RegistrationClosed:
+		return database.InviteCode{}, false, errRegistrationClosed
+	case RegistrationOpen:
+		return database.InviteCode{}, false, nil
+	}
+	if inviteCode == "" {
+		if cfg.Registration.Mode == RegistrationApproval {
+			return database.InviteCode{}, true, nil
+		}
+		return database.InviteCode{}, false, errInviteRequired
+	}
+	invite, err := cfg.DBQueries.UseInviteCode(ctx, auth.HashToken(inviteCode))
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.InviteCode{}, false, errInvalidInviteCode
+	}
+	if err != nil {
+		return database.InviteCode{}, false, err
+	}
+	return invite, false, nil
+}
+
+func respondWithSignupError(res http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errRegistrationClosed):
+		http.Error(res, ErrorRegistrationClosed, http.StatusForbidden)
+	case errors.Is(err, errInviteRequired):
+		http.Error(res, ErrorInviteRequired, http.StatusForbidden)
+	case errors.Is(err, errInvalidInviteCode):
+		http.Error(res, ErrorInvalidInviteCode, http.StatusForbidden)
+	default:
+		http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+	}
+}
+
+// GetRegistrationHandler tells sign-up forms which registration mode is in
+// force, so they can ask for an invite code or warn about approval.
+func GetRegistrationHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		type resPayload struct {
+			Mode string `json:"mode"`
+		}
+		respondWithJSON(res, http.StatusOK, resPayload{Mode: apiCfg.Registration.Mode})
+	}
+}
+
+type invitePayload struct {
+	ID        string `json:"id"`
+	CreatedBy string `json:"created_by"`
+	MaxUses   int32  `json:"max_uses"`
+	Uses      int32  `json:"uses"`
+	CreatedAt string `json:"created_at"`
+	ExpiresAt string `json:"expires_at,omitempty"`
+	// Code is only returned when the invite is created.
+	Code string `json:"code,omitempty"`
+}
+
+func newInvitePayload(invite database.InviteCode) invitePayload {
+	payload := invitePayload{
+		ID:        invite.ID.String(),
+		CreatedBy: invite.CreatedBy.String(),
+		MaxUses:   invite.MaxUses,
+		Uses:      invite.Uses,
+		CreatedAt: invite.CreatedAt.Format(TimeFormat),
+	}
+	if invite.ExpiresAt.Valid {
+		payload.ExpiresAt = invite.ExpiresAt.Time.Format(TimeFormat)
+	}
+	return payload
+}
+
+func newInvitePayloads(invites []database.InviteCode) []invitePayload {
+	payload := make([]invitePayload, len(invites))
+	for i, invite := range invites {
+		payload[i] = newInvitePayload(invite)
+	}
+	return payload
+}
+
+// CreateInviteHandler mints an invite code. Codes last a week and work once
+// unless asked otherwise. The code is only shown in this response; just its
+// hash is kept.
+func CreateInviteHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		type reqPayload struct {
+			MaxUses   int32  `json:"max_uses"`
+			ExpiresAt string `json:"expires_at"`
+		}
+		user, err := apiCfg.authenticate(req)
+		if err != nil {
+			respondWithAuthError(res, err)
+			return
+		}
+		if !apiCfg.Registration.usesInvites() {
+			http.Error(res, ErrorInvitesNotUsed, http.StatusForbidden)
+			return
+		}
+		if apiCfg.RequireVerifiedEmail && !user.EmailVerifiedAt.Valid {
+			http.Error(res, ErrorEmailNotVerified, http.StatusForbidden)
+			return
+		}
+		params := reqPayload{MaxUses: 1}
+		if err := json.NewDecoder(req.Body).Decode(&params); err != nil {
+			http.Error(res, ErrorSomethingWentWrong, http.StatusBadRequest)
+			return
+		}
+		now := time.Now()
+		expiresAt, err := parseNullTime(params.ExpiresAt)
+		if err != nil || params.MaxUses <= 0 || (expiresAt.Valid && expiresAt.Time.Before(now)) {
+			http.Error(res, ErrorInvalidInvite, http.StatusBadRequest)
+			return
+		}
+		isAdmin := auth.HasRole(user.Role, auth.RoleAdmin)
+		if !isAdmin {
+			if !expiresAt.Valid {
+				expiresAt = sql.NullTime{Time: now.Add(DefaultInviteDuration), Valid: true}
+			}
+			if params.MaxUses > maxUserInviteUses || expiresAt.Time.After(now.Add(maxUserInviteDuration)) {
+				http.Error(res, ErrorInvalidInvite, http.StatusBadRequest)
+				return
+			}
+			active, err := apiCfg.DBQueries.CountActiveUserInviteCodes(req.Context(), user.ID)
+			if err != nil {
+				http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+				return
+			}
+			if active >= int64(apiCfg.Registration.InvitesPerUser) {
+				http.Error(res, ErrorInviteLimitReached, http.StatusForbidden)
+				return
+			}
+		}
+		code, err := auth.MakeRefreshToken()
+		if err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		invite, err := apiCfg.DBQueries.CreateInviteCode(req.Context(), database.CreateInviteCodeParams{
+			CreatedBy: user.ID,
+			CodeHash:  auth.HashToken(code),
+			MaxUses:   params.MaxUses,
+			ExpiresAt: expiresAt,
+		})
+		if err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		apiCfg.recordAudit(req, auditInviteCreated, user.ID, user.ID, map[string]any{"invite_id": invite.ID, "max_uses": invite.MaxUses})
+		payload := newInvitePayload(invite)
+		payload.Code = code
+		respondWithJSON(res, http.StatusCreated, payload)
+	}
+}
+
+// GetInvitesHandler lists the invite codes the caller has minted, newest
+// first.
+func GetInvitesHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		user, err := apiCfg.authenticate(req)
+		if err != nil {
+			respondWithAuthError(res, err)
+			return
+		}
+		invites, err := apiCfg.DBQueries.GetUserInviteCodes(req.Context(), user.ID)
+		if err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		respondWithJSON(res, http.StatusOK, newInvitePayloads(invites))
+	}
+}
+
+// RevokeInviteHandler deletes one of the caller's invite codes. Accounts
+// already created with it are not affected.
+func RevokeInviteHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		user, err := apiCfg.authenticate(req)
+		if err != nil {
+			respondWithAuthError(res, err)
+			return
+		}
+		inviteID, err := uuid.Parse(req.PathValue("inviteID"))
+		if err != nil {
+			http.Error(res, ErrorNotFound, http.StatusNotFound)
+			return
+		}
+		deleted, err := apiCfg.DBQueries.DeleteUserInviteCode(req.Context(), database.DeleteUserInviteCodeParams{
+			ID:        inviteID,
+			CreatedBy: user.ID,
+		})
+		if err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		if deleted == 0 {
+			http.Error(res, ErrorNotFound, http.StatusNotFound)
+			return
+		}
+		apiCfg.recordAudit(req, auditInviteRevoked, user.ID, user.ID, map[string]any{"invite_id": inviteID})
+		res.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ListInvitesHandler lists every invite code, newest first, for admins.
+func ListInvitesHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		limit, offset, err := parsePagination(req)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+		invites, err := apiCfg.DBQueries.GetInviteCodes(req.Context(), database.GetInviteCodesParams{
+			PageLimit:  limit,
+			PageOffset: offset,
+		})
+		if err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		respondWithJSON(res, http.StatusOK, newInvitePayloads(invites))
+	}
+}
+
+// DeleteInviteHandler lets admins revoke anyone's invite code.
+func DeleteInviteHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		inviteID, err := uuid.Parse(req.PathValue("inviteID"))
+		if err != nil {
+			http.Error(res, ErrorNotFound, http.StatusNotFound)
+			return
+		}
+		deleted, err := apiCfg.DBQueries.DeleteInviteCode(req.Context(), inviteID)
+		if err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		if deleted == 0 {
+			http.Error(res, ErrorNotFound, http.StatusNotFound)
+			return
+		}
+		res.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// GetPendingSignupsHandler lists the accounts waiting for approval, oldest
+// first.
+func GetPendingSignupsHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		limit, offset, err := parsePagination(req)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+		users, err := apiCfg.DBQueries.GetPendingUsers(req.Context(), database.GetPendingUsersParams{
+			PageLimit:  limit,
+			PageOffset: offset,
+		})
+		if err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		payload := make([]adminUserPayload, len(users))
+		for i, user := range users {
+			payload[i] = newAdminUserPayload(user)
+		}
+		respondWithJSON(res, http.StatusOK, payload)
+	}
+}
+
+// ApproveSignupHandler lets a pending account sign in, and tells its owner.
+func ApproveSignupHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		userUUID, err := uuid.Parse(req.PathValue("userID"))
+		if err != nil {
+			http.Error(res, ErrorNotFound, http.StatusNotFound)
+			return
+		}
+		user, err := apiCfg.DBQueries.ApproveUser(req.Context(), userUUID)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(res, ErrorNotFound, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		apiCfg.sendMail(mail.Message{
+			To:      user.Email,
+			Subject: "Your Chirpy account is ready",
+			Body:    fmt.Sprintf("Your Chirpy account has been approved. You can sign in at %s.", apiCfg.BaseURL+"/app/"),
+		})
+		respondWithJSON(res, http.StatusOK, newAdminUserPayload(user))
+	}
+}
+
+// RejectSignupHandler deletes a pending account, and tells its owner. The
+// email address is free to sign up with again.
+func RejectSignupHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		userUUID, err := uuid.Parse(req.PathValue("userID"))
+		if err != nil {
+			http.Error(res, ErrorNotFound, http.StatusNotFound)
+			return
+		}
+		user, err := apiCfg.DBQueries.GetUserByID(req.Context(), userUUID)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(res, ErrorNotFound, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		deleted, err := apiCfg.DBQueries.DeletePendingUser(req.Context(), user.ID)
+		if err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		if deleted == 0 {
+			http.Error(res, ErrorNotFound, http.StatusNotFound)
+			return
+		}
+		apiCfg.sendMail(mail.Message{
+			To:      user.Email,
+			Subject: "Your Chirpy signup",
+			Body:    "Your request for a Chirpy account was not approved, and the account has been removed.",
+		})
+		res.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/charlesaraya/chirpy/internal/auth"
+	"github.com/charlesaraya/chirpy/internal/database"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// denylistSyncInterval bounds how long a token revoked by another instance
+// keeps working here.
+const denylistSyncInterval time.Duration = 30 * time.Second
+
+// revokeAccessToken denylists a single access token until it expires.
+func (cfg *ApiConfig) revokeAccessToken(ctx context.Context, userID uuid.UUID, claims *auth.Claims) error {
+	if claims.ID == "" || claims.ExpiresAt == nil {
+		return nil
+	}
+	params := database.RevokeAccessTokenParams{
+		Jti:       claims.ID,
+		UserID:    userID,
+		ExpiresAt: claims.ExpiresAt.Time,
+	}
+	if err := cfg.DBQueries.RevokeAccessToken(ctx, params); err != nil {
+		return err
+	}
+	cfg.Denylist.Add(claims.ID, claims.ExpiresAt.Time)
+	return nil
+}
+
+// signOutEverywhere revokes every refresh token the user holds and rejects
+// any access token issued to them so far.
+func (cfg *ApiConfig) signOutEverywhere(ctx context.Context, userID uuid.UUID) error {
+	if err := cfg.DBQueries.RevokeUserRefreshTokens(ctx, userID); err != nil {
+		return err
+	}
+	return cfg.DBQueries.RevokeUserAccessTokens(ctx, userID)
+}
+
+// revokeSessionAccessTokens rejects the access tokens issued alongside a
+// session's refresh tokens that have not expired yet.
+func (cfg *ApiConfig) revokeSessionAccessTokens(ctx context.Context, userID, familyID uuid.UUID) error {
+	tokens, err := cfg.DBQueries.GetSessionAccessTokens(ctx, database.GetSessionAccessTokensParams{
+		UserID:   userID,
+		FamilyID: familyID,
+	})
+	if err != nil {
+		return err
+	}
+	for _, token := range tokens {
+		claims := &auth.Claims{RegisteredClaims: jwt.RegisteredClaims{
+			ID:        token.AccessJti,
+			ExpiresAt: jwt.NewNumericDate(token.AccessExpiresAt.Time),
+		}}
+		if err := cfg.revokeAccessToken(ctx, userID, claims); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncDenylist copies tokens revoked after since from the database into the
+// in-memory denylist and returns the newest revocation time seen.
+func (cfg *ApiConfig) syncDenylist(ctx context.Context, since time.Time) (time.Time, error) {
+	revoked, err := cfg.DBQueries.GetRevokedAccessTokensSince(ctx, since)
+	if err != nil {
+		return since, err
+	}
+	for _, token := range revoked {
+		cfg.Denylist.Add(token.Jti, token.ExpiresAt)
+		since = token.RevokedAt
+	}
+	return since, nil
+}
+
+// watchDenylist keeps the in-memory denylist in step with the database and
+// clears out entries for tokens that have expired.
+func (cfg *ApiConfig) watchDenylist(since time.Time, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		ctx := context.Background()
+		var err error
+		if since, err = cfg.syncDenylist(ctx, since); err != nil {
+			log.Printf("denylist sync: %v", err)
+		}
+		cfg.Denylist.Prune(time.Now())
+		if err := cfg.DBQueries.DeleteExpiredRevokedAccessTokens(ctx); err != nil {
+			log.Printf("denylist cleanup: %v", err)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/charlesaraya/chirpy/internal/auth"
+	"github.com/charlesaraya/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// userSessionPayload describes one signed-in device. Its ID is the refresh
+// token family, which stays the same across rotations.
+type userSessionPayload struct {
+	ID         string `json:"id"`
+	Device     string `json:"device"`
+	UserAgent  string `json:"user_agent"`
+	IP         string `json:"ip"`
+	SignedInAt string `json:"signed_in_at"`
+	LastUsedAt string `json:"last_used_at"`
+	ExpiresAt  string `json:"expires_at"`
+}
+
+// Checked in order, so more specific names come before the ones they embed
+// (Edge and Chrome both claim to be Safari).
+var (
+	knownBrowsers = []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"curl/", "curl"},
+	}
+	knownPlatforms = []struct{ token, name string }{
+		{"Android", "Android"},
+		{"iPhone", "iOS"},
+		{"iPad", "iOS"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"Linux", "Linux"},
+	}
+)
+
+// describeDevice turns a user agent into a short label such as
+// "Firefox on Linux".
+func describeDevice(userAgent string) string {
+	browser, platform := "", ""
+	for _, b := range knownBrowsers {
+		if strings.Contains(userAgent, b.token) {
+			browser = b.name
+			break
+		}
+	}
+	for _, p := range knownPlatforms {
+		if strings.Contains(userAgent, p.token) {
+			platform = p.name
+			break
+		}
+	}
+	switch {
+	case browser != "" && platform != "":
+		return browser + " on " + platform
+	case browser != "":
+		return browser
+	case platform != "":
+		return platform
+	}
+	return "Unknown device"
+}
+
+// GetSessionsHandler lists the caller's active sessions, most recently used
+// first.
+func GetSessionsHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		user, err := apiCfg.authenticate(req)
+		if err != nil {
+			respondWithAuthError(res, err)
+			return
+		}
+		tokens, err := apiCfg.DBQueries.GetUserSessions(req.Context(), user.ID)
+		if err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		payload := make([]userSessionPayload, len(tokens))
+		for i, token := range tokens {
+			payload[i] = userSessionPayload{
+				ID:         token.FamilyID.String(),
+				Device:     describeDevice(token.UserAgent),
+				UserAgent:  token.UserAgent,
+				IP:         token.Ip,
+				SignedInAt: token.SignedInAt.Format(TimeFormat),
+				LastUsedAt: token.LastUsedAt.Format(TimeFormat),
+				ExpiresAt:  token.ExpiresAt.Format(TimeFormat),
+			}
+		}
+		respondWithJSON(res, http.StatusOK, payload)
+	}
+}
+
+// RevokeSessionHandler signs the caller out of one session.
+func RevokeSessionHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		user, err := apiCfg.authenticate(req)
+		if err != nil {
+			respondWithAuthError(res, err)
+			return
+		}
+		familyID, err := uuid.Parse(req.PathValue("sessionID"))
+		if err != nil {
+			http.Error(res, ErrorNotFound, http.StatusNotFound)
+			return
+		}
+		params := database.RevokeUserSessionParams{
+			UserID:   user.ID,
+			FamilyID: familyID,
+		}
+		revoked, err := apiCfg.DBQueries.RevokeUserSession(req.Context(), params)
+		if err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		if revoked == 0 {
+			http.Error(res, ErrorNotFound, http.StatusNotFound)
+			return
+		}
+		if err := apiCfg.revokeSessionAccessTokens(req.Context(), user.ID, familyID); err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		apiCfg.recordAudit(req, auditSessionRevoked, user.ID, user.ID, map[string]any{"family_id": familyID})
+		res.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// RevokeAllSessionsHandler signs the caller out everywhere, including the
+// access token used to make the request.
+func RevokeAllSessionsHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		user, err := apiCfg.authenticate(req)
+		if err != nil {
+			respondWithAuthError(res, err)
+			return
+		}
+		if err := apiCfg.signOutEverywhere(req.Context(), user.ID); err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		apiCfg.recordAudit(req, auditSessionsRevoked, user.ID, user.ID, nil)
+		res.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// LogoutHandler revokes the access token used to make the request, so it
+// stops working straight away. When the body carries the session's
+// refresh_token, or a browser its refresh cookie, that session is ended too.
+func LogoutHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		type reqPayload struct {
+			RefreshToken string `json:"refresh_token"`
+		}
+		user, err := apiCfg.authenticateForPasswordChange(req, "")
+		if err != nil {
+			respondWithAuthError(res, err)
+			return
+		}
+		params := reqPayload{}
+		if err := json.NewDecoder(req.Body).Decode(&params); err != nil && !errors.Is(err, io.EOF) {
+			http.Error(res, ErrorSomethingWentWrong, http.StatusBadRequest)
+			return
+		}
+		token, fromCookie, _ := sessionToken(req, accessTokenCookie)
+		claims, err := apiCfg.Keys.ParseJWT(token)
+		if err != nil {
+			http.Error(res, ErrorUnauthorized, http.StatusUnauthorized)
+			return
+		}
+		if err := apiCfg.revokeAccessToken(req.Context(), user.ID, claims); err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		if fromCookie {
+			if cookie, err := req.Cookie(refreshTokenCookie); err == nil {
+				params.RefreshToken = cookie.Value
+			}
+			clearSessionCookies(res)
+		}
+		if params.RefreshToken != "" {
+			refreshToken, err := apiCfg.DBQueries.GetRefreshToken(req.Context(), auth.HashToken(params.RefreshToken))
+			if err == nil && refreshToken.UserID == user.ID {
+				if err := apiCfg.DBQueries.RevokeRefreshTokenFamily(req.Context(), refreshToken.FamilyID); err != nil {
+					http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+					return
+				}
+			}
+		}
+		apiCfg.recordAudit(req, auditLogout, user.ID, user.ID, nil)
+		res.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	"github.com/charlesaraya/chirpy/internal/database"
+	"github.com/charlesaraya/chirpy/internal/spam"
+)
+
+// scoreChirp gathers the author's recent activity and scores a new chirp body.
+func scoreChirp(ctx context.Context, apiCfg *ApiConfig, user database.User, body string) (spam.Result, error) {
+	now := time.Now()
+	recentParams := database.GetRecentChirpsFromUserParams{
+		UserID:    user.ID,
+		CreatedAt: now.Add(-apiCfg.SpamConfig.Lookback),
+	}
+	recent, err := apiCfg.DBQueries.GetRecentChirpsFromUser(ctx, recentParams)
+	if err != nil {
+		return spam.Result{}, err
+	}
+	signals := spam.Signals{
+		Body:           body,
+		AccountCreated: user.CreatedAt,
+		RecentChirps:   make([]spam.Chirp, len(recent)),
+		Now:            now,
+	}
+	for i, chirp := range recent {
+		signals.RecentChirps[i] = spam.Chirp{Body: chirp.Body, CreatedAt: chirp.CreatedAt}
+	}
+	return spam.Score(apiCfg.SpamConfig, signals), nil
+}
+
+// createChirp stores a new chirp along with its spam score, if it has one,
+// in one transaction, so a failure leaves neither behind.
+func (cfg *ApiConfig) createChirp(ctx context.Context, params database.CreateChirpParams, result spam.Result) (database.Chirp, error) {
+	var chirp database.Chirp
+	err := cfg.inTx(ctx, func(queries *database.Queries) error {
+		var err error
+		chirp, err = queries.CreateChirp(ctx, params)
+		if err != nil {
+			return err
+		}
+		if result.Score <= 0 {
+			return nil
+		}
+		_, err = queries.CreateChirpSpamScore(ctx, database.CreateChirpSpamScoreParams{
+			ChirpID: chirp.ID,
+			Score:   result.Score,
+			Reasons: result.Reasons,
+		})
+		return err
+	})
+	return chirp, err
+}
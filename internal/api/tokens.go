@@ -0,0 +1,104 @@
+package api
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/charlesaraya/chirpy/internal/auth"
+	"github.com/charlesaraya/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+const RefreshTokenDuration time.Duration = 60 * 24 * time.Hour
+
+// Login methods recorded with each login.succeeded audit event.
+const (
+	loginMethodPassword     string = "password"
+	loginMethodTOTP         string = "password+totp"
+	loginMethodRecoveryCode string = "password+recovery_code"
+	loginMethodOAuth        string = "oauth"
+	loginMethodMagicLink    string = "magic_link"
+	loginMethodDevice       string = "device"
+	// loginMethodOIDC is followed by the provider's name, as in "oidc:google".
+	loginMethodOIDC string = "oidc"
+)
+
+// issueRefreshToken creates a refresh token in the given family and returns
+// the raw token. Only its hash is stored, along with the device the request
+// came from and the ID of the access token issued with it, so revoking the
+// session can reject that too. Pass a new family ID when a session starts and
+// the current one when rotating, together with the time the session started.
+func issueRefreshToken(req *http.Request, apiCfg *ApiConfig, userID, familyID uuid.UUID, signedInAt time.Time, accessToken string) (string, error) {
+	claims, err := apiCfg.Keys.ParseJWT(accessToken)
+	if err != nil {
+		return "", err
+	}
+	refreshToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		return "", err
+	}
+	refreshTokenParams := database.CreateRefreshTokenParams{
+		TokenHash:  auth.HashToken(refreshToken),
+		FamilyID:   familyID,
+		UserID:     userID,
+		ExpiresAt:  time.Now().Add(RefreshTokenDuration),
+		UserAgent:  req.UserAgent(),
+		Ip:         clientIP(req),
+		SignedInAt: signedInAt,
+		AccessJti:  claims.ID,
+	}
+	if claims.ExpiresAt != nil {
+		refreshTokenParams.AccessExpiresAt = sql.NullTime{Time: claims.ExpiresAt.Time, Valid: true}
+	}
+	if _, err := apiCfg.DBQueries.CreateRefreshToken(req.Context(), refreshTokenParams); err != nil {
+		return "", err
+	}
+	return refreshToken, nil
+}
+
+// startSession signs the user in: it issues an access and a refresh token,
+// records the login and writes them out the way LoginUserHandler always has,
+// or as cookies when the login asked for a cookie session.
+func startSession(res http.ResponseWriter, req *http.Request, apiCfg *ApiConfig, user database.User, method string) {
+	token, err := apiCfg.Keys.MakeJWT(user.ID, user.Role, MaxSessionDuration)
+	if err != nil {
+		http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+		return
+	}
+	refreshToken, err := issueRefreshToken(req, apiCfg, user.ID, uuid.New(), time.Now(), token)
+	if err != nil {
+		http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+		return
+	}
+	apiCfg.recordAudit(req, auditLoginSucceeded, user.ID, user.ID, map[string]any{"method": method})
+	if wantsCookieSession(req) {
+		if err := setSessionCookies(res, token, refreshToken); err != nil {
+			http.Error(res, ErrorInternalServerError, http.StatusInternalServerError)
+			return
+		}
+		token, refreshToken = "", ""
+	}
+	payload := UserPayload{
+		ID:                    user.ID.String(),
+		CreatedAt:             user.CreatedAt.Format(TimeFormat),
+		UpdatedAt:             user.UpdatedAt.Format(TimeFormat),
+		Email:                 user.Email,
+		IsChirpyRed:           user.IsChirpyRed,
+		Token:                 token,
+		RefreshToken:          refreshToken,
+		PasswordResetRequired: user.PasswordResetRequired,
+		EmailVerified:         user.EmailVerifiedAt.Valid,
+		PendingEmail:          user.PendingEmail.String,
+	}
+	respondWithJSON(res, http.StatusOK, payload)
+}
+
+// GetJWKSHandler publishes the public keys access tokens can be verified
+// with, so other services need not share a secret with Chirpy.
+func GetJWKSHandler(apiCfg *ApiConfig) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		res.Header().Set("Cache-Control", "public, max-age=300")
+		respondWithJSON(res, http.StatusOK, apiCfg.Keys.JWKS())
+	}
+}
@@ -50,6 +50,7 @@ func PolkaWebhookHandler(apiCfg *ApiConfig) http.HandlerFunc {
 			http.Error(res, ErrorNotFound, http.StatusNotFound)
 			return
 		}
+		apiCfg.recordAudit(req, auditWebhookUpgrade, uuid.Nil, userUUID, map[string]any{"event": reqPayload.Event})
 		res.WriteHeader(http.StatusNoContent)
 	}
 }
@@ -17,9 +17,9 @@ const testSecret = "testsecret"
 func TestJWTs(t *testing.T) {
 	validUUID := uuid.New()
 
-	validToken, _ := MakeJWT(validUUID, testSecret, time.Hour)
+	validToken, _ := MakeJWT(validUUID, RoleUser, testSecret, time.Hour)
 
-	expiredToken, _ := MakeJWT(validUUID, testSecret, -time.Hour)
+	expiredToken, _ := MakeJWT(validUUID, RoleUser, testSecret, -time.Hour)
 
 	tests := []struct {
 		name        string
@@ -82,6 +82,107 @@ func TestJWTs(t *testing.T) {
 	}
 }
 
+func TestJWTRoleClaim(t *testing.T) {
+	token, err := MakeJWT(uuid.New(), RoleModerator, testSecret, time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	claims, err := ParseJWT(token, testSecret)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if claims.Role != RoleModerator {
+		t.Errorf("expected role %q, got %q", RoleModerator, claims.Role)
+	}
+}
+
+func TestJWTIDAndIssuedBefore(t *testing.T) {
+	first, _ := MakeJWT(uuid.New(), RoleUser, testSecret, time.Hour)
+	second, _ := MakeJWT(uuid.New(), RoleUser, testSecret, time.Hour)
+	firstClaims, err := ParseJWT(first, testSecret)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	secondClaims, err := ParseJWT(second, testSecret)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if firstClaims.ID == "" || firstClaims.ID == secondClaims.ID {
+		t.Errorf("expected distinct token IDs, got %q and %q", firstClaims.ID, secondClaims.ID)
+	}
+	if firstClaims.IssuedBefore(time.Now()) {
+		t.Errorf("token issued this second should not count as issued before now")
+	}
+	if !firstClaims.IssuedBefore(time.Now().Add(time.Minute)) {
+		t.Errorf("token should count as issued before a later cutoff")
+	}
+}
+
+func TestDenylist(t *testing.T) {
+	var denylist Denylist
+	now := time.Now()
+	denylist.Add("live", now.Add(time.Hour))
+	denylist.Add("stale", now.Add(-time.Minute))
+	if !denylist.Contains("live") || !denylist.Contains("stale") {
+		t.Fatalf("expected both revoked tokens to be listed")
+	}
+	if denylist.Contains("other") {
+		t.Errorf("unexpected token in denylist")
+	}
+	denylist.Prune(now)
+	if !denylist.Contains("live") {
+		t.Errorf("unexpired token was pruned")
+	}
+	if denylist.Contains("stale") {
+		t.Errorf("expired token was not pruned")
+	}
+}
+
+func TestHasRole(t *testing.T) {
+	tests := []struct {
+		role     string
+		required string
+		want     bool
+	}{
+		{RoleUser, RoleUser, true},
+		{RoleUser, RoleModerator, false},
+		{RoleModerator, RoleModerator, true},
+		{RoleModerator, RoleAdmin, false},
+		{RoleAdmin, RoleModerator, true},
+		{"", RoleUser, false},
+		{"superuser", RoleUser, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.role+" requires "+tc.required, func(t *testing.T) {
+			if got := HasRole(tc.role, tc.required); got != tc.want {
+				t.Errorf("expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestOutranks(t *testing.T) {
+	tests := []struct {
+		role  string
+		other string
+		want  bool
+	}{
+		{RoleAdmin, RoleModerator, true},
+		{RoleModerator, RoleUser, true},
+		{RoleModerator, RoleModerator, false},
+		{RoleModerator, RoleAdmin, false},
+		{RoleAdmin, RoleAdmin, false},
+		{"superuser", RoleUser, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.role+" over "+tc.other, func(t *testing.T) {
+			if got := Outranks(tc.role, tc.other); got != tc.want {
+				t.Errorf("expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
 func TestMakeRefreshToken(t *testing.T) {
 	token, err := MakeRefreshToken()
 	if err != nil {
@@ -96,6 +197,21 @@ func TestMakeRefreshToken(t *testing.T) {
 	}
 }
 
+func TestHashToken(t *testing.T) {
+	token, _ := MakeRefreshToken()
+	hash := HashToken(token)
+	if hash == token {
+		t.Fatalf("expected hash to differ from token")
+	}
+	if hash != HashToken(token) {
+		t.Errorf("expected hashing to be deterministic")
+	}
+	other, _ := MakeRefreshToken()
+	if hash == HashToken(other) {
+		t.Errorf("expected different tokens to hash differently")
+	}
+}
+
 func TestGenerateBearerToken(t *testing.T) {
 	t.Run("use header with correct bearer", func(t *testing.T) {
 		correctHeader := http.Header{}
@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+// Denylist is an in-memory set of revoked access token IDs (jti claims).
+// Entries are kept until the token would have expired anyway. The zero value
+// is ready to use and safe for concurrent use.
+type Denylist struct {
+	mu      sync.RWMutex
+	entries map[string]time.Time
+}
+
+// Add marks the token with the given ID as revoked until expiresAt.
+func (d *Denylist) Add(jti string, expiresAt time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.entries == nil {
+		d.entries = make(map[string]time.Time)
+	}
+	d.entries[jti] = expiresAt
+}
+
+// Contains reports whether the token with the given ID has been revoked.
+func (d *Denylist) Contains(jti string) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	_, ok := d.entries[jti]
+	return ok
+}
+
+// Prune drops entries for tokens that have expired by now.
+func (d *Denylist) Prune(now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for jti, expiresAt := range d.entries {
+		if !expiresAt.After(now) {
+			delete(d.entries, jti)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	HashArgon2id string = "argon2id"
+	HashBcrypt   string = "bcrypt"
+
+	ErrPasswordMismatch  string = "password does not match hash"
+	ErrUnknownHashFormat string = "unknown password hash format"
+	ErrUnknownPepper     string = "password hash uses a different pepper"
+)
+
+// Argon2Params are the argon2id cost parameters. Memory is in KiB.
+type Argon2Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  int
+	KeyLength   uint32
+}
+
+// DefaultArgon2Params follow the OWASP recommendation for argon2id.
+func DefaultArgon2Params() Argon2Params {
+	return Argon2Params{
+		Memory:      19 * 1024,
+		Iterations:  2,
+		Parallelism: 1,
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+}
+
+// PasswordHasher hashes passwords with the configured algorithm and
+// verifies hashes made with any supported one. The algorithm and its
+// parameters are stored in each hash, so they can change without breaking
+// existing passwords; NeedsRehash tells when a hash is out of date.
+//
+// The zero value hashes with bcrypt at bcrypt.DefaultCost.
+type PasswordHasher struct {
+	Algorithm  string
+	Argon2     Argon2Params
+	BcryptCost int
+	// Pepper is an optional server-side secret mixed into argon2id hashes.
+	// Hashes record a fingerprint of it, so changing it makes them unusable.
+	Pepper []byte
+}
+
+// DefaultPasswordHasher hashes new passwords with argon2id.
+func DefaultPasswordHasher() PasswordHasher {
+	return PasswordHasher{
+		Algorithm:  HashArgon2id,
+		Argon2:     DefaultArgon2Params(),
+		BcryptCost: bcrypt.DefaultCost,
+	}
+}
+
+func (h PasswordHasher) bcryptCost() int {
+	if h.BcryptCost == 0 {
+		return bcrypt.DefaultCost
+	}
+	return h.BcryptCost
+}
+
+// Hash returns an encoded hash of password.
+func (h PasswordHasher) Hash(password string) (string, error) {
+	if h.Algorithm != HashArgon2id {
+		hashed, err := bcrypt.GenerateFromPassword([]byte(password), h.bcryptCost())
+		if err != nil {
+			return "", fmt.Errorf("hashing password: %w", err)
+		}
+		return string(hashed), nil
+	}
+	salt := make([]byte, h.Argon2.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	hash := argon2id{Argon2Params: h.Argon2, Salt: salt}
+	if len(h.Pepper) > 0 {
+		hash.KeyID = pepperID(h.Pepper)
+	}
+	hash.Key = hash.derive(h.pepper(password), h.Argon2.KeyLength)
+	return hash.String(), nil
+}
+
+// Verify checks password against an encoded hash.
+func (h PasswordHasher) Verify(encoded, password string) error {
+	if !strings.HasPrefix(encoded, "$"+HashArgon2id+"$") {
+		if err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password)); err != nil {
+			return fmt.Errorf("password hash: %w", err)
+		}
+		return nil
+	}
+	hash, err := parseArgon2id(encoded)
+	if err != nil {
+		return err
+	}
+	input := []byte(password)
+	if hash.KeyID != "" {
+		if len(h.Pepper) == 0 || hash.KeyID != pepperID(h.Pepper) {
+			return errors.New(ErrUnknownPepper)
+		}
+		input = h.pepper(password)
+	}
+	if subtle.ConstantTimeCompare(hash.derive(input, uint32(len(hash.Key))), hash.Key) != 1 {
+		return errors.New(ErrPasswordMismatch)
+	}
+	return nil
+}
+
+// NeedsRehash reports whether an encoded hash was made with a different
+// algorithm, parameters or pepper than the hasher would use now.
+func (h PasswordHasher) NeedsRehash(encoded string) bool {
+	if h.Algorithm != HashArgon2id {
+		cost, err := bcrypt.Cost([]byte(encoded))
+		return err != nil || cost != h.bcryptCost()
+	}
+	hash, err := parseArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	wantKeyID := ""
+	if len(h.Pepper) > 0 {
+		wantKeyID = pepperID(h.Pepper)
+	}
+	return hash.Memory != h.Argon2.Memory ||
+		hash.Iterations != h.Argon2.Iterations ||
+		hash.Parallelism != h.Argon2.Parallelism ||
+		len(hash.Salt) != h.Argon2.SaltLength ||
+		uint32(len(hash.Key)) != h.Argon2.KeyLength ||
+		hash.KeyID != wantKeyID
+}
+
+// pepper mixes the pepper into the password. Without one the password is
+// used as is.
+func (h PasswordHasher) pepper(password string) []byte {
+	if len(h.Pepper) == 0 {
+		return []byte(password)
+	}
+	mac := hmac.New(sha256.New, h.Pepper)
+	mac.Write([]byte(password))
+	return mac.Sum(nil)
+}
+
+// pepperID fingerprints a pepper without revealing it.
+func pepperID(pepper []byte) string {
+	sum := sha256.Sum256(pepper)
+	return base64.RawStdEncoding.EncodeToString(sum[:6])
+}
+
+// argon2id is a hash in PHC string format:
+// $argon2id$v=19$m=19456,t=2,p=1[,keyid=...]$<salt>$<key>
+type argon2id struct {
+	Argon2Params
+	KeyID string
+	Salt  []byte
+	Key   []byte
+}
+
+func (a argon2id) derive(password []byte, keyLength uint32) []byte {
+	return argon2.IDKey(password, a.Salt, a.Iterations, a.Memory, a.Parallelism, keyLength)
+}
+
+func (a argon2id) String() string {
+	params := fmt.Sprintf("m=%d,t=%d,p=%d", a.Memory, a.Iterations, a.Parallelism)
+	if a.KeyID != "" {
+		params += ",keyid=" + a.KeyID
+	}
+	return fmt.Sprintf("$%s$v=%d$%s$%s$%s", HashArgon2id, argon2.Version, params,
+		base64.RawStdEncoding.EncodeToString(a.Salt), base64.RawStdEncoding.EncodeToString(a.Key))
+}
+
+func parseArgon2id(encoded string) (argon2id, error) {
+	var hash argon2id
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != HashArgon2id || parts[2] != fmt.Sprintf("v=%d", argon2.Version) {
+		return hash, errors.New(ErrUnknownHashFormat)
+	}
+	for _, param := range strings.Split(parts[3], ",") {
+		name, value, _ := strings.Cut(param, "=")
+		var err error
+		switch name {
+		case "m":
+			_, err = fmt.Sscanf(value, "%d", &hash.Memory)
+		case "t":
+			_, err = fmt.Sscanf(value, "%d", &hash.Iterations)
+		case "p":
+			_, err = fmt.Sscanf(value, "%d", &hash.Parallelism)
+		case "keyid":
+			hash.KeyID = value
+		default:
+			err = errors.New(ErrUnknownHashFormat)
+		}
+		if err != nil {
+			return hash, errors.New(ErrUnknownHashFormat)
+		}
+	}
+	var err error
+	if hash.Salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return hash, errors.New(ErrUnknownHashFormat)
+	}
+	if hash.Key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return hash, errors.New(ErrUnknownHashFormat)
+	}
+	if hash.Memory == 0 || hash.Iterations == 0 || hash.Parallelism == 0 || len(hash.Key) == 0 {
+		return hash, errors.New(ErrUnknownHashFormat)
+	}
+	return hash, nil
+}
@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestPasswordHasher(t *testing.T) {
+	// Cheap parameters keep the test fast.
+	argon2Params := Argon2Params{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+	hashers := map[string]PasswordHasher{
+		"bcrypt":            {Algorithm: HashBcrypt, BcryptCost: bcrypt.MinCost},
+		"argon2id":          {Algorithm: HashArgon2id, Argon2: argon2Params},
+		"peppered argon2id": {Algorithm: HashArgon2id, Argon2: argon2Params, Pepper: []byte("pepper")},
+	}
+	for name, hasher := range hashers {
+		t.Run(name, func(t *testing.T) {
+			hash, err := hasher.Hash("correct horse")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err := hasher.Verify(hash, "correct horse"); err != nil {
+				t.Errorf("expected password to match, got %v", err)
+			}
+			if err := hasher.Verify(hash, "wrong horse"); err == nil {
+				t.Error("expected wrong password to be rejected")
+			}
+			if hasher.NeedsRehash(hash) {
+				t.Error("expected a fresh hash to be up to date")
+			}
+		})
+	}
+	t.Run("argon2id hash format", func(t *testing.T) {
+		hash, _ := hashers["argon2id"].Hash("correct horse")
+		if !strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$") {
+			t.Errorf("unexpected hash %q", hash)
+		}
+	})
+	t.Run("verifies hashes from other algorithms", func(t *testing.T) {
+		hash, _ := hashers["bcrypt"].Hash("correct horse")
+		if err := hashers["argon2id"].Verify(hash, "correct horse"); err != nil {
+			t.Errorf("expected bcrypt hash to verify, got %v", err)
+		}
+		if !hashers["argon2id"].NeedsRehash(hash) {
+			t.Error("expected bcrypt hash to need a rehash")
+		}
+	})
+	t.Run("outdated parameters need a rehash", func(t *testing.T) {
+		hash, _ := hashers["argon2id"].Hash("correct horse")
+		stronger := hashers["argon2id"]
+		stronger.Argon2.Iterations = 2
+		if !stronger.NeedsRehash(hash) {
+			t.Error("expected hash with fewer iterations to need a rehash")
+		}
+		if err := stronger.Verify(hash, "correct horse"); err != nil {
+			t.Errorf("expected old hash to still verify, got %v", err)
+		}
+		bcryptHash, _ := hashers["bcrypt"].Hash("correct horse")
+		if !(PasswordHasher{Algorithm: HashBcrypt, BcryptCost: bcrypt.MinCost + 1}).NeedsRehash(bcryptHash) {
+			t.Error("expected bcrypt hash with lower cost to need a rehash")
+		}
+	})
+	t.Run("pepper changes", func(t *testing.T) {
+		hash, _ := hashers["argon2id"].Hash("correct horse")
+		if !hashers["peppered argon2id"].NeedsRehash(hash) {
+			t.Error("expected unpeppered hash to need a rehash once a pepper is set")
+		}
+		if err := hashers["peppered argon2id"].Verify(hash, "correct horse"); err != nil {
+			t.Errorf("expected unpeppered hash to still verify, got %v", err)
+		}
+		peppered, _ := hashers["peppered argon2id"].Hash("correct horse")
+		if err := hashers["argon2id"].Verify(peppered, "correct horse"); err == nil {
+			t.Error("expected peppered hash to fail without the pepper")
+		}
+	})
+	t.Run("malformed hash", func(t *testing.T) {
+		if err := hashers["argon2id"].Verify("$argon2id$v=19$m=64$bad", "correct horse"); err == nil {
+			t.Error("expected malformed hash to be rejected")
+		}
+	})
+}
@@ -0,0 +1,382 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	AlgHS256 string = "HS256"
+	AlgEdDSA string = "EdDSA"
+	AlgRS256 string = "RS256"
+
+	// DefaultKeyID names the HS256 key built from TOKEN_SECRET when no
+	// keyring file is configured.
+	DefaultKeyID    string = "default"
+	DefaultAudience string = "chirpy"
+
+	ErrUnknownKeyID      string = "unknown signing key id"
+	ErrUnexpectedAlg     string = "token algorithm does not match its key"
+	ErrNoSigningKey      string = "keyring has no usable signing key"
+	ErrUnsupportedKeyAlg string = "unsupported key algorithm"
+)
+
+// Key is one entry of a Keyring. Keys without private material can only
+// verify tokens, which is how retired keys are kept around during rotation.
+type Key struct {
+	ID        string
+	Algorithm string
+	signKey   any
+	verifyKey any
+}
+
+// NewHMACKey returns an HS256 key that both signs and verifies.
+func NewHMACKey(kid string, secret []byte) *Key {
+	return &Key{ID: kid, Algorithm: AlgHS256, signKey: secret, verifyKey: secret}
+}
+
+// NewEd25519Key returns an EdDSA key. Pass a nil private key to get a
+// verify-only key.
+func NewEd25519Key(kid string, private ed25519.PrivateKey, public ed25519.PublicKey) *Key {
+	key := &Key{ID: kid, Algorithm: AlgEdDSA, verifyKey: public}
+	if private != nil {
+		key.signKey = private
+		key.verifyKey = private.Public()
+	}
+	return key
+}
+
+// NewRSAKey returns an RS256 key. Pass a nil private key to get a
+// verify-only key.
+func NewRSAKey(kid string, private *rsa.PrivateKey, public *rsa.PublicKey) *Key {
+	key := &Key{ID: kid, Algorithm: AlgRS256, verifyKey: public}
+	if private != nil {
+		key.signKey = private
+		key.verifyKey = &private.PublicKey
+	}
+	return key
+}
+
+// CanSign reports whether the key holds private material.
+func (k *Key) CanSign() bool {
+	return k.signKey != nil
+}
+
+func (k *Key) method() jwt.SigningMethod {
+	return jwt.GetSigningMethod(k.Algorithm)
+}
+
+// Keyring signs tokens with one key and verifies them with any of its keys,
+// picked by the token's kid header. Rotating keys means adding the new key,
+// making it the signing key, and dropping the old one once the tokens it
+// signed have expired.
+type Keyring struct {
+	Issuer   string
+	Audience string
+	signing  *Key
+	keys     map[string]*Key
+}
+
+// NewKeyring builds a keyring that signs with the key named signingKID.
+func NewKeyring(issuer, audience string, signingKID string, keys ...*Key) (*Keyring, error) {
+	keyring := &Keyring{
+		Issuer:   issuer,
+		Audience: audience,
+		keys:     make(map[string]*Key, len(keys)),
+	}
+	for _, key := range keys {
+		if key.method() == nil {
+			return nil, fmt.Errorf("key %q: %s %q", key.ID, ErrUnsupportedKeyAlg, key.Algorithm)
+		}
+		if _, ok := keyring.keys[key.ID]; ok {
+			return nil, fmt.Errorf("duplicate key id %q", key.ID)
+		}
+		keyring.keys[key.ID] = key
+	}
+	signing, ok := keyring.keys[signingKID]
+	if !ok || !signing.CanSign() {
+		return nil, errors.New(ErrNoSigningKey)
+	}
+	keyring.signing = signing
+	return keyring, nil
+}
+
+// NewHMACKeyring returns a keyring holding a single HS256 key, for setups
+// that only configure TOKEN_SECRET.
+func NewHMACKeyring(secret string) *Keyring {
+	key := NewHMACKey(DefaultKeyID, []byte(secret))
+	return &Keyring{
+		Issuer:   issuer,
+		Audience: DefaultAudience,
+		signing:  key,
+		keys:     map[string]*Key{key.ID: key},
+	}
+}
+
+// Sign signs claims with the current signing key, stamping the keyring's
+// issuer and a fresh jti. Claims that already name an audience keep it.
+func (k *Keyring) Sign(claims *Claims) (string, error) {
+	claims.Issuer = k.Issuer
+	if len(claims.Audience) == 0 {
+		claims.Audience = jwt.ClaimStrings{k.Audience}
+	}
+	if claims.ID == "" {
+		claims.ID = uuid.NewString()
+	}
+	token := jwt.NewWithClaims(k.signing.method(), claims)
+	token.Header["kid"] = k.signing.ID
+	signedToken, err := token.SignedString(k.signing.signKey)
+	if err != nil {
+		return "", fmt.Errorf("signing token: %w", err)
+	}
+	return signedToken, nil
+}
+
+// MakeJWT issues an access token for the user.
+func (k *Keyring) MakeJWT(userID uuid.UUID, role string, expiresIn time.Duration) (string, error) {
+	now := time.Now()
+	return k.Sign(&Claims{
+		Role: role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(now.Add(expiresIn)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			Subject:   userID.String(),
+		},
+	})
+}
+
+// MakeClientJWT issues an access token that lets an OAuth client act for the
+// user within the given scopes. It carries no role, so it never passes a role
+// check.
+func (k *Keyring) MakeClientJWT(userID uuid.UUID, clientID string, scopes []string, expiresIn time.Duration) (string, error) {
+	now := time.Now()
+	return k.Sign(&Claims{
+		ClientID: clientID,
+		Scope:    FormatScope(scopes),
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(now.Add(expiresIn)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			Subject:   userID.String(),
+		},
+	})
+}
+
+// ParseJWT verifies an access token issued by this keyring.
+func (k *Keyring) ParseJWT(tokenString string) (*Claims, error) {
+	return k.Parse(tokenString, k.Audience)
+}
+
+// Parse verifies a token's signature, expiry, issuer and audience.
+func (k *Keyring) Parse(tokenString, audience string) (*Claims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, k.keyFunc,
+		jwt.WithIssuer(k.Issuer),
+		jwt.WithAudience(audience),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	claims, ok := token.Claims.(*Claims)
+	if !ok {
+		return nil, errors.New(ErrUnknownClaimsType)
+	}
+	return claims, nil
+}
+
+func (k *Keyring) keyFunc(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := k.keys[kid]
+	if !ok {
+		return nil, errors.New(ErrUnknownKeyID)
+	}
+	// Checking the algorithm against the key stops a public key from being
+	// used as an HMAC secret.
+	if token.Method.Alg() != key.Algorithm {
+		return nil, errors.New(ErrUnexpectedAlg)
+	}
+	return key.verifyKey, nil
+}
+
+// JWK is a public key in JSON Web Key format.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public halves of the keyring's asymmetric keys. HMAC keys
+// are secret and never published.
+func (k *Keyring) JWKS() JWKS {
+	set := JWKS{Keys: []JWK{}}
+	for _, key := range k.keys {
+		switch public := key.verifyKey.(type) {
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				KeyType:   "OKP",
+				KeyID:     key.ID,
+				Algorithm: key.Algorithm,
+				Use:       "sig",
+				Curve:     "Ed25519",
+				X:         base64.RawURLEncoding.EncodeToString(public),
+			})
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				KeyType:   "RSA",
+				KeyID:     key.ID,
+				Algorithm: key.Algorithm,
+				Use:       "sig",
+				N:         base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+				E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+			})
+		}
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].KeyID < set.Keys[j].KeyID })
+	return set
+}
+
+// keyringFile is the JSON layout read by LoadKeyring. Key files are PEM
+// encoded (PKCS#8 private keys, PKIX public keys) and resolved relative to
+// the keyring file. HS256 secrets are read from the named environment
+// variable so they stay out of the file.
+type keyringFile struct {
+	Issuer     string `json:"issuer"`
+	Audience   string `json:"audience"`
+	SigningKey string `json:"signing_key"`
+	Keys       []struct {
+		ID             string `json:"kid"`
+		Algorithm      string `json:"alg"`
+		SecretEnv      string `json:"secret_env"`
+		PrivateKeyFile string `json:"private_key_file"`
+		PublicKeyFile  string `json:"public_key_file"`
+	} `json:"keys"`
+}
+
+// LoadKeyring reads a keyring from a JSON file.
+func LoadKeyring(path string) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading keyring: %w", err)
+	}
+	var file keyringFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parsing keyring: %w", err)
+	}
+	if file.Issuer == "" {
+		file.Issuer = issuer
+	}
+	if file.Audience == "" {
+		file.Audience = DefaultAudience
+	}
+	dir := filepath.Dir(path)
+	keys := make([]*Key, 0, len(file.Keys))
+	for _, entry := range file.Keys {
+		var key *Key
+		switch entry.Algorithm {
+		case AlgHS256:
+			secret := os.Getenv(entry.SecretEnv)
+			if entry.SecretEnv == "" || secret == "" {
+				return nil, fmt.Errorf("key %q: secret_env must name a non-empty variable", entry.ID)
+			}
+			key = NewHMACKey(entry.ID, []byte(secret))
+		case AlgEdDSA, AlgRS256:
+			private, public, err := loadKeyPair(dir, entry.PrivateKeyFile, entry.PublicKeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: %w", entry.ID, err)
+			}
+			if key, err = newAsymmetricKey(entry.ID, entry.Algorithm, private, public); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("key %q: %s %q", entry.ID, ErrUnsupportedKeyAlg, entry.Algorithm)
+		}
+		keys = append(keys, key)
+	}
+	return NewKeyring(file.Issuer, file.Audience, file.SigningKey, keys...)
+}
+
+func loadKeyPair(dir, privateFile, publicFile string) (crypto.Signer, crypto.PublicKey, error) {
+	if privateFile != "" {
+		block, err := readPEM(filepath.Join(dir, privateFile))
+		if err != nil {
+			return nil, nil, err
+		}
+		private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, nil, fmt.Errorf("parsing private key: %w", err)
+		}
+		signer, ok := private.(crypto.Signer)
+		if !ok {
+			return nil, nil, errors.New(ErrUnsupportedKeyAlg)
+		}
+		return signer, signer.Public(), nil
+	}
+	if publicFile == "" {
+		return nil, nil, errors.New("private_key_file or public_key_file is required")
+	}
+	block, err := readPEM(filepath.Join(dir, publicFile))
+	if err != nil {
+		return nil, nil, err
+	}
+	public, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing public key: %w", err)
+	}
+	return nil, public, nil
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading key file: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data", path)
+	}
+	return block, nil
+}
+
+func newAsymmetricKey(kid, alg string, private crypto.Signer, public crypto.PublicKey) (*Key, error) {
+	switch alg {
+	case AlgEdDSA:
+		edPublic, ok := public.(ed25519.PublicKey)
+		if !ok {
+			break
+		}
+		edPrivate, _ := private.(ed25519.PrivateKey)
+		return NewEd25519Key(kid, edPrivate, edPublic), nil
+	case AlgRS256:
+		rsaPublic, ok := public.(*rsa.PublicKey)
+		if !ok {
+			break
+		}
+		rsaPrivate, _ := private.(*rsa.PrivateKey)
+		return NewRSAKey(kid, rsaPrivate, rsaPublic), nil
+	}
+	return nil, fmt.Errorf("key %q: key material does not match %s", kid, alg)
+}
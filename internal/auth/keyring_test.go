@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+func TestKeyringRotation(t *testing.T) {
+	_, edPrivate, _ := ed25519.GenerateKey(rand.Reader)
+	rsaPrivate, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating rsa key: %v", err)
+	}
+	oldKey := NewHMACKey("old", []byte(testSecret))
+	edKey := NewEd25519Key("ed", edPrivate, nil)
+	rsaKey := NewRSAKey("rsa", rsaPrivate, nil)
+
+	before, err := NewKeyring(issuer, DefaultAudience, "old", oldKey)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	oldToken, _ := before.MakeJWT(uuid.New(), RoleUser, time.Hour)
+
+	for _, signingKID := range []string{"ed", "rsa"} {
+		t.Run(signingKID, func(t *testing.T) {
+			after, err := NewKeyring(issuer, DefaultAudience, signingKID, oldKey, edKey, rsaKey)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if _, err := after.ParseJWT(oldToken); err != nil {
+				t.Errorf("token signed by the old key should still verify: %v", err)
+			}
+			newToken, err := after.MakeJWT(uuid.New(), RoleUser, time.Hour)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if _, err := after.ParseJWT(newToken); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if _, err := before.ParseJWT(newToken); err == nil {
+				t.Errorf("keyring without the new key should reject its tokens")
+			}
+		})
+	}
+}
+
+func TestKeyringValidation(t *testing.T) {
+	_, edPrivate, _ := ed25519.GenerateKey(rand.Reader)
+	keyring, err := NewKeyring(issuer, DefaultAudience, "ed", NewEd25519Key("ed", edPrivate, nil))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	other, _ := NewKeyring("someone-else", DefaultAudience, "ed", NewEd25519Key("ed", edPrivate, nil))
+	foreignIssuer, _ := other.MakeJWT(uuid.New(), RoleUser, time.Hour)
+
+	claims := &Claims{RegisteredClaims: jwt.RegisteredClaims{
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		Audience:  jwt.ClaimStrings{"another-service"},
+	}}
+	foreignAudience, _ := keyring.Sign(claims)
+
+	// An HS256 token keyed with the public key must not pass as EdDSA.
+	confused := jwt.NewWithClaims(jwt.SigningMethodHS256, &Claims{RegisteredClaims: jwt.RegisteredClaims{
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		Issuer:    issuer,
+		Audience:  jwt.ClaimStrings{DefaultAudience},
+	}})
+	confused.Header["kid"] = "ed"
+	confusedToken, _ := confused.SignedString([]byte(edPrivate.Public().(ed25519.PublicKey)))
+
+	unknownKID, _ := NewHMACKeyring(testSecret).MakeJWT(uuid.New(), RoleUser, time.Hour)
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{name: "wrong issuer", token: foreignIssuer, wantErr: jwt.ErrTokenInvalidIssuer},
+		{name: "wrong audience", token: foreignAudience, wantErr: jwt.ErrTokenInvalidAudience},
+		{name: "algorithm confusion", token: confusedToken, wantErr: jwt.ErrTokenUnverifiable},
+		{name: "unknown kid", token: unknownKID, wantErr: jwt.ErrTokenUnverifiable},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := keyring.ParseJWT(tc.token)
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("expected error %v, got %v", tc.wantErr, err)
+			}
+		})
+	}
+	if _, err := keyring.Parse(foreignAudience, "another-service"); err != nil {
+		t.Errorf("token should verify for its own audience: %v", err)
+	}
+}
+
+func TestLoadKeyring(t *testing.T) {
+	dir := t.TempDir()
+	_, edPrivate, _ := ed25519.GenerateKey(rand.Reader)
+	rsaPrivate, _ := rsa.GenerateKey(rand.Reader, 2048)
+	writePEM(t, filepath.Join(dir, "ed.pem"), "PRIVATE KEY", mustMarshal(x509.MarshalPKCS8PrivateKey(edPrivate)))
+	writePEM(t, filepath.Join(dir, "rsa.pub.pem"), "PUBLIC KEY", mustMarshal(x509.MarshalPKIXPublicKey(&rsaPrivate.PublicKey)))
+	t.Setenv("CHIRPY_TEST_SECRET", testSecret)
+	config := `{
+		"issuer": "chirpy",
+		"audience": "chirpy-api",
+		"signing_key": "ed",
+		"keys": [
+			{"kid": "ed", "alg": "EdDSA", "private_key_file": "ed.pem"},
+			{"kid": "rsa", "alg": "RS256", "public_key_file": "rsa.pub.pem"},
+			{"kid": "legacy", "alg": "HS256", "secret_env": "CHIRPY_TEST_SECRET"}
+		]
+	}`
+	path := filepath.Join(dir, "keys.json")
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatalf("writing keyring: %v", err)
+	}
+	keyring, err := LoadKeyring(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if keyring.Audience != "chirpy-api" {
+		t.Errorf("expected audience %q, got %q", "chirpy-api", keyring.Audience)
+	}
+	token, _ := keyring.MakeJWT(uuid.New(), RoleUser, time.Hour)
+	if _, err := keyring.ParseJWT(token); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	jwks := keyring.JWKS()
+	if len(jwks.Keys) != 2 {
+		t.Fatalf("expected the two public keys in the JWKS, got %+v", jwks.Keys)
+	}
+	if jwks.Keys[0].KeyID != "ed" || jwks.Keys[0].KeyType != "OKP" || jwks.Keys[0].X == "" {
+		t.Errorf("unexpected EdDSA key %+v", jwks.Keys[0])
+	}
+	if jwks.Keys[1].KeyID != "rsa" || jwks.Keys[1].KeyType != "RSA" || jwks.Keys[1].E != "AQAB" {
+		t.Errorf("unexpected RSA key %+v", jwks.Keys[1])
+	}
+
+	if err := os.WriteFile(path, []byte(`{"signing_key": "rsa", "keys": [{"kid": "rsa", "alg": "RS256", "public_key_file": "rsa.pub.pem"}]}`), 0o600); err != nil {
+		t.Fatalf("writing keyring: %v", err)
+	}
+	if _, err := LoadKeyring(path); err == nil {
+		t.Errorf("expected an error when the signing key has no private half")
+	}
+}
+
+func mustMarshal(der []byte, err error) []byte {
+	if err != nil {
+		panic(err)
+	}
+	return der
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("writing %s: %v", path, err)
+	}
+}
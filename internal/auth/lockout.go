@@ -0,0 +1,76 @@
+package auth
+
+import "time"
+
+// LockoutPolicy throttles repeated failed logins. After FreeAttempts
+// failures each further one locks logins for BaseDelay, doubling every time
+// up to MaxDelay. Failures are forgotten after ResetAfter without one.
+//
+// The zero value never locks.
+type LockoutPolicy struct {
+	FreeAttempts int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	ResetAfter   time.Duration
+}
+
+// DefaultAccountLockoutPolicy throttles guesses against a single account.
+func DefaultAccountLockoutPolicy() LockoutPolicy {
+	return LockoutPolicy{
+		FreeAttempts: 5,
+		BaseDelay:    30 * time.Second,
+		MaxDelay:     30 * time.Minute,
+		ResetAfter:   24 * time.Hour,
+	}
+}
+
+// DefaultIPLockoutPolicy throttles a single client guessing across many
+// accounts. It is looser than the account policy, as many users may share
+// an address.
+func DefaultIPLockoutPolicy() LockoutPolicy {
+	return LockoutPolicy{
+		FreeAttempts: 20,
+		BaseDelay:    time.Second,
+		MaxDelay:     15 * time.Minute,
+		ResetAfter:   time.Hour,
+	}
+}
+
+// DefaultMagicLinkPolicy limits how often sign-in links are emailed to one
+// address, so the endpoint cannot be used to flood someone's inbox.
+func DefaultMagicLinkPolicy() LockoutPolicy {
+	return LockoutPolicy{
+		FreeAttempts: 3,
+		BaseDelay:    time.Minute,
+		MaxDelay:     time.Hour,
+		ResetAfter:   time.Hour,
+	}
+}
+
+// DefaultMFALockoutPolicy throttles guesses at a user's second factor once
+// their password is known. A TOTP code has a million values, so guessing
+// has to stay far slower than codes change.
+func DefaultMFALockoutPolicy() LockoutPolicy {
+	return LockoutPolicy{
+		FreeAttempts: 5,
+		BaseDelay:    time.Minute,
+		MaxDelay:     time.Hour,
+		ResetAfter:   24 * time.Hour,
+	}
+}
+
+// Delay returns how long logins stay locked after the given number of
+// consecutive failures.
+func (p LockoutPolicy) Delay(failures int) time.Duration {
+	if failures <= p.FreeAttempts || p.BaseDelay <= 0 {
+		return 0
+	}
+	delay := p.BaseDelay
+	for range failures - p.FreeAttempts - 1 {
+		delay *= 2
+		if delay >= p.MaxDelay {
+			return p.MaxDelay
+		}
+	}
+	return min(delay, p.MaxDelay)
+}
@@ -0,0 +1,30 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLockoutPolicyDelay(t *testing.T) {
+	policy := LockoutPolicy{FreeAttempts: 3, BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{failures: 0, want: 0},
+		{failures: 3, want: 0},
+		{failures: 4, want: time.Second},
+		{failures: 5, want: 2 * time.Second},
+		{failures: 7, want: 8 * time.Second},
+		{failures: 8, want: 10 * time.Second},
+		{failures: 1000, want: 10 * time.Second},
+	}
+	for _, tc := range tests {
+		if got := policy.Delay(tc.failures); got != tc.want {
+			t.Errorf("after %d failures expected %v, got %v", tc.failures, tc.want, got)
+		}
+	}
+	if got := (LockoutPolicy{}).Delay(100); got != 0 {
+		t.Errorf("expected zero policy never to lock, got %v", got)
+	}
+}
@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"slices"
+	"strings"
+)
+
+// PKCEMethodS256 is the only PKCE challenge method accepted. The plain
+// method offers no protection if the authorization request leaks.
+const PKCEMethodS256 string = "S256"
+
+// userCodeAlphabet has no vowels, so user codes never spell words, and no
+// characters that are easily mistaken for one another. 20^8 codes is plenty
+// for codes that live ten minutes (RFC 8628, section 6.1).
+const (
+	userCodeAlphabet string = "BCDFGHJKLMNPQRSTVWXZ"
+	userCodeLength   int    = 8
+)
+
+// ParseScope splits a space-separated OAuth scope string, dropping
+// duplicates. It fails if the string is empty or names an unknown scope.
+func ParseScope(scope string) ([]string, bool) {
+	var scopes []string
+	for _, s := range strings.Fields(scope) {
+		if !IsValidScope(s) {
+			return nil, false
+		}
+		if !slices.Contains(scopes, s) {
+			scopes = append(scopes, s)
+		}
+	}
+	return scopes, len(scopes) > 0
+}
+
+// FormatScope joins scopes into an OAuth scope string.
+func FormatScope(scopes []string) string {
+	return strings.Join(scopes, " ")
+}
+
+// Scopes returns the scopes granted to an OAuth client token.
+func (c *Claims) Scopes() []string {
+	return strings.Fields(c.Scope)
+}
+
+// PKCEChallenge derives the S256 code challenge for a code verifier.
+func PKCEChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// VerifyPKCE checks a code verifier against the S256 challenge sent with the
+// authorization request. Verifiers must be 43 to 128 characters long.
+func VerifyPKCE(verifier, challenge string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(PKCEChallenge(verifier)), []byte(challenge)) == 1
+}
+
+// MakeUserCode returns a random device flow user code, such as "BCDF-GHJK",
+// for people to type in on another device.
+func MakeUserCode() (string, error) {
+	b := make([]byte, userCodeLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	code := make([]byte, 0, userCodeLength+1)
+	for i, c := range b {
+		if i == userCodeLength/2 {
+			code = append(code, '-')
+		}
+		// 256 is not a multiple of 20, but the bias is too small to matter.
+		code = append(code, userCodeAlphabet[int(c)%len(userCodeAlphabet)])
+	}
+	return string(code), nil
+}
+
+// NormalizeUserCode undoes what people do when typing a user code: lower
+// case, and dropped or extra dashes and spaces.
+func NormalizeUserCode(code string) string {
+	code = strings.ToUpper(code)
+	code = strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, code)
+	if len(code) != userCodeLength {
+		return code
+	}
+	return code[:userCodeLength/2] + "-" + code[userCodeLength/2:]
+}
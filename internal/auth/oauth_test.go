@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestParseScope(t *testing.T) {
+	tests := []struct {
+		scope  string
+		want   []string
+		wantOK bool
+	}{
+		{scope: "chirps:read", want: []string{ScopeChirpsRead}, wantOK: true},
+		{scope: " chirps:read  chirps:write chirps:read", want: []string{ScopeChirpsRead, ScopeChirpsWrite}, wantOK: true},
+		{scope: "", wantOK: false},
+		{scope: "chirps:read admin", wantOK: false},
+	}
+	for _, tc := range tests {
+		got, ok := ParseScope(tc.scope)
+		if ok != tc.wantOK || !slices.Equal(got, tc.want) {
+			t.Errorf("ParseScope(%q) = %v, %v; expected %v, %v", tc.scope, got, ok, tc.want, tc.wantOK)
+		}
+	}
+}
+
+func TestVerifyPKCE(t *testing.T) {
+	// Example from RFC 7636, appendix B.
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+	if got := PKCEChallenge(verifier); got != challenge {
+		t.Errorf("expected challenge %q, got %q", challenge, got)
+	}
+	if !VerifyPKCE(verifier, challenge) {
+		t.Error("expected verifier to match")
+	}
+	if VerifyPKCE(strings.Repeat("a", 43), challenge) {
+		t.Error("expected wrong verifier to be rejected")
+	}
+	if VerifyPKCE("short", PKCEChallenge("short")) {
+		t.Error("expected too short verifier to be rejected")
+	}
+}
+
+func TestMakeClientJWT(t *testing.T) {
+	keys := NewHMACKeyring(testSecret)
+	userID := uuid.New()
+	token, err := keys.MakeClientJWT(userID, "client-1", []string{ScopeChirpsRead, ScopeChirpsWrite}, time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	claims, err := keys.ParseJWT(token)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if claims.ClientID != "client-1" || claims.Subject != userID.String() {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+	if !slices.Equal(claims.Scopes(), []string{ScopeChirpsRead, ScopeChirpsWrite}) {
+		t.Errorf("unexpected scopes %v", claims.Scopes())
+	}
+	if HasRole(claims.Role, RoleUser) {
+		t.Error("expected client tokens to carry no role")
+	}
+}
+
+func TestUserCode(t *testing.T) {
+	code, err := MakeUserCode()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(code) != 9 || code[4] != '-' || strings.Trim(code, userCodeAlphabet+"-") != "" {
+		t.Errorf("unexpected user code %q", code)
+	}
+	if NormalizeUserCode(code) != code {
+		t.Errorf("expected %q to already be normal, got %q", code, NormalizeUserCode(code))
+	}
+	tests := map[string]string{
+		"bcdf-ghjk":   "BCDF-GHJK",
+		"BCDFGHJK":    "BCDF-GHJK",
+		" bcd fghjk ": "BCDF-GHJK",
+		"BCD":         "BCD",
+	}
+	for input, want := range tests {
+		if got := NormalizeUserCode(input); got != want {
+			t.Errorf("NormalizeUserCode(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
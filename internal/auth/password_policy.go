@@ -0,0 +1,101 @@
+package auth
+
+import (
+	_ "embed"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Codes identifying which rule of a PasswordPolicy a password broke.
+const (
+	PasswordTooShort       string = "too_short"
+	PasswordTooLong        string = "too_long"
+	PasswordCommon         string = "common"
+	PasswordSimilarToEmail string = "similar_to_email"
+)
+
+// bcryptMaxBytes is the most bcrypt reads of a password; anything after it
+// is silently ignored.
+const bcryptMaxBytes int = 72
+
+//go:embed common_passwords.txt
+var commonPasswordList string
+
+var commonPasswords = func() map[string]bool {
+	passwords := make(map[string]bool)
+	for _, line := range strings.Split(commonPasswordList, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			passwords[line] = true
+		}
+	}
+	return passwords
+}()
+
+// PasswordPolicy decides which passwords users may choose.
+type PasswordPolicy struct {
+	MinLength            int
+	MaxBytes             int
+	RejectCommon         bool
+	RejectSimilarToEmail bool
+}
+
+// PasswordViolation describes one rule a password broke.
+type PasswordViolation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func DefaultPasswordPolicy() PasswordPolicy {
+	return PasswordPolicy{
+		MinLength:            8,
+		MaxBytes:             bcryptMaxBytes,
+		RejectCommon:         true,
+		RejectSimilarToEmail: true,
+	}
+}
+
+// Check returns every rule the password breaks for the account with the
+// given email. An empty result means the password is acceptable.
+func (p PasswordPolicy) Check(password, email string) []PasswordViolation {
+	var violations []PasswordViolation
+	if utf8.RuneCountInString(password) < p.MinLength {
+		violations = append(violations, PasswordViolation{
+			Code:    PasswordTooShort,
+			Message: fmt.Sprintf("Password must be at least %d characters long", p.MinLength),
+		})
+	}
+	if p.MaxBytes > 0 && len(password) > p.MaxBytes {
+		violations = append(violations, PasswordViolation{
+			Code:    PasswordTooLong,
+			Message: fmt.Sprintf("Password must be at most %d bytes long", p.MaxBytes),
+		})
+	}
+	lower := strings.ToLower(password)
+	if p.RejectCommon && commonPasswords[lower] {
+		violations = append(violations, PasswordViolation{
+			Code:    PasswordCommon,
+			Message: "Password is too common",
+		})
+	}
+	if p.RejectSimilarToEmail && similarToEmail(lower, strings.ToLower(email)) {
+		violations = append(violations, PasswordViolation{
+			Code:    PasswordSimilarToEmail,
+			Message: "Password must not contain your email address",
+		})
+	}
+	return violations
+}
+
+// similarToEmail reports whether a lowercased password is built around the
+// email address or its local part.
+func similarToEmail(password, email string) bool {
+	if password == "" || email == "" {
+		return false
+	}
+	local, _, _ := strings.Cut(email, "@")
+	if strings.Contains(password, email) || strings.Contains(email, password) {
+		return true
+	}
+	return len(local) >= 3 && strings.Contains(password, local)
+}
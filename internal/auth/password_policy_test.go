@@ -0,0 +1,35 @@
+package auth
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestPasswordPolicy(t *testing.T) {
+	policy := DefaultPasswordPolicy()
+	tests := []struct {
+		name     string
+		password string
+		want     []string
+	}{
+		{name: "acceptable", password: "correct horse battery", want: nil},
+		{name: "empty", password: "", want: []string{PasswordTooShort}},
+		{name: "short and common", password: "qwerty", want: []string{PasswordTooShort, PasswordCommon}},
+		{name: "common regardless of case", password: "Password123", want: []string{PasswordCommon}},
+		{name: "too long for bcrypt", password: strings.Repeat("é", 40), want: []string{PasswordTooLong}},
+		{name: "contains email local part", password: "walter-rocks-2024", want: []string{PasswordSimilarToEmail}},
+		{name: "is the email", password: "walter@breakingbad.com", want: []string{PasswordSimilarToEmail}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var got []string
+			for _, violation := range policy.Check(tc.password, "walter@breakingbad.com") {
+				got = append(got, violation.Code)
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("expected violations %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
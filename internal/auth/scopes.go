@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"slices"
+	"strings"
+)
+
+// Scopes limit what a personal access token may do. Session tokens from a
+// login are not scoped.
+const (
+	ScopeChirpsRead   string = "chirps:read"
+	ScopeChirpsWrite  string = "chirps:write"
+	ScopeProfileWrite string = "profile:write"
+)
+
+// PersonalAccessTokenPrefix marks personal access tokens, telling them apart
+// from JWTs in the Authorization header and making leaked ones easy to spot.
+const PersonalAccessTokenPrefix string = "chirpy_pat_"
+
+var validScopes = []string{ScopeChirpsRead, ScopeChirpsWrite, ScopeProfileWrite}
+
+func IsValidScope(scope string) bool {
+	return slices.Contains(validScopes, scope)
+}
+
+// HasScope reports whether scopes include required.
+func HasScope(scopes []string, required string) bool {
+	return slices.Contains(scopes, required)
+}
+
+// MakePersonalAccessToken returns a new random personal access token.
+func MakePersonalAccessToken() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return PersonalAccessTokenPrefix + hex.EncodeToString(key), nil
+}
+
+func IsPersonalAccessToken(token string) bool {
+	return strings.HasPrefix(token, PersonalAccessTokenPrefix)
+}
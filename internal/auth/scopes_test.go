@@ -0,0 +1,34 @@
+package auth
+
+import "testing"
+
+func TestPersonalAccessTokens(t *testing.T) {
+	token, err := MakePersonalAccessToken()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !IsPersonalAccessToken(token) {
+		t.Errorf("expected %q to be recognised as a personal access token", token)
+	}
+	other, _ := MakePersonalAccessToken()
+	if token == other {
+		t.Error("expected tokens to differ")
+	}
+	jwt, _ := MakeJWT([16]byte{}, RoleUser, testSecret, 0)
+	if IsPersonalAccessToken(jwt) {
+		t.Error("expected a JWT not to be recognised as a personal access token")
+	}
+}
+
+func TestScopes(t *testing.T) {
+	if !IsValidScope(ScopeChirpsWrite) || IsValidScope("chirps:admin") {
+		t.Error("unexpected scope validity")
+	}
+	scopes := []string{ScopeChirpsRead}
+	if !HasScope(scopes, ScopeChirpsRead) {
+		t.Error("expected chirps:read to be granted")
+	}
+	if HasScope(scopes, ScopeChirpsWrite) {
+		t.Error("expected chirps:write not to be granted by chirps:read")
+	}
+}
@@ -8,29 +8,33 @@ package database
 import (
 	"context"
 	"database/sql"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 const createChirp = `-- name: CreateChirp :one
-INSERT INTO chirps (id, user_id, created_at, updated_at, body)
+INSERT INTO chirps (id, user_id, created_at, updated_at, body, status)
 VALUES (
     gen_random_uuid (),
     $1,
     NOW(),
     NOW(),
-    $2
+    $2,
+    $3
 )
-RETURNING id, user_id, created_at, updated_at, body
+RETURNING id, user_id, created_at, updated_at, body, status
 `
 
 type CreateChirpParams struct {
 	UserID uuid.UUID
 	Body   string
+	Status string
 }
 
 func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp, error) {
-	row := q.db.QueryRowContext(ctx, createChirp, arg.UserID, arg.Body)
+	row := q.db.QueryRowContext(ctx, createChirp, arg.UserID, arg.Body, arg.Status)
 	var i Chirp
 	err := row.Scan(
 		&i.ID,
@@ -38,6 +42,7 @@ func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.Body,
+		&i.Status,
 	)
 	return i, err
 }
@@ -67,7 +72,7 @@ func (q *Queries) DeleteChirps(ctx context.Context) error {
 }
 
 const getChirps = `-- name: GetChirps :many
-SELECT id, user_id, created_at, updated_at, body FROM chirps
+SELECT id, user_id, created_at, updated_at, body, status FROM chirps
 ORDER BY created_at
 `
 
@@ -86,6 +91,7 @@ func (q *Queries) GetChirps(ctx context.Context) ([]Chirp, error) {
 			&i.CreatedAt,
 			&i.UpdatedAt,
 			&i.Body,
+			&i.Status,
 		); err != nil {
 			return nil, err
 		}
@@ -101,7 +107,7 @@ func (q *Queries) GetChirps(ctx context.Context) ([]Chirp, error) {
 }
 
 const getChirpsFromUser = `-- name: GetChirpsFromUser :many
-SELECT id, user_id, created_at, updated_at, body FROM chirps
+SELECT id, user_id, created_at, updated_at, body, status FROM chirps
 WHERE user_id = $1
 ORDER BY created_at
 `
@@ -121,6 +127,103 @@ func (q *Queries) GetChirpsFromUser(ctx context.Context, userID uuid.UUID) ([]Ch
 			&i.CreatedAt,
 			&i.UpdatedAt,
 			&i.Body,
+			&i.Status,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getHeldChirps = `-- name: GetHeldChirps :many
+SELECT chirps.id, chirps.user_id, chirps.created_at, chirps.updated_at, chirps.body, chirps.status,
+    COALESCE(chirp_spam_scores.score, 0)::float8 AS score,
+    COALESCE(chirp_spam_scores.reasons, '{}')::text[] AS reasons
+FROM chirps
+LEFT JOIN chirp_spam_scores ON chirp_spam_scores.chirp_id = chirps.id
+WHERE chirps.status = 'held'
+ORDER BY chirps.created_at
+`
+
+type GetHeldChirpsRow struct {
+	ID        uuid.UUID
+	UserID    uuid.UUID
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Body      string
+	Status    string
+	Score     float64
+	Reasons   []string
+}
+
+// Held chirps without a spam score are listed too, so none are stuck out of
+// a moderator's sight.
+func (q *Queries) GetHeldChirps(ctx context.Context) ([]GetHeldChirpsRow, error) {
+	rows, err := q.db.QueryContext(ctx, getHeldChirps)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetHeldChirpsRow
+	for rows.Next() {
+		var i GetHeldChirpsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.Status,
+			&i.Score,
+			pq.Array(&i.Reasons),
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getRecentChirpsFromUser = `-- name: GetRecentChirpsFromUser :many
+SELECT id, user_id, created_at, updated_at, body, status FROM chirps
+WHERE user_id = $1 AND created_at > $2
+ORDER BY created_at DESC
+`
+
+type GetRecentChirpsFromUserParams struct {
+	UserID    uuid.UUID
+	CreatedAt time.Time
+}
+
+func (q *Queries) GetRecentChirpsFromUser(ctx context.Context, arg GetRecentChirpsFromUserParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getRecentChirpsFromUser, arg.UserID, arg.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.Status,
 		); err != nil {
 			return nil, err
 		}
@@ -136,7 +239,7 @@ func (q *Queries) GetChirpsFromUser(ctx context.Context, userID uuid.UUID) ([]Ch
 }
 
 const getSingleChirp = `-- name: GetSingleChirp :one
-SELECT id, user_id, created_at, updated_at, body FROM chirps
+SELECT id, user_id, created_at, updated_at, body, status FROM chirps
 WHERE id = $1
 `
 
@@ -149,6 +252,195 @@ func (q *Queries) GetSingleChirp(ctx context.Context, id uuid.UUID) (Chirp, erro
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.Body,
+		&i.Status,
+	)
+	return i, err
+}
+
+const getVisibleChirp = `-- name: GetVisibleChirp :one
+SELECT chirps.id, chirps.user_id, chirps.created_at, chirps.updated_at, chirps.body, chirps.status FROM chirps
+JOIN users ON users.id = chirps.user_id
+WHERE chirps.id = $1
+AND ((chirps.status = 'published' AND users.is_shadowbanned = false) OR chirps.user_id = $2)
+`
+
+type GetVisibleChirpParams struct {
+	ID       uuid.UUID
+	ViewerID uuid.UUID
+}
+
+func (q *Queries) GetVisibleChirp(ctx context.Context, arg GetVisibleChirpParams) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, getVisibleChirp, arg.ID, arg.ViewerID)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.Status,
+	)
+	return i, err
+}
+
+const getVisibleChirps = `-- name: GetVisibleChirps :many
+SELECT chirps.id, chirps.user_id, chirps.created_at, chirps.updated_at, chirps.body, chirps.status FROM chirps
+JOIN users ON users.id = chirps.user_id
+WHERE (chirps.status = 'published' AND users.is_shadowbanned = false) OR chirps.user_id = $1
+ORDER BY chirps.created_at
+`
+
+func (q *Queries) GetVisibleChirps(ctx context.Context, viewerID uuid.UUID) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getVisibleChirps, viewerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.Status,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getVisibleChirpsFromUser = `-- name: GetVisibleChirpsFromUser :many
+SELECT chirps.id, chirps.user_id, chirps.created_at, chirps.updated_at, chirps.body, chirps.status FROM chirps
+JOIN users ON users.id = chirps.user_id
+WHERE chirps.user_id = $1
+AND ((chirps.status = 'published' AND users.is_shadowbanned = false) OR chirps.user_id = $2)
+ORDER BY chirps.created_at
+`
+
+type GetVisibleChirpsFromUserParams struct {
+	UserID   uuid.UUID
+	ViewerID uuid.UUID
+}
+
+func (q *Queries) GetVisibleChirpsFromUser(ctx context.Context, arg GetVisibleChirpsFromUserParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getVisibleChirpsFromUser, arg.UserID, arg.ViewerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.Status,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const importChirp = `-- name: ImportChirp :execrows
+INSERT INTO chirps (id, user_id, created_at, updated_at, body, status)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6
+)
+ON CONFLICT (id) DO NOTHING
+`
+
+type ImportChirpParams struct {
+	ID        uuid.UUID
+	UserID    uuid.UUID
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Body      string
+	Status    string
+}
+
+func (q *Queries) ImportChirp(ctx context.Context, arg ImportChirpParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, importChirp,
+		arg.ID,
+		arg.UserID,
+		arg.CreatedAt,
+		arg.UpdatedAt,
+		arg.Body,
+		arg.Status,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const reassignUserChirps = `-- name: ReassignUserChirps :execrows
+UPDATE chirps
+SET user_id = $1
+WHERE user_id = $2
+`
+
+type ReassignUserChirpsParams struct {
+	ToUserID   uuid.UUID
+	FromUserID uuid.UUID
+}
+
+func (q *Queries) ReassignUserChirps(ctx context.Context, arg ReassignUserChirpsParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, reassignUserChirps, arg.ToUserID, arg.FromUserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const setChirpStatus = `-- name: SetChirpStatus :one
+UPDATE chirps
+SET status = $2, updated_at = NOW()
+WHERE id = $1
+RETURNING id, user_id, created_at, updated_at, body, status
+`
+
+type SetChirpStatusParams struct {
+	ID     uuid.UUID
+	Status string
+}
+
+func (q *Queries) SetChirpStatus(ctx context.Context, arg SetChirpStatusParams) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, setChirpStatus, arg.ID, arg.Status)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.Status,
 	)
 	return i, err
 }
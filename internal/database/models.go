@@ -6,33 +6,235 @@ package database
 
 import (
 	"database/sql"
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+type AccountDeletion struct {
+	UserID      uuid.UUID
+	RequestedAt time.Time
+	EraseAfter  time.Time
+	Attempts    int32
+	RetryAfter  sql.NullTime
+}
+
+type AccountExport struct {
+	ID          uuid.UUID
+	UserID      uuid.UUID
+	Status      string
+	CreatedAt   time.Time
+	StartedAt   sql.NullTime
+	CompletedAt sql.NullTime
+	ExpiresAt   sql.NullTime
+}
+
+type AccountExportArchive struct {
+	ExportID uuid.UUID
+	Data     []byte
+}
+
+type AuditEvent struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	Action    string
+	ActorID   uuid.NullUUID
+	TargetID  uuid.NullUUID
+	Ip        string
+	UserAgent string
+	Metadata  json.RawMessage
+}
+
 type Chirp struct {
 	ID        uuid.UUID
 	UserID    uuid.UUID
 	CreatedAt time.Time
 	UpdatedAt time.Time
 	Body      string
+	Status    string
 }
 
-type RefreshToken struct {
-	Token     string
+type ChirpSpamScore struct {
+	ChirpID   uuid.UUID
+	Score     float64
+	Reasons   []string
+	CreatedAt time.Time
+}
+
+type DeviceAuthorization struct {
+	ID              uuid.UUID
+	DeviceCodeHash  string
+	UserCode        string
+	UserAgent       string
+	Ip              string
+	Status          string
+	UserID          uuid.NullUUID
+	IntervalSeconds int32
+	LastPolledAt    sql.NullTime
+	CreatedAt       time.Time
+	ExpiresAt       time.Time
+}
+
+type EmailVerificationToken struct {
+	ID        uuid.UUID
 	UserID    uuid.UUID
+	Email     string
+	TokenHash string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    sql.NullTime
+}
+
+type InviteCode struct {
+	ID        uuid.UUID
+	CreatedBy uuid.UUID
+	CodeHash  string
+	MaxUses   int32
+	Uses      int32
+	CreatedAt time.Time
+	ExpiresAt sql.NullTime
+}
+
+type LoginThrottle struct {
+	Key            string
+	FailedAttempts int32
+	LastFailedAt   time.Time
+	LockedUntil    sql.NullTime
+}
+
+type MagicLinkToken struct {
+	ID        uuid.UUID
+	UserID    uuid.UUID
+	TokenHash string
+	NonceHash string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    sql.NullTime
+}
+
+type MfaRecoveryCode struct {
+	ID        uuid.UUID
+	UserID    uuid.UUID
+	CodeHash  string
+	CreatedAt time.Time
+	UsedAt    sql.NullTime
+}
+
+type OauthAuthorizationCode struct {
+	ID            uuid.UUID
+	CodeHash      string
+	ClientID      uuid.UUID
+	UserID        uuid.UUID
+	RedirectUri   string
+	Scopes        []string
+	CodeChallenge string
+	CreatedAt     time.Time
+	ExpiresAt     time.Time
+	UsedAt        sql.NullTime
+}
+
+type OauthClient struct {
+	ID           uuid.UUID
+	OwnerID      uuid.UUID
+	Name         string
+	SecretHash   sql.NullString
+	RedirectUris []string
+	CreatedAt    time.Time
+}
+
+type OauthGrant struct {
+	UserID    uuid.UUID
+	ClientID  uuid.UUID
+	Scopes    []string
 	CreatedAt time.Time
 	UpdatedAt time.Time
+}
+
+type OidcLoginState struct {
+	StateHash    string
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+	CreatedAt    time.Time
+	ExpiresAt    time.Time
+}
+
+type PasswordResetToken struct {
+	ID        uuid.UUID
+	UserID    uuid.UUID
+	TokenHash string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    sql.NullTime
+}
+
+type PersonalAccessToken struct {
+	ID         uuid.UUID
+	UserID     uuid.UUID
+	Name       string
+	TokenHash  string
+	Scopes     []string
+	CreatedAt  time.Time
+	ExpiresAt  sql.NullTime
+	LastUsedAt sql.NullTime
+}
+
+type RefreshToken struct {
+	UserID          uuid.UUID
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+	ExpiresAt       time.Time
+	RevokedAt       sql.NullTime
+	ID              uuid.UUID
+	TokenHash       string
+	FamilyID        uuid.UUID
+	RotatedAt       sql.NullTime
+	UserAgent       string
+	Ip              string
+	SignedInAt      time.Time
+	LastUsedAt      time.Time
+	AccessJti       string
+	AccessExpiresAt sql.NullTime
+}
+
+type RevokedAccessToken struct {
+	Jti       string
+	UserID    uuid.UUID
 	ExpiresAt time.Time
-	RevokedAt sql.NullTime
+	RevokedAt time.Time
+}
+
+type TotpCredential struct {
+	UserID       uuid.UUID
+	Secret       string
+	CreatedAt    time.Time
+	ConfirmedAt  sql.NullTime
+	LastUsedStep int64
 }
 
 type User struct {
-	ID             uuid.UUID
-	CreatedAt      time.Time
-	UpdatedAt      time.Time
-	Email          string
-	HashedPassword string
-	IsChirpyRed    bool
+	ID                    uuid.UUID
+	CreatedAt             time.Time
+	UpdatedAt             time.Time
+	Email                 string
+	HashedPassword        string
+	IsChirpyRed           bool
+	SuspendedAt           sql.NullTime
+	SuspensionReason      sql.NullString
+	IsShadowbanned        bool
+	Role                  string
+	PasswordResetRequired bool
+	TokensValidAfter      sql.NullTime
+	EmailVerifiedAt       sql.NullTime
+	PendingEmail          sql.NullString
+	PendingApproval       bool
+}
+
+type UserIdentity struct {
+	Provider  string
+	Subject   string
+	UserID    uuid.UUID
+	Email     string
+	CreatedAt time.Time
 }
@@ -7,40 +7,74 @@ package database
 
 import (
 	"context"
+	"database/sql"
 	"time"
 
 	"github.com/google/uuid"
 )
 
 const createRefreshToken = `-- name: CreateRefreshToken :one
-INSERT INTO refresh_tokens (token, user_id, created_at, updated_at, expires_at, revoked_at)
+INSERT INTO refresh_tokens (id, token_hash, family_id, user_id, created_at, updated_at, expires_at, revoked_at, user_agent, ip, signed_in_at, last_used_at, access_jti, access_expires_at)
 VALUES (
+    gen_random_uuid (),
     $1,
     $2,
+    $3,
     NOW(),
     NOW(),
-    $3,
-    NULL
+    $4,
+    NULL,
+    $5,
+    $6,
+    $7,
+    NOW(),
+    $8,
+    $9
 )
-RETURNING token, user_id, created_at, updated_at, expires_at, revoked_at
+RETURNING user_id, created_at, updated_at, expires_at, revoked_at, id, token_hash, family_id, rotated_at, user_agent, ip, signed_in_at, last_used_at, access_jti, access_expires_at
 `
 
 type CreateRefreshTokenParams struct {
-	Token     string
-	UserID    uuid.UUID
-	ExpiresAt time.Time
+	TokenHash       string
+	FamilyID        uuid.UUID
+	UserID          uuid.UUID
+	ExpiresAt       time.Time
+	UserAgent       string
+	Ip              string
+	SignedInAt      time.Time
+	AccessJti       string
+	AccessExpiresAt sql.NullTime
 }
 
 func (q *Queries) CreateRefreshToken(ctx context.Context, arg CreateRefreshTokenParams) (RefreshToken, error) {
-	row := q.db.QueryRowContext(ctx, createRefreshToken, arg.Token, arg.UserID, arg.ExpiresAt)
+	row := q.db.QueryRowContext(ctx, createRefreshToken,
+		arg.TokenHash,
+		arg.FamilyID,
+		arg.UserID,
+		arg.ExpiresAt,
+		arg.UserAgent,
+		arg.Ip,
+		arg.SignedInAt,
+		arg.AccessJti,
+		arg.AccessExpiresAt,
+	)
 	var i RefreshToken
 	err := row.Scan(
-		&i.Token,
 		&i.UserID,
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.ExpiresAt,
 		&i.RevokedAt,
+		&i.ID,
+		&i.TokenHash,
+		&i.FamilyID,
+		&i.RotatedAt,
+		&i.UserAgent,
+		&i.Ip,
+		&i.SignedInAt,
+		&i.LastUsedAt,
+		&i.AccessJti,
+		&i.AccessExpiresAt,
 	)
 	return i, err
 }
@@ -55,31 +89,225 @@ func (q *Queries) DeleteTokens(ctx context.Context) error {
 }
 
 const getRefreshToken = `-- name: GetRefreshToken :one
-SELECT token, user_id, created_at, updated_at, expires_at, revoked_at FROM refresh_tokens
-WHERE token = $1
+SELECT user_id, created_at, updated_at, expires_at, revoked_at, id, token_hash, family_id, rotated_at, user_agent, ip, signed_in_at, last_used_at, access_jti, access_expires_at FROM refresh_tokens
+WHERE token_hash = $1
 `
 
-func (q *Queries) GetRefreshToken(ctx context.Context, token string) (RefreshToken, error) {
-	row := q.db.QueryRowContext(ctx, getRefreshToken, token)
+func (q *Queries) GetRefreshToken(ctx context.Context, tokenHash string) (RefreshToken, error) {
+	row := q.db.QueryRowContext(ctx, getRefreshToken, tokenHash)
 	var i RefreshToken
 	err := row.Scan(
-		&i.Token,
 		&i.UserID,
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.ExpiresAt,
 		&i.RevokedAt,
+		&i.ID,
+		&i.TokenHash,
+		&i.FamilyID,
+		&i.RotatedAt,
+		&i.UserAgent,
+		&i.Ip,
+		&i.SignedInAt,
+		&i.LastUsedAt,
+		&i.AccessJti,
+		&i.AccessExpiresAt,
 	)
 	return i, err
 }
 
+const getSessionAccessTokens = `-- name: GetSessionAccessTokens :many
+SELECT access_jti, access_expires_at FROM refresh_tokens
+WHERE user_id = $1 AND family_id = $2 AND access_jti <> '' AND access_expires_at > NOW()
+`
+
+type GetSessionAccessTokensParams struct {
+	UserID   uuid.UUID
+	FamilyID uuid.UUID
+}
+
+type GetSessionAccessTokensRow struct {
+	AccessJti       string
+	AccessExpiresAt sql.NullTime
+}
+
+// Access tokens issued alongside a session's refresh tokens that have not
+// expired yet.
+func (q *Queries) GetSessionAccessTokens(ctx context.Context, arg GetSessionAccessTokensParams) ([]GetSessionAccessTokensRow, error) {
+	rows, err := q.db.QueryContext(ctx, getSessionAccessTokens, arg.UserID, arg.FamilyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetSessionAccessTokensRow
+	for rows.Next() {
+		var i GetSessionAccessTokensRow
+		if err := rows.Scan(&i.AccessJti, &i.AccessExpiresAt); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getUserRefreshTokens = `-- name: GetUserRefreshTokens :many
+SELECT user_id, created_at, updated_at, expires_at, revoked_at, id, token_hash, family_id, rotated_at, user_agent, ip, signed_in_at, last_used_at, access_jti, access_expires_at FROM refresh_tokens
+WHERE user_id = $1
+ORDER BY created_at DESC
+`
+
+func (q *Queries) GetUserRefreshTokens(ctx context.Context, userID uuid.UUID) ([]RefreshToken, error) {
+	rows, err := q.db.QueryContext(ctx, getUserRefreshTokens, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []RefreshToken
+	for rows.Next() {
+		var i RefreshToken
+		if err := rows.Scan(
+			&i.UserID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.ExpiresAt,
+			&i.RevokedAt,
+			&i.ID,
+			&i.TokenHash,
+			&i.FamilyID,
+			&i.RotatedAt,
+			&i.UserAgent,
+			&i.Ip,
+			&i.SignedInAt,
+			&i.LastUsedAt,
+			&i.AccessJti,
+			&i.AccessExpiresAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getUserSessions = `-- name: GetUserSessions :many
+SELECT user_id, created_at, updated_at, expires_at, revoked_at, id, token_hash, family_id, rotated_at, user_agent, ip, signed_in_at, last_used_at, access_jti, access_expires_at FROM refresh_tokens
+WHERE user_id = $1 AND rotated_at IS NULL AND revoked_at IS NULL AND expires_at > NOW()
+ORDER BY last_used_at DESC
+`
+
+func (q *Queries) GetUserSessions(ctx context.Context, userID uuid.UUID) ([]RefreshToken, error) {
+	rows, err := q.db.QueryContext(ctx, getUserSessions, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []RefreshToken
+	for rows.Next() {
+		var i RefreshToken
+		if err := rows.Scan(
+			&i.UserID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.ExpiresAt,
+			&i.RevokedAt,
+			&i.ID,
+			&i.TokenHash,
+			&i.FamilyID,
+			&i.RotatedAt,
+			&i.UserAgent,
+			&i.Ip,
+			&i.SignedInAt,
+			&i.LastUsedAt,
+			&i.AccessJti,
+			&i.AccessExpiresAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const revokeRefreshToken = `-- name: RevokeRefreshToken :exec
 UPDATE refresh_tokens
 SET revoked_at = NOW(), updated_at = NOW()
-WHERE token = $1
+WHERE token_hash = $1
 `
 
-func (q *Queries) RevokeRefreshToken(ctx context.Context, token string) error {
-	_, err := q.db.ExecContext(ctx, revokeRefreshToken, token)
+func (q *Queries) RevokeRefreshToken(ctx context.Context, tokenHash string) error {
+	_, err := q.db.ExecContext(ctx, revokeRefreshToken, tokenHash)
 	return err
 }
+
+const revokeRefreshTokenFamily = `-- name: RevokeRefreshTokenFamily :exec
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE family_id = $1 AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeRefreshTokenFamily(ctx context.Context, familyID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, revokeRefreshTokenFamily, familyID)
+	return err
+}
+
+const revokeUserRefreshTokens = `-- name: RevokeUserRefreshTokens :exec
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE user_id = $1 AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeUserRefreshTokens(ctx context.Context, userID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, revokeUserRefreshTokens, userID)
+	return err
+}
+
+const revokeUserSession = `-- name: RevokeUserSession :execrows
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE user_id = $1 AND family_id = $2 AND revoked_at IS NULL
+`
+
+type RevokeUserSessionParams struct {
+	UserID   uuid.UUID
+	FamilyID uuid.UUID
+}
+
+func (q *Queries) RevokeUserSession(ctx context.Context, arg RevokeUserSessionParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, revokeUserSession, arg.UserID, arg.FamilyID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const rotateRefreshToken = `-- name: RotateRefreshToken :execrows
+UPDATE refresh_tokens
+SET rotated_at = NOW(), updated_at = NOW()
+WHERE id = $1 AND rotated_at IS NULL AND revoked_at IS NULL
+`
+
+func (q *Queries) RotateRefreshToken(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, rotateRefreshToken, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
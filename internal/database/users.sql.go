@@ -7,29 +7,97 @@ package database
 
 import (
 	"context"
+	"database/sql"
 
 	"github.com/google/uuid"
 )
 
+const approveUser = `-- name: ApproveUser :one
+UPDATE users
+SET pending_approval = false, updated_at = NOW()
+WHERE id = $1 AND pending_approval
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, suspended_at, suspension_reason, is_shadowbanned, role, password_reset_required, tokens_valid_after, email_verified_at, pending_email, pending_approval
+`
+
+func (q *Queries) ApproveUser(ctx context.Context, id uuid.UUID) (User, error) {
+	row := q.db.QueryRowContext(ctx, approveUser, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.SuspendedAt,
+		&i.SuspensionReason,
+		&i.IsShadowbanned,
+		&i.Role,
+		&i.PasswordResetRequired,
+		&i.TokensValidAfter,
+		&i.EmailVerifiedAt,
+		&i.PendingEmail,
+		&i.PendingApproval,
+	)
+	return i, err
+}
+
+const confirmEmailChange = `-- name: ConfirmEmailChange :one
+UPDATE users
+SET email = $2, pending_email = NULL, email_verified_at = NOW(), updated_at = NOW()
+WHERE id = $1 AND pending_email = $2
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, suspended_at, suspension_reason, is_shadowbanned, role, password_reset_required, tokens_valid_after, email_verified_at, pending_email, pending_approval
+`
+
+type ConfirmEmailChangeParams struct {
+	ID    uuid.UUID
+	Email string
+}
+
+func (q *Queries) ConfirmEmailChange(ctx context.Context, arg ConfirmEmailChangeParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, confirmEmailChange, arg.ID, arg.Email)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.SuspendedAt,
+		&i.SuspensionReason,
+		&i.IsShadowbanned,
+		&i.Role,
+		&i.PasswordResetRequired,
+		&i.TokensValidAfter,
+		&i.EmailVerifiedAt,
+		&i.PendingEmail,
+		&i.PendingApproval,
+	)
+	return i, err
+}
+
 const createUser = `-- name: CreateUser :one
-INSERT INTO users (id, created_at, updated_at, email, hashed_password)
+INSERT INTO users (id, created_at, updated_at, email, hashed_password, pending_approval)
 VALUES (
     gen_random_uuid (),
     NOW(),
     NOW(),
     $1,
-    $2
+    $2,
+    $3
 )
-RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, suspended_at, suspension_reason, is_shadowbanned, role, password_reset_required, tokens_valid_after, email_verified_at, pending_email, pending_approval
 `
 
 type CreateUserParams struct {
-	Email          string
-	HashedPassword string
+	Email           string
+	HashedPassword  string
+	PendingApproval bool
 }
 
 func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, error) {
-	row := q.db.QueryRowContext(ctx, createUser, arg.Email, arg.HashedPassword)
+	row := q.db.QueryRowContext(ctx, createUser, arg.Email, arg.HashedPassword, arg.PendingApproval)
 	var i User
 	err := row.Scan(
 		&i.ID,
@@ -38,10 +106,45 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, e
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.SuspendedAt,
+		&i.SuspensionReason,
+		&i.IsShadowbanned,
+		&i.Role,
+		&i.PasswordResetRequired,
+		&i.TokensValidAfter,
+		&i.EmailVerifiedAt,
+		&i.PendingEmail,
+		&i.PendingApproval,
 	)
 	return i, err
 }
 
+const deletePendingUser = `-- name: DeletePendingUser :execrows
+DELETE FROM users
+WHERE id = $1 AND pending_approval
+`
+
+func (q *Queries) DeletePendingUser(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deletePendingUser, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteUser = `-- name: DeleteUser :execrows
+DELETE FROM users
+WHERE id = $1
+`
+
+func (q *Queries) DeleteUser(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteUser, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const deleteUsers = `-- name: DeleteUsers :exec
 DELETE FROM users
 `
@@ -51,8 +154,59 @@ func (q *Queries) DeleteUsers(ctx context.Context) error {
 	return err
 }
 
+const getPendingUsers = `-- name: GetPendingUsers :many
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, suspended_at, suspension_reason, is_shadowbanned, role, password_reset_required, tokens_valid_after, email_verified_at, pending_email, pending_approval FROM users
+WHERE pending_approval
+ORDER BY created_at, id
+LIMIT $1 OFFSET $2
+`
+
+type GetPendingUsersParams struct {
+	PageLimit  int32
+	PageOffset int32
+}
+
+func (q *Queries) GetPendingUsers(ctx context.Context, arg GetPendingUsersParams) ([]User, error) {
+	rows, err := q.db.QueryContext(ctx, getPendingUsers, arg.PageLimit, arg.PageOffset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []User
+	for rows.Next() {
+		var i User
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Email,
+			&i.HashedPassword,
+			&i.IsChirpyRed,
+			&i.SuspendedAt,
+			&i.SuspensionReason,
+			&i.IsShadowbanned,
+			&i.Role,
+			&i.PasswordResetRequired,
+			&i.TokensValidAfter,
+			&i.EmailVerifiedAt,
+			&i.PendingEmail,
+			&i.PendingApproval,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getUser = `-- name: GetUser :one
-SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red FROM users
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, suspended_at, suspension_reason, is_shadowbanned, role, password_reset_required, tokens_valid_after, email_verified_at, pending_email, pending_approval FROM users
 WHERE email = $1
 `
 
@@ -66,15 +220,415 @@ func (q *Queries) GetUser(ctx context.Context, email string) (User, error) {
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.SuspendedAt,
+		&i.SuspensionReason,
+		&i.IsShadowbanned,
+		&i.Role,
+		&i.PasswordResetRequired,
+		&i.TokensValidAfter,
+		&i.EmailVerifiedAt,
+		&i.PendingEmail,
+		&i.PendingApproval,
+	)
+	return i, err
+}
+
+const getUserByID = `-- name: GetUserByID :one
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, suspended_at, suspension_reason, is_shadowbanned, role, password_reset_required, tokens_valid_after, email_verified_at, pending_email, pending_approval FROM users
+WHERE id = $1
+`
+
+func (q *Queries) GetUserByID(ctx context.Context, id uuid.UUID) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUserByID, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.SuspendedAt,
+		&i.SuspensionReason,
+		&i.IsShadowbanned,
+		&i.Role,
+		&i.PasswordResetRequired,
+		&i.TokensValidAfter,
+		&i.EmailVerifiedAt,
+		&i.PendingEmail,
+		&i.PendingApproval,
+	)
+	return i, err
+}
+
+const markEmailVerified = `-- name: MarkEmailVerified :one
+UPDATE users
+SET email_verified_at = NOW(), updated_at = NOW()
+WHERE id = $1 AND email = $2
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, suspended_at, suspension_reason, is_shadowbanned, role, password_reset_required, tokens_valid_after, email_verified_at, pending_email, pending_approval
+`
+
+type MarkEmailVerifiedParams struct {
+	ID    uuid.UUID
+	Email string
+}
+
+func (q *Queries) MarkEmailVerified(ctx context.Context, arg MarkEmailVerifiedParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, markEmailVerified, arg.ID, arg.Email)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.SuspendedAt,
+		&i.SuspensionReason,
+		&i.IsShadowbanned,
+		&i.Role,
+		&i.PasswordResetRequired,
+		&i.TokensValidAfter,
+		&i.EmailVerifiedAt,
+		&i.PendingEmail,
+		&i.PendingApproval,
+	)
+	return i, err
+}
+
+const rehashUserPassword = `-- name: RehashUserPassword :execrows
+UPDATE users
+SET hashed_password = $3
+WHERE id = $1 AND hashed_password = $2
+`
+
+type RehashUserPasswordParams struct {
+	ID               uuid.UUID
+	HashedPassword   string
+	HashedPassword_2 string
+}
+
+func (q *Queries) RehashUserPassword(ctx context.Context, arg RehashUserPasswordParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, rehashUserPassword, arg.ID, arg.HashedPassword, arg.HashedPassword_2)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const requirePasswordReset = `-- name: RequirePasswordReset :one
+UPDATE users
+SET password_reset_required = true, updated_at = NOW()
+WHERE id = $1
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, suspended_at, suspension_reason, is_shadowbanned, role, password_reset_required, tokens_valid_after, email_verified_at, pending_email, pending_approval
+`
+
+func (q *Queries) RequirePasswordReset(ctx context.Context, id uuid.UUID) (User, error) {
+	row := q.db.QueryRowContext(ctx, requirePasswordReset, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.SuspendedAt,
+		&i.SuspensionReason,
+		&i.IsShadowbanned,
+		&i.Role,
+		&i.PasswordResetRequired,
+		&i.TokensValidAfter,
+		&i.EmailVerifiedAt,
+		&i.PendingEmail,
+		&i.PendingApproval,
+	)
+	return i, err
+}
+
+const revokeUserAccessTokens = `-- name: RevokeUserAccessTokens :exec
+UPDATE users
+SET tokens_valid_after = NOW()
+WHERE id = $1
+`
+
+func (q *Queries) RevokeUserAccessTokens(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, revokeUserAccessTokens, id)
+	return err
+}
+
+const searchUsers = `-- name: SearchUsers :many
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, suspended_at, suspension_reason, is_shadowbanned, role, password_reset_required, tokens_valid_after, email_verified_at, pending_email, pending_approval FROM users
+WHERE ($1::text IS NULL OR email ILIKE $1)
+AND ($2::timestamp IS NULL OR created_at >= $2)
+AND ($3::timestamp IS NULL OR created_at < $3)
+AND ($4::boolean IS NULL OR is_chirpy_red = $4)
+ORDER BY created_at, id
+LIMIT $5 OFFSET $6
+`
+
+type SearchUsersParams struct {
+	Email         sql.NullString
+	CreatedAfter  sql.NullTime
+	CreatedBefore sql.NullTime
+	IsChirpyRed   sql.NullBool
+	PageLimit     int32
+	PageOffset    int32
+}
+
+func (q *Queries) SearchUsers(ctx context.Context, arg SearchUsersParams) ([]User, error) {
+	rows, err := q.db.QueryContext(ctx, searchUsers,
+		arg.Email,
+		arg.CreatedAfter,
+		arg.CreatedBefore,
+		arg.IsChirpyRed,
+		arg.PageLimit,
+		arg.PageOffset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []User
+	for rows.Next() {
+		var i User
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Email,
+			&i.HashedPassword,
+			&i.IsChirpyRed,
+			&i.SuspendedAt,
+			&i.SuspensionReason,
+			&i.IsShadowbanned,
+			&i.Role,
+			&i.PasswordResetRequired,
+			&i.TokensValidAfter,
+			&i.EmailVerifiedAt,
+			&i.PendingEmail,
+			&i.PendingApproval,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const setChirpyRed = `-- name: SetChirpyRed :one
+UPDATE users
+SET is_chirpy_red = $2, updated_at = NOW()
+WHERE id = $1
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, suspended_at, suspension_reason, is_shadowbanned, role, password_reset_required, tokens_valid_after, email_verified_at, pending_email, pending_approval
+`
+
+type SetChirpyRedParams struct {
+	ID          uuid.UUID
+	IsChirpyRed bool
+}
+
+func (q *Queries) SetChirpyRed(ctx context.Context, arg SetChirpyRedParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, setChirpyRed, arg.ID, arg.IsChirpyRed)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.SuspendedAt,
+		&i.SuspensionReason,
+		&i.IsShadowbanned,
+		&i.Role,
+		&i.PasswordResetRequired,
+		&i.TokensValidAfter,
+		&i.EmailVerifiedAt,
+		&i.PendingEmail,
+		&i.PendingApproval,
+	)
+	return i, err
+}
+
+const setPendingEmail = `-- name: SetPendingEmail :exec
+UPDATE users
+SET pending_email = $2, updated_at = NOW()
+WHERE id = $1
+`
+
+type SetPendingEmailParams struct {
+	ID           uuid.UUID
+	PendingEmail sql.NullString
+}
+
+func (q *Queries) SetPendingEmail(ctx context.Context, arg SetPendingEmailParams) error {
+	_, err := q.db.ExecContext(ctx, setPendingEmail, arg.ID, arg.PendingEmail)
+	return err
+}
+
+const setUserPassword = `-- name: SetUserPassword :exec
+UPDATE users
+SET hashed_password = $2, password_reset_required = false, updated_at = NOW()
+WHERE id = $1
+`
+
+type SetUserPasswordParams struct {
+	ID             uuid.UUID
+	HashedPassword string
+}
+
+func (q *Queries) SetUserPassword(ctx context.Context, arg SetUserPasswordParams) error {
+	_, err := q.db.ExecContext(ctx, setUserPassword, arg.ID, arg.HashedPassword)
+	return err
+}
+
+const setUserRole = `-- name: SetUserRole :one
+UPDATE users
+SET role = $2, updated_at = NOW()
+WHERE id = $1
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, suspended_at, suspension_reason, is_shadowbanned, role, password_reset_required, tokens_valid_after, email_verified_at, pending_email, pending_approval
+`
+
+type SetUserRoleParams struct {
+	ID   uuid.UUID
+	Role string
+}
+
+func (q *Queries) SetUserRole(ctx context.Context, arg SetUserRoleParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, setUserRole, arg.ID, arg.Role)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.SuspendedAt,
+		&i.SuspensionReason,
+		&i.IsShadowbanned,
+		&i.Role,
+		&i.PasswordResetRequired,
+		&i.TokensValidAfter,
+		&i.EmailVerifiedAt,
+		&i.PendingEmail,
+		&i.PendingApproval,
+	)
+	return i, err
+}
+
+const setUserShadowban = `-- name: SetUserShadowban :one
+UPDATE users
+SET is_shadowbanned = $2, updated_at = NOW()
+WHERE id = $1
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, suspended_at, suspension_reason, is_shadowbanned, role, password_reset_required, tokens_valid_after, email_verified_at, pending_email, pending_approval
+`
+
+type SetUserShadowbanParams struct {
+	ID             uuid.UUID
+	IsShadowbanned bool
+}
+
+func (q *Queries) SetUserShadowban(ctx context.Context, arg SetUserShadowbanParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, setUserShadowban, arg.ID, arg.IsShadowbanned)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.SuspendedAt,
+		&i.SuspensionReason,
+		&i.IsShadowbanned,
+		&i.Role,
+		&i.PasswordResetRequired,
+		&i.TokensValidAfter,
+		&i.EmailVerifiedAt,
+		&i.PendingEmail,
+		&i.PendingApproval,
+	)
+	return i, err
+}
+
+const suspendUser = `-- name: SuspendUser :one
+UPDATE users
+SET suspended_at = NOW(), suspension_reason = $2, updated_at = NOW()
+WHERE id = $1
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, suspended_at, suspension_reason, is_shadowbanned, role, password_reset_required, tokens_valid_after, email_verified_at, pending_email, pending_approval
+`
+
+type SuspendUserParams struct {
+	ID               uuid.UUID
+	SuspensionReason sql.NullString
+}
+
+func (q *Queries) SuspendUser(ctx context.Context, arg SuspendUserParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, suspendUser, arg.ID, arg.SuspensionReason)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.SuspendedAt,
+		&i.SuspensionReason,
+		&i.IsShadowbanned,
+		&i.Role,
+		&i.PasswordResetRequired,
+		&i.TokensValidAfter,
+		&i.EmailVerifiedAt,
+		&i.PendingEmail,
+		&i.PendingApproval,
+	)
+	return i, err
+}
+
+const unsuspendUser = `-- name: UnsuspendUser :one
+UPDATE users
+SET suspended_at = NULL, suspension_reason = NULL, updated_at = NOW()
+WHERE id = $1
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, suspended_at, suspension_reason, is_shadowbanned, role, password_reset_required, tokens_valid_after, email_verified_at, pending_email, pending_approval
+`
+
+func (q *Queries) UnsuspendUser(ctx context.Context, id uuid.UUID) (User, error) {
+	row := q.db.QueryRowContext(ctx, unsuspendUser, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.SuspendedAt,
+		&i.SuspensionReason,
+		&i.IsShadowbanned,
+		&i.Role,
+		&i.PasswordResetRequired,
+		&i.TokensValidAfter,
+		&i.EmailVerifiedAt,
+		&i.PendingEmail,
+		&i.PendingApproval,
 	)
 	return i, err
 }
 
 const updateUser = `-- name: UpdateUser :one
 UPDATE users
-SET email = $1, hashed_password = $2, updated_at = NOW()
+SET email = $1, hashed_password = $2, password_reset_required = false, updated_at = NOW()
 WHERE id = $3
-RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, suspended_at, suspension_reason, is_shadowbanned, role, password_reset_required, tokens_valid_after, email_verified_at, pending_email, pending_approval
 `
 
 type UpdateUserParams struct {
@@ -93,6 +647,15 @@ func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (User, e
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.SuspendedAt,
+		&i.SuspensionReason,
+		&i.IsShadowbanned,
+		&i.Role,
+		&i.PasswordResetRequired,
+		&i.TokensValidAfter,
+		&i.EmailVerifiedAt,
+		&i.PendingEmail,
+		&i.PendingApproval,
 	)
 	return i, err
 }
@@ -101,7 +664,7 @@ const upgradeUser = `-- name: UpgradeUser :one
 UPDATE users
 SET is_chirpy_red = true, updated_at = NOW()
 WHERE id = $1
-RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, suspended_at, suspension_reason, is_shadowbanned, role, password_reset_required, tokens_valid_after, email_verified_at, pending_email, pending_approval
 `
 
 func (q *Queries) UpgradeUser(ctx context.Context, id uuid.UUID) (User, error) {
@@ -114,6 +677,15 @@ func (q *Queries) UpgradeUser(ctx context.Context, id uuid.UUID) (User, error) {
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.SuspendedAt,
+		&i.SuspensionReason,
+		&i.IsShadowbanned,
+		&i.Role,
+		&i.PasswordResetRequired,
+		&i.TokensValidAfter,
+		&i.EmailVerifiedAt,
+		&i.PendingEmail,
+		&i.PendingApproval,
 	)
 	return i, err
 }
@@ -0,0 +1,243 @@
+// Package export writes the archive users download to take their Chirpy
+// data elsewhere, and reads it back to import it: a ZIP holding a JSON file
+// per kind of data, for programs, and an index.html showing all of it, for
+// people.
+package export
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"time"
+)
+
+const (
+	// Format and Version identify the archive layout in its manifest.
+	Format  string = "chirpy-export"
+	Version int    = 1
+
+	ManifestFile     string = "manifest.json"
+	ProfileFile      string = "profile.json"
+	ChirpsFile       string = "chirps.json"
+	SessionsFile     string = "sessions.json"
+	IdentitiesFile   string = "identities.json"
+	AccessTokensFile string = "access_tokens.json"
+	AppsFile         string = "apps.json"
+	SecurityLogFile  string = "security_log.json"
+	IndexFile        string = "index.html"
+)
+
+// Archive is everything Chirpy keeps about a user.
+type Archive struct {
+	Manifest     Manifest
+	Profile      Profile
+	Chirps       []Chirp
+	Sessions     []Session
+	Identities   []Identity
+	AccessTokens []AccessToken
+	Apps         []App
+	SecurityLog  []Event
+}
+
+type Manifest struct {
+	Format     string    `json:"format"`
+	Version    int       `json:"version"`
+	ExportedAt time.Time `json:"exported_at"`
+	UserID     string    `json:"user_id"`
+	Files      []string  `json:"files"`
+}
+
+type Profile struct {
+	ID            string    `json:"id"`
+	Email         string    `json:"email"`
+	EmailVerified bool      `json:"email_verified"`
+	IsChirpyRed   bool      `json:"is_chirpy_red"`
+	Role          string    `json:"role"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+type Chirp struct {
+	ID        string    `json:"id"`
+	Body      string    `json:"body"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Session is a device the user is signed in on.
+type Session struct {
+	Device     string    `json:"device"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	SignedInAt time.Time `json:"signed_in_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// Identity is an external identity provider account linked for sign-in.
+type Identity struct {
+	Provider string    `json:"provider"`
+	Subject  string    `json:"subject"`
+	Email    string    `json:"email"`
+	LinkedAt time.Time `json:"linked_at"`
+}
+
+// AccessToken describes a personal access token. The token itself is never
+// stored, so it cannot be exported.
+type AccessToken struct {
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// App is a third-party app the user has authorized.
+type App struct {
+	ClientID     string    `json:"client_id"`
+	Name         string    `json:"name"`
+	Scopes       []string  `json:"scopes"`
+	AuthorizedAt time.Time `json:"authorized_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// Event is an audit log entry the user took part in.
+type Event struct {
+	Action    string          `json:"action"`
+	CreatedAt time.Time       `json:"created_at"`
+	ActorID   string          `json:"actor_id,omitempty"`
+	TargetID  string          `json:"target_id,omitempty"`
+	IP        string          `json:"ip,omitempty"`
+	UserAgent string          `json:"user_agent,omitempty"`
+	Metadata  json.RawMessage `json:"metadata,omitempty"`
+}
+
+// Write writes the archive as a ZIP file. It fills in the manifest's format,
+// version and file list.
+func (a *Archive) Write(w io.Writer) error {
+	files := []struct {
+		name string
+		data any
+	}{
+		{ProfileFile, a.Profile},
+		{ChirpsFile, nonNil(a.Chirps)},
+		{SessionsFile, nonNil(a.Sessions)},
+		{IdentitiesFile, nonNil(a.Identities)},
+		{AccessTokensFile, nonNil(a.AccessTokens)},
+		{AppsFile, nonNil(a.Apps)},
+		{SecurityLogFile, nonNil(a.SecurityLog)},
+	}
+	a.Manifest.Format = Format
+	a.Manifest.Version = Version
+	a.Manifest.Files = []string{IndexFile}
+	for _, file := range files {
+		a.Manifest.Files = append(a.Manifest.Files, file.name)
+	}
+
+	archive := zip.NewWriter(w)
+	if err := writeJSON(archive, ManifestFile, a.Manifest); err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := writeJSON(archive, file.name, file.data); err != nil {
+			return err
+		}
+	}
+	index, err := archive.CreateHeader(&zip.FileHeader{Name: IndexFile, Method: zip.Deflate, Modified: a.Manifest.ExportedAt})
+	if err != nil {
+		return err
+	}
+	if err := indexTemplate.Execute(index, a); err != nil {
+		return fmt.Errorf("%s: %w", IndexFile, err)
+	}
+	return archive.Close()
+}
+
+func writeJSON(archive *zip.Writer, name string, v any) error {
+	file, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// nonNil makes empty sections [] rather than null.
+func nonNil[T any](items []T) []T {
+	if items == nil {
+		return []T{}
+	}
+	return items
+}
+
+var indexTemplate = template.Must(template.New(IndexFile).Funcs(template.FuncMap{
+	"date": func(t time.Time) string { return t.UTC().Format("2006-01-02 15:04 MST") },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Chirpy data export for {{.Profile.Email}}</title>
+<style>
+body { font-family: sans-serif; max-width: 60em; margin: 2em auto; padding: 0 1em; }
+table { border-collapse: collapse; width: 100%; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.5em; text-align: left; vertical-align: top; }
+</style>
+</head>
+<body>
+<h1>Chirpy data export</h1>
+<p>Exported {{date .Manifest.ExportedAt}}. The JSON files in this archive hold the same data.</p>
+
+<h2>Profile</h2>
+<table>
+<tr><th>ID</th><td>{{.Profile.ID}}</td></tr>
+<tr><th>Email</th><td>{{.Profile.Email}}{{if .Profile.EmailVerified}} (verified){{end}}</td></tr>
+<tr><th>Chirpy Red</th><td>{{if .Profile.IsChirpyRed}}Yes{{else}}No{{end}}</td></tr>
+<tr><th>Role</th><td>{{.Profile.Role}}</td></tr>
+<tr><th>Joined</th><td>{{date .Profile.CreatedAt}}</td></tr>
+</table>
+
+<h2>Chirps ({{len .Chirps}})</h2>
+<table>
+<tr><th>Posted</th><th>Chirp</th><th>Status</th></tr>
+{{range .Chirps}}<tr><td>{{date .CreatedAt}}</td><td>{{.Body}}</td><td>{{.Status}}</td></tr>
+{{end}}</table>
+
+<h2>Signed-in devices ({{len .Sessions}})</h2>
+<table>
+<tr><th>Device</th><th>IP address</th><th>Signed in</th><th>Last used</th></tr>
+{{range .Sessions}}<tr><td>{{.Device}}</td><td>{{.IP}}</td><td>{{date .SignedInAt}}</td><td>{{date .LastUsedAt}}</td></tr>
+{{end}}</table>
+
+<h2>Linked accounts ({{len .Identities}})</h2>
+<table>
+<tr><th>Provider</th><th>Email</th><th>Linked</th></tr>
+{{range .Identities}}<tr><td>{{.Provider}}</td><td>{{.Email}}</td><td>{{date .LinkedAt}}</td></tr>
+{{end}}</table>
+
+<h2>Personal access tokens ({{len .AccessTokens}})</h2>
+<table>
+<tr><th>Name</th><th>Scopes</th><th>Created</th></tr>
+{{range .AccessTokens}}<tr><td>{{.Name}}</td><td>{{range $i, $scope := .Scopes}}{{if $i}}, {{end}}{{$scope}}{{end}}</td><td>{{date .CreatedAt}}</td></tr>
+{{end}}</table>
+
+<h2>Authorized apps ({{len .Apps}})</h2>
+<table>
+<tr><th>App</th><th>Scopes</th><th>Authorized</th></tr>
+{{range .Apps}}<tr><td>{{.Name}}</td><td>{{range $i, $scope := .Scopes}}{{if $i}}, {{end}}{{$scope}}{{end}}</td><td>{{date .AuthorizedAt}}</td></tr>
+{{end}}</table>
+
+<h2>Security log ({{len .SecurityLog}})</h2>
+<table>
+<tr><th>When</th><th>Event</th><th>IP address</th></tr>
+{{range .SecurityLog}}<tr><td>{{date .CreatedAt}}</td><td>{{.Action}}</td><td>{{.IP}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
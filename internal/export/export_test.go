@@ -0,0 +1,153 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readFile(t *testing.T, archive *zip.Reader, name string) []byte {
+	t.Helper()
+	file, err := archive.Open(name)
+	if err != nil {
+		t.Fatalf("opening %s: %v", name, err)
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		t.Fatalf("reading %s: %v", name, err)
+	}
+	return data
+}
+
+func TestWrite(t *testing.T) {
+	exportedAt := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	archive := &Archive{
+		Manifest: Manifest{ExportedAt: exportedAt, UserID: "user-1"},
+		Profile:  Profile{ID: "user-1", Email: "ada@example.com", Role: "user", CreatedAt: exportedAt},
+		Chirps: []Chirp{
+			{ID: "chirp-1", Body: "<script>alert(1)</script>", Status: "published", CreatedAt: exportedAt},
+		},
+	}
+	var buf bytes.Buffer
+	if err := archive.Write(&buf); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	reader, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("reading zip: %v", err)
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(readFile(t, reader, ManifestFile), &manifest); err != nil {
+		t.Fatalf("decoding manifest: %v", err)
+	}
+	if manifest.Format != Format || manifest.Version != Version || !manifest.ExportedAt.Equal(exportedAt) {
+		t.Errorf("manifest = %+v", manifest)
+	}
+	for _, name := range manifest.Files {
+		readFile(t, reader, name)
+	}
+
+	var chirps []Chirp
+	if err := json.Unmarshal(readFile(t, reader, ChirpsFile), &chirps); err != nil {
+		t.Fatalf("decoding chirps: %v", err)
+	}
+	if len(chirps) != 1 || chirps[0].Body != archive.Chirps[0].Body {
+		t.Errorf("chirps = %+v", chirps)
+	}
+	if sessions := strings.TrimSpace(string(readFile(t, reader, SessionsFile))); sessions != "[]" {
+		t.Errorf("empty sessions written as %s, want []", sessions)
+	}
+
+	index := string(readFile(t, reader, IndexFile))
+	if strings.Contains(index, "<script>") {
+		t.Error("index.html does not escape chirp bodies")
+	}
+	if !strings.Contains(index, "ada@example.com") {
+		t.Error("index.html is missing the profile")
+	}
+}
+
+func zipOf(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+	for name, content := range files {
+		file, err := writer.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Write([]byte(content))
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestRead(t *testing.T) {
+	createdAt := time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+	archive := &Archive{
+		Manifest: Manifest{ExportedAt: createdAt, UserID: "user-1"},
+		Profile:  Profile{ID: "user-1", Email: "ada@example.com"},
+		Chirps:   []Chirp{{ID: "chirp-1", Body: "hello", Status: "published", CreatedAt: createdAt}},
+	}
+	var buf bytes.Buffer
+	if err := archive.Write(&buf); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	got, err := Read(bytes.NewReader(buf.Bytes()), int64(buf.Len()), 1<<20)
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if got.Profile != archive.Profile {
+		t.Errorf("profile = %+v, want %+v", got.Profile, archive.Profile)
+	}
+	if len(got.Chirps) != 1 || got.Chirps[0].Body != "hello" || !got.Chirps[0].CreatedAt.Equal(createdAt) {
+		t.Errorf("chirps = %+v", got.Chirps)
+	}
+
+	got, err = Read(bytes.NewReader(buf.Bytes()), int64(buf.Len()), 1<<20, ChirpsFile)
+	if err != nil {
+		t.Fatalf("Read(ChirpsFile) error = %v", err)
+	}
+	if got.Profile != (Profile{}) || len(got.Chirps) != 1 {
+		t.Errorf("Read(ChirpsFile) = profile %+v, chirps %+v, want only the chirps", got.Profile, got.Chirps)
+	}
+
+	// Each file fits on its own, but not together.
+	manifest := `{"format":"chirpy-export","version":1}`
+	padding := strings.Repeat(" ", 600)
+	big := zipOf(t, map[string]string{ManifestFile: manifest, ChirpsFile: "[]", ProfileFile: "{}" + padding, SessionsFile: "[]" + padding})
+	if _, err := Read(bytes.NewReader(big), int64(len(big)), 1000); err == nil || !strings.Contains(err.Error(), ErrTooLarge) {
+		t.Errorf("Read() error = %v, want it to mention %q", err, ErrTooLarge)
+	}
+	if _, err := Read(bytes.NewReader(big), int64(len(big)), 1000, ChirpsFile); err != nil {
+		t.Errorf("Read(ChirpsFile) error = %v, want the unread files not to count", err)
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr string
+	}{
+		{"not a zip", []byte("hello"), ErrNotAnArchive},
+		{"no manifest", zipOf(t, map[string]string{ChirpsFile: "[]"}), ErrNotAnArchive},
+		{"other format", zipOf(t, map[string]string{ManifestFile: `{"format":"tweets","version":1}`}), ErrNotAnArchive},
+		{"newer version", zipOf(t, map[string]string{ManifestFile: `{"format":"chirpy-export","version":2}`}), ErrUnsupportedVersion},
+		{"bad section", zipOf(t, map[string]string{ManifestFile: `{"format":"chirpy-export","version":1}`, ChirpsFile: "{"}), ChirpsFile},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Read(bytes.NewReader(tc.data), int64(len(tc.data)), 1<<20)
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("Read() error = %v, want it to mention %q", err, tc.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package export
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"slices"
+)
+
+const (
+	ErrNotAnArchive       string = "not a chirpy export archive"
+	ErrUnsupportedVersion string = "unsupported chirpy export version"
+	ErrTooLarge           string = "archive is too large once decompressed"
+)
+
+// Read reads an archive written by Write. Besides the manifest it reads only
+// the named files, or all of them when none are named. Files missing from it
+// leave their section empty, and files it does not know are ignored. At most
+// maxBytes are decompressed in all, so a small archive cannot expand into an
+// enormous one.
+func Read(r io.ReaderAt, size, maxBytes int64, files ...string) (*Archive, error) {
+	reader, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, errors.New(ErrNotAnArchive)
+	}
+	archive := &Archive{}
+	budget := maxBytes
+	found, err := readJSON(reader, ManifestFile, &archive.Manifest, &budget)
+	if err != nil {
+		return nil, err
+	}
+	if !found || archive.Manifest.Format != Format {
+		return nil, errors.New(ErrNotAnArchive)
+	}
+	if archive.Manifest.Version != Version {
+		return nil, fmt.Errorf("%s: %d", ErrUnsupportedVersion, archive.Manifest.Version)
+	}
+	sections := []struct {
+		name string
+		v    any
+	}{
+		{ProfileFile, &archive.Profile},
+		{ChirpsFile, &archive.Chirps},
+		{SessionsFile, &archive.Sessions},
+		{IdentitiesFile, &archive.Identities},
+		{AccessTokensFile, &archive.AccessTokens},
+		{AppsFile, &archive.Apps},
+		{SecurityLogFile, &archive.SecurityLog},
+	}
+	for _, section := range sections {
+		if len(files) > 0 && !slices.Contains(files, section.name) {
+			continue
+		}
+		if _, err := readJSON(reader, section.name, section.v, &budget); err != nil {
+			return nil, err
+		}
+	}
+	return archive, nil
+}
+
+// readJSON decodes the named file into v, reporting whether it exists. The
+// bytes it decompresses are taken off budget.
+func readJSON(reader *zip.Reader, name string, v any, budget *int64) (bool, error) {
+	file, err := reader.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", name, err)
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, *budget+1))
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", name, err)
+	}
+	if int64(len(data)) > *budget {
+		return false, fmt.Errorf("%s: %s", name, ErrTooLarge)
+	}
+	*budget -= int64(len(data))
+	if err := json.Unmarshal(data, v); err != nil {
+		return false, fmt.Errorf("%s: %w", name, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,42 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormat(t *testing.T) {
+	msg := Message{
+		To:      "walt@breakingbad.com\r\nBcc: jesse@breakingbad.com",
+		Subject: "Reset your password",
+		Body:    "line one\nline two",
+	}
+	data := string(format("Chirpy <no-reply@chirpy.dev>", msg, time.Unix(0, 0).UTC()))
+	if strings.Contains(data, "\r\nBcc:") {
+		t.Errorf("header injection was not stripped:\n%s", data)
+	}
+	for _, want := range []string{
+		"From: Chirpy <no-reply@chirpy.dev>\r\n",
+		"To: walt@breakingbad.comBcc: jesse@breakingbad.com\r\n",
+		"Subject: Reset your password\r\n",
+		"\r\n\r\nline one\r\nline two\r\n",
+	} {
+		if !strings.Contains(data, want) {
+			t.Errorf("expected message to contain %q, got:\n%s", want, data)
+		}
+	}
+}
+
+func TestLogMailer(t *testing.T) {
+	var buf bytes.Buffer
+	mailer := NewLogMailer("no-reply@chirpy.dev", &buf)
+	if err := mailer.Send(context.Background(), Message{To: "walt@breakingbad.com", Subject: "Hi", Body: "token: abc"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(buf.String(), "To: walt@breakingbad.com") || !strings.Contains(buf.String(), "token: abc") {
+		t.Errorf("unexpected log output:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,126 @@
+package oidc_test
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/charlesaraya/chirpy/internal/auth"
+	"github.com/charlesaraya/chirpy/internal/oidc"
+	"github.com/charlesaraya/chirpy/internal/oidc/oidctest"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const redirectURI string = "http://localhost:8080/api/login/oidc/test/callback"
+
+// authorize runs the browser's part of the flow against the mock provider
+// and returns the code it redirects back with.
+func authorize(t *testing.T, provider *oidc.Provider, state, nonce, verifier string) string {
+	t.Helper()
+	authURL, err := provider.AuthCodeURL(context.Background(), redirectURI, state, nonce, auth.PKCEChallenge(verifier))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	res, err := client.Get(authURL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	res.Body.Close()
+	location, err := url.Parse(res.Header.Get("Location"))
+	if err != nil || res.StatusCode != http.StatusFound {
+		t.Fatalf("expected a redirect, got %d %q", res.StatusCode, res.Header.Get("Location"))
+	}
+	if location.Query().Get("state") != state {
+		t.Fatalf("expected state %q, got %q", state, location.Query().Get("state"))
+	}
+	return location.Query().Get("code")
+}
+
+func TestProvider(t *testing.T) {
+	server, err := oidctest.NewServer()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer server.Close()
+	server.SetUser(oidctest.User{Subject: "user-1", Email: "ada@example.com", EmailVerified: true})
+	provider := oidc.NewProvider(oidc.Config{
+		Name:         "test",
+		Issuer:       server.Issuer(),
+		ClientID:     oidctest.ClientID,
+		ClientSecret: oidctest.ClientSecret,
+	}, nil)
+	verifier := strings.Repeat("v", 43)
+	ctx := context.Background()
+
+	t.Run("code flow", func(t *testing.T) {
+		code := authorize(t, provider, "state", "nonce", verifier)
+		claims, err := provider.Exchange(ctx, code, redirectURI, verifier, "nonce")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if claims.Subject != "user-1" || claims.Email != "ada@example.com" || !claims.EmailVerified {
+			t.Errorf("unexpected claims %+v", claims)
+		}
+	})
+	t.Run("nonce mismatch", func(t *testing.T) {
+		code := authorize(t, provider, "state", "nonce", verifier)
+		if _, err := provider.Exchange(ctx, code, redirectURI, verifier, "other"); err == nil {
+			t.Error("expected a nonce mismatch to be rejected")
+		}
+	})
+	t.Run("wrong verifier", func(t *testing.T) {
+		code := authorize(t, provider, "state", "nonce", verifier)
+		if _, err := provider.Exchange(ctx, code, redirectURI, strings.Repeat("w", 43), "nonce"); err == nil {
+			t.Error("expected a wrong code verifier to be rejected")
+		}
+	})
+
+	now := time.Now()
+	valid := jwt.MapClaims{
+		"iss":   server.Issuer(),
+		"aud":   oidctest.ClientID,
+		"sub":   "user-1",
+		"nonce": "nonce",
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}
+	tests := []struct {
+		name     string
+		override jwt.MapClaims
+	}{
+		{name: "wrong audience", override: jwt.MapClaims{"aud": "someone-else"}},
+		{name: "wrong issuer", override: jwt.MapClaims{"iss": "https://evil.example.com"}},
+		{name: "expired", override: jwt.MapClaims{"exp": now.Add(-time.Minute).Unix()}},
+		{name: "no subject", override: jwt.MapClaims{"sub": ""}},
+		{name: "other authorized party", override: jwt.MapClaims{"aud": []string{oidctest.ClientID, "other"}, "azp": "other"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			claims := jwt.MapClaims{}
+			for k, v := range valid {
+				claims[k] = v
+			}
+			for k, v := range tc.override {
+				claims[k] = v
+			}
+			idToken, err := server.SignIDToken(claims)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if _, err := provider.VerifyIDToken(ctx, idToken, "nonce"); err == nil {
+				t.Error("expected the id token to be rejected")
+			}
+		})
+	}
+	t.Run("unknown signing key", func(t *testing.T) {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, valid)
+		idToken, _ := token.SignedString([]byte("secret"))
+		if _, err := provider.VerifyIDToken(ctx, idToken, "nonce"); err == nil {
+			t.Error("expected an HS256 id token to be rejected")
+		}
+	})
+}
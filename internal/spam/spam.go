@@ -0,0 +1,143 @@
+package spam
+
+import (
+	"strings"
+	"time"
+)
+
+const (
+	ReasonDuplicateBody      string = "duplicate_body"
+	ReasonLinkDensity        string = "link_density"
+	ReasonVelocityMinute     string = "velocity_minute"
+	ReasonVelocityHour       string = "velocity_hour"
+	ReasonNewAccountThrottle string = "new_account_throttle"
+	ReasonNewAccountLinks    string = "new_account_links"
+	ReasonMentionFlooding    string = "mention_flooding"
+)
+
+// Config holds the thresholds used to score a chirp. A chirp whose score
+// reaches Threshold should be held for review instead of published.
+type Config struct {
+	Threshold                  float64
+	Lookback                   time.Duration
+	MaxChirpsPerMinute         int
+	MaxChirpsPerHour           int
+	NewAccountAge              time.Duration
+	NewAccountMaxChirpsPerHour int
+	MaxLinkRatio               float64
+	MaxMentions                int
+}
+
+// DefaultConfig returns the thresholds Chirpy uses unless configured
+// otherwise.
+func DefaultConfig() Config {
+	return Config{
+		Threshold:                  1.0,
+		Lookback:                   24 * time.Hour,
+		MaxChirpsPerMinute:         3,
+		MaxChirpsPerHour:           30,
+		NewAccountAge:              24 * time.Hour,
+		NewAccountMaxChirpsPerHour: 5,
+		MaxLinkRatio:               0.3,
+		MaxMentions:                5,
+	}
+}
+
+// Chirp is a previously posted chirp by the same author.
+type Chirp struct {
+	Body      string
+	CreatedAt time.Time
+}
+
+// Signals is everything the scorer knows about a new chirp. RecentChirps
+// should cover at least Config.Lookback.
+type Signals struct {
+	Body           string
+	AccountCreated time.Time
+	RecentChirps   []Chirp
+	Now            time.Time
+}
+
+// Result is a chirp's spam score along with the reasons that added to it.
+type Result struct {
+	Score   float64
+	Reasons []string
+}
+
+func (r *Result) add(weight float64, reason string) {
+	r.Score += weight
+	r.Reasons = append(r.Reasons, reason)
+}
+
+// IsSpam reports whether the score reaches cfg's threshold, meaning the
+// chirp should be held for review.
+func (r Result) IsSpam(cfg Config) bool {
+	return r.Score >= cfg.Threshold
+}
+
+// Score weighs a new chirp's signals against cfg. Each rule the chirp breaks
+// adds to the score and names itself in the reasons.
+func Score(cfg Config, s Signals) Result {
+	result := Result{Reasons: []string{}}
+	words := strings.Fields(s.Body)
+
+	normalized := normalize(s.Body)
+	var lastMinute, lastHour int
+	for _, chirp := range s.RecentChirps {
+		age := s.Now.Sub(chirp.CreatedAt)
+		if age > cfg.Lookback {
+			continue
+		}
+		if age <= time.Minute {
+			lastMinute++
+		}
+		if age <= time.Hour {
+			lastHour++
+		}
+	}
+	for _, chirp := range s.RecentChirps {
+		if s.Now.Sub(chirp.CreatedAt) <= cfg.Lookback && normalize(chirp.Body) == normalized {
+			result.add(1.0, ReasonDuplicateBody)
+			break
+		}
+	}
+
+	var links, mentions int
+	for _, word := range words {
+		lower := strings.ToLower(word)
+		if strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://") || strings.HasPrefix(lower, "www.") {
+			links++
+		}
+		if len(word) > 1 && strings.HasPrefix(word, "@") {
+			mentions++
+		}
+	}
+	if len(words) > 0 && float64(links)/float64(len(words)) > cfg.MaxLinkRatio {
+		result.add(0.6, ReasonLinkDensity)
+	}
+
+	if lastMinute >= cfg.MaxChirpsPerMinute {
+		result.add(0.6, ReasonVelocityMinute)
+	}
+	if lastHour >= cfg.MaxChirpsPerHour {
+		result.add(0.5, ReasonVelocityHour)
+	}
+
+	if s.Now.Sub(s.AccountCreated) < cfg.NewAccountAge {
+		if lastHour >= cfg.NewAccountMaxChirpsPerHour {
+			result.add(0.5, ReasonNewAccountThrottle)
+		}
+		if links > 0 {
+			result.add(0.3, ReasonNewAccountLinks)
+		}
+	}
+
+	if mentions > cfg.MaxMentions {
+		result.add(0.6, ReasonMentionFlooding)
+	}
+	return result
+}
+
+func normalize(body string) string {
+	return strings.Join(strings.Fields(strings.ToLower(body)), " ")
+}
@@ -0,0 +1,100 @@
+package spam
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScore(t *testing.T) {
+	cfg := DefaultConfig()
+	now := time.Now()
+	oldAccount := now.Add(-30 * 24 * time.Hour)
+
+	tests := []struct {
+		name       string
+		signals    Signals
+		wantSpam   bool
+		wantReason string
+	}{
+		{
+			name: "ordinary chirp",
+			signals: Signals{
+				Body:           "Just had the best coffee in town",
+				AccountCreated: oldAccount,
+				Now:            now,
+			},
+			wantSpam: false,
+		},
+		{
+			name: "duplicate body",
+			signals: Signals{
+				Body:           "Buy   my Stuff",
+				AccountCreated: oldAccount,
+				RecentChirps:   []Chirp{{Body: "buy my stuff", CreatedAt: now.Add(-2 * time.Hour)}},
+				Now:            now,
+			},
+			wantSpam:   true,
+			wantReason: ReasonDuplicateBody,
+		},
+		{
+			name: "duplicate outside lookback",
+			signals: Signals{
+				Body:           "good morning",
+				AccountCreated: oldAccount,
+				RecentChirps:   []Chirp{{Body: "good morning", CreatedAt: now.Add(-48 * time.Hour)}},
+				Now:            now,
+			},
+			wantSpam: false,
+		},
+		{
+			name: "links on a new account",
+			signals: Signals{
+				Body:           "https://spam.example https://spam.example/2 deals",
+				AccountCreated: now.Add(-time.Hour),
+				Now:            now,
+			},
+			wantSpam:   false,
+			wantReason: ReasonLinkDensity,
+		},
+		{
+			name: "posting velocity",
+			signals: Signals{
+				Body:           "one more thing",
+				AccountCreated: now.Add(-time.Hour),
+				RecentChirps: []Chirp{
+					{Body: "a", CreatedAt: now.Add(-10 * time.Second)},
+					{Body: "b", CreatedAt: now.Add(-20 * time.Second)},
+					{Body: "c", CreatedAt: now.Add(-30 * time.Second)},
+					{Body: "d", CreatedAt: now.Add(-10 * time.Minute)},
+					{Body: "e", CreatedAt: now.Add(-20 * time.Minute)},
+				},
+				Now: now,
+			},
+			wantSpam:   true,
+			wantReason: ReasonVelocityMinute,
+		},
+		{
+			name: "mention flooding",
+			signals: Signals{
+				Body:           strings.Repeat("@someone ", 7) + "check this",
+				AccountCreated: oldAccount,
+				Now:            now,
+			},
+			wantSpam:   false,
+			wantReason: ReasonMentionFlooding,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			result := Score(cfg, tc.signals)
+			if result.IsSpam(cfg) != tc.wantSpam {
+				t.Errorf("expected spam %v, got score %.2f with reasons %v", tc.wantSpam, result.Score, result.Reasons)
+			}
+			if tc.wantReason != "" && !slices.Contains(result.Reasons, tc.wantReason) {
+				t.Errorf("expected reason %q, got %v", tc.wantReason, result.Reasons)
+			}
+		})
+	}
+}
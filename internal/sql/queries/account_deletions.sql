@@ -0,0 +1,31 @@
+-- name: ScheduleAccountDeletion :one
+INSERT INTO account_deletions (user_id, requested_at, erase_after)
+VALUES (
+    $1,
+    NOW(),
+    $2
+)
+ON CONFLICT (user_id) DO UPDATE
+SET user_id = account_deletions.user_id
+RETURNING *;
+
+-- name: GetAccountDeletion :one
+SELECT * FROM account_deletions
+WHERE user_id = $1;
+
+-- name: CancelAccountDeletion :execrows
+DELETE FROM account_deletions
+WHERE user_id = $1;
+
+-- name: GetDueAccountDeletions :many
+SELECT * FROM account_deletions
+WHERE erase_after <= NOW()
+    AND (retry_after IS NULL OR retry_after <= NOW())
+ORDER BY erase_after
+LIMIT $1;
+
+-- name: RecordAccountDeletionFailure :exec
+UPDATE account_deletions
+SET attempts = attempts + 1,
+    retry_after = sqlc.arg(retry_after)
+WHERE user_id = sqlc.arg(user_id);
@@ -0,0 +1,51 @@
+-- name: CreateAccountExport :one
+INSERT INTO account_exports (id, user_id, status, created_at)
+VALUES (
+    gen_random_uuid (),
+    $1,
+    'pending',
+    NOW()
+)
+RETURNING *;
+
+-- name: GetAccountExport :one
+SELECT * FROM account_exports
+WHERE id = $1 AND user_id = $2;
+
+-- name: GetActiveAccountExport :one
+SELECT * FROM account_exports
+WHERE user_id = $1 AND status IN ('pending', 'building')
+ORDER BY created_at DESC
+LIMIT 1;
+
+-- name: ClaimAccountExport :one
+UPDATE account_exports
+SET status = 'building', started_at = NOW()
+WHERE id = (
+    SELECT id FROM account_exports
+    WHERE status = 'pending' OR (status = 'building' AND started_at < sqlc.arg(stale_before)::timestamp)
+    ORDER BY created_at
+    LIMIT 1
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
+
+-- name: SaveAccountExportArchive :exec
+INSERT INTO account_export_archives (export_id, data)
+VALUES ($1, $2)
+ON CONFLICT (export_id) DO UPDATE
+SET data = EXCLUDED.data;
+
+-- name: FinishAccountExport :exec
+UPDATE account_exports
+SET status = $2, completed_at = NOW(), expires_at = $3
+WHERE id = $1;
+
+-- name: GetAccountExportArchive :one
+SELECT account_export_archives.data FROM account_export_archives
+JOIN account_exports ON account_exports.id = account_export_archives.export_id
+WHERE account_exports.id = $1 AND account_exports.status = 'ready' AND account_exports.expires_at > NOW();
+
+-- name: DeleteExpiredAccountExports :exec
+DELETE FROM account_exports
+WHERE expires_at <= NOW();
@@ -0,0 +1,35 @@
+-- name: CreateAuditEvent :exec
+INSERT INTO audit_events (id, created_at, action, actor_id, target_id, ip, user_agent, metadata)
+VALUES (
+    gen_random_uuid (),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6
+);
+
+-- name: GetAuditEvents :many
+SELECT * FROM audit_events
+WHERE (sqlc.narg(action)::text IS NULL OR action = sqlc.narg(action))
+AND (sqlc.narg(actor_id)::uuid IS NULL OR actor_id = sqlc.narg(actor_id))
+AND (sqlc.narg(target_id)::uuid IS NULL OR target_id = sqlc.narg(target_id))
+AND (sqlc.narg(since)::timestamp IS NULL OR created_at >= sqlc.narg(since))
+AND (sqlc.narg(until)::timestamp IS NULL OR created_at < sqlc.narg(until))
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg(page_limit) OFFSET sqlc.arg(page_offset);
+
+-- name: RedactUserAuditEvents :execrows
+-- set_config turns redaction on for this statement's transaction only. It
+-- sits in the WHERE clause so it has run before any row is updated.
+UPDATE audit_events
+SET ip = '', user_agent = '', metadata = '{}'
+WHERE (actor_id = sqlc.arg(user_id)::uuid OR target_id = sqlc.arg(user_id)::uuid)
+AND set_config('chirpy.audit_redaction', 'on', true) = 'on';
+
+-- name: GetUserAuditEvents :many
+SELECT * FROM audit_events
+WHERE actor_id = sqlc.arg(user_id)::uuid OR target_id = sqlc.arg(user_id)::uuid
+ORDER BY created_at, id;
@@ -0,0 +1,9 @@
+-- name: CreateChirpSpamScore :one
+INSERT INTO chirp_spam_scores (chirp_id, score, reasons, created_at)
+VALUES (
+    $1,
+    $2,
+    $3,
+    NOW()
+)
+RETURNING *;
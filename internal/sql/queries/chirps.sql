@@ -1,11 +1,12 @@
 -- name: CreateChirp :one
-INSERT INTO chirps (id, user_id, created_at, updated_at, body)
+INSERT INTO chirps (id, user_id, created_at, updated_at, body, status)
 VALUES (
     gen_random_uuid (),
     $1,
     NOW(),
     NOW(),
-    $2
+    $2,
+    $3
 )
 RETURNING *;
 
@@ -22,6 +23,47 @@ SELECT * FROM chirps
 WHERE user_id = $1
 ORDER BY created_at;
 
+-- name: GetVisibleChirp :one
+SELECT chirps.* FROM chirps
+JOIN users ON users.id = chirps.user_id
+WHERE chirps.id = sqlc.arg(id)
+AND ((chirps.status = 'published' AND users.is_shadowbanned = false) OR chirps.user_id = sqlc.arg(viewer_id));
+
+-- name: GetVisibleChirps :many
+SELECT chirps.* FROM chirps
+JOIN users ON users.id = chirps.user_id
+WHERE (chirps.status = 'published' AND users.is_shadowbanned = false) OR chirps.user_id = sqlc.arg(viewer_id)
+ORDER BY chirps.created_at;
+
+-- name: GetVisibleChirpsFromUser :many
+SELECT chirps.* FROM chirps
+JOIN users ON users.id = chirps.user_id
+WHERE chirps.user_id = sqlc.arg(user_id)
+AND ((chirps.status = 'published' AND users.is_shadowbanned = false) OR chirps.user_id = sqlc.arg(viewer_id))
+ORDER BY chirps.created_at;
+
+-- name: GetRecentChirpsFromUser :many
+SELECT * FROM chirps
+WHERE user_id = $1 AND created_at > $2
+ORDER BY created_at DESC;
+
+-- name: GetHeldChirps :many
+-- Held chirps without a spam score are listed too, so none are stuck out of
+-- a moderator's sight.
+SELECT chirps.*,
+    COALESCE(chirp_spam_scores.score, 0)::float8 AS score,
+    COALESCE(chirp_spam_scores.reasons, '{}')::text[] AS reasons
+FROM chirps
+LEFT JOIN chirp_spam_scores ON chirp_spam_scores.chirp_id = chirps.id
+WHERE chirps.status = 'held'
+ORDER BY chirps.created_at;
+
+-- name: SetChirpStatus :one
+UPDATE chirps
+SET status = $2, updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
 -- name: DeleteChirp :execresult
 DELETE FROM chirps
 WHERE id = $1 AND user_id = $2
@@ -29,3 +71,20 @@ RETURNING id, user_id;
 
 -- name: DeleteChirps :exec
 TRUNCATE TABLE chirps;
+
+-- name: ReassignUserChirps :execrows
+UPDATE chirps
+SET user_id = sqlc.arg(to_user_id)
+WHERE user_id = sqlc.arg(from_user_id);
+
+-- name: ImportChirp :execrows
+INSERT INTO chirps (id, user_id, created_at, updated_at, body, status)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6
+)
+ON CONFLICT (id) DO NOTHING;
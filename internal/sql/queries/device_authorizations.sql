@@ -0,0 +1,43 @@
+-- name: CreateDeviceAuthorization :one
+INSERT INTO device_authorizations (id, device_code_hash, user_code, user_agent, ip, status, user_id, interval_seconds, last_polled_at, created_at, expires_at)
+VALUES (
+    gen_random_uuid (),
+    $1,
+    $2,
+    $3,
+    $4,
+    'pending',
+    NULL,
+    $5,
+    NULL,
+    NOW(),
+    $6
+)
+RETURNING *;
+
+-- name: GetDeviceAuthorizationByDeviceCode :one
+SELECT * FROM device_authorizations
+WHERE device_code_hash = $1;
+
+-- name: GetDeviceAuthorizationByUserCode :one
+SELECT * FROM device_authorizations
+WHERE user_code = $1 AND status = 'pending' AND expires_at > NOW();
+
+-- name: DecideDeviceAuthorization :execrows
+UPDATE device_authorizations
+SET status = $2, user_id = $3
+WHERE id = $1 AND status = 'pending' AND expires_at > NOW();
+
+-- name: PollDeviceAuthorization :exec
+UPDATE device_authorizations
+SET last_polled_at = NOW(), interval_seconds = $2
+WHERE id = $1;
+
+-- name: UseDeviceAuthorization :execrows
+UPDATE device_authorizations
+SET status = 'used'
+WHERE id = $1 AND status = 'approved' AND expires_at > NOW();
+
+-- name: DeleteExpiredDeviceAuthorizations :exec
+DELETE FROM device_authorizations
+WHERE expires_at <= NOW();
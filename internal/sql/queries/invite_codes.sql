@@ -0,0 +1,46 @@
+-- name: CreateInviteCode :one
+INSERT INTO invite_codes (id, created_by, code_hash, max_uses, created_at, expires_at)
+VALUES (
+    gen_random_uuid (),
+    $1,
+    $2,
+    $3,
+    NOW(),
+    $4
+)
+RETURNING *;
+
+-- name: GetInviteCodes :many
+SELECT * FROM invite_codes
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg(page_limit) OFFSET sqlc.arg(page_offset);
+
+-- name: GetUserInviteCodes :many
+SELECT * FROM invite_codes
+WHERE created_by = $1
+ORDER BY created_at DESC;
+
+-- name: CountActiveUserInviteCodes :one
+SELECT COUNT(*) FROM invite_codes
+WHERE created_by = $1 AND uses < max_uses AND (expires_at IS NULL OR expires_at > NOW());
+
+-- name: UseInviteCode :one
+-- Checking a code is still good and counting the use happen in one
+-- statement, so a code cannot be used more times than it allows.
+UPDATE invite_codes
+SET uses = uses + 1
+WHERE code_hash = $1 AND uses < max_uses AND (expires_at IS NULL OR expires_at > NOW())
+RETURNING *;
+
+-- name: ReleaseInviteCode :exec
+UPDATE invite_codes
+SET uses = uses - 1
+WHERE id = $1 AND uses > 0;
+
+-- name: DeleteInviteCode :execrows
+DELETE FROM invite_codes
+WHERE id = $1;
+
+-- name: DeleteUserInviteCode :execrows
+DELETE FROM invite_codes
+WHERE id = $1 AND created_by = $2;
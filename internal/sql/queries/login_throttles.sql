@@ -0,0 +1,30 @@
+-- name: GetLoginThrottles :many
+SELECT * FROM login_throttles
+WHERE key = ANY(sqlc.arg(keys)::text[]);
+
+-- name: RecordLoginFailure :one
+INSERT INTO login_throttles (key, failed_attempts, last_failed_at)
+VALUES (sqlc.arg(key), 1, NOW())
+ON CONFLICT (key) DO UPDATE
+SET failed_attempts = CASE
+        WHEN login_throttles.last_failed_at < sqlc.arg(reset_before) THEN 1
+        ELSE login_throttles.failed_attempts + 1
+    END,
+    last_failed_at = NOW()
+RETURNING *;
+
+-- name: LockLogin :exec
+UPDATE login_throttles
+SET locked_until = $2
+WHERE key = $1;
+
+-- name: ClearLoginThrottle :execrows
+DELETE FROM login_throttles
+WHERE key = $1;
+
+-- name: GetLockedAccounts :many
+SELECT login_throttles.key, login_throttles.failed_attempts, login_throttles.last_failed_at, login_throttles.locked_until, users.id AS user_id
+FROM login_throttles
+LEFT JOIN users ON login_throttles.key = 'email:' || LOWER(users.email)
+WHERE login_throttles.key LIKE 'email:%' AND login_throttles.locked_until > NOW()
+ORDER BY login_throttles.locked_until DESC;
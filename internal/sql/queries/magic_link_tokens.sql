@@ -0,0 +1,25 @@
+-- name: CreateMagicLinkToken :exec
+INSERT INTO magic_link_tokens (id, user_id, token_hash, nonce_hash, created_at, expires_at, used_at)
+VALUES (
+    gen_random_uuid (),
+    $1,
+    $2,
+    $3,
+    NOW(),
+    $4,
+    NULL
+);
+
+-- name: GetMagicLinkToken :one
+SELECT * FROM magic_link_tokens
+WHERE token_hash = $1;
+
+-- name: UseMagicLinkToken :execrows
+UPDATE magic_link_tokens
+SET used_at = NOW()
+WHERE id = $1 AND used_at IS NULL AND expires_at > NOW();
+
+-- name: ExpireUserMagicLinkTokens :exec
+UPDATE magic_link_tokens
+SET used_at = NOW()
+WHERE user_id = $1 AND used_at IS NULL;
@@ -0,0 +1,29 @@
+-- name: CreateRecoveryCode :exec
+INSERT INTO mfa_recovery_codes (id, user_id, code_hash, created_at, used_at)
+VALUES (
+    gen_random_uuid (),
+    $1,
+    $2,
+    NOW(),
+    NULL
+);
+
+-- name: GetUnusedLegacyRecoveryCodes :many
+-- Codes issued before recovery codes were hashed with SHA-256 carry a
+-- password hash, which starts with '$'.
+SELECT * FROM mfa_recovery_codes
+WHERE user_id = $1 AND used_at IS NULL AND code_hash LIKE '$%';
+
+-- name: UseRecoveryCode :execrows
+UPDATE mfa_recovery_codes
+SET used_at = NOW()
+WHERE id = $1 AND used_at IS NULL;
+
+-- name: UseRecoveryCodeByHash :execrows
+UPDATE mfa_recovery_codes
+SET used_at = NOW()
+WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL;
+
+-- name: DeleteRecoveryCodes :exec
+DELETE FROM mfa_recovery_codes
+WHERE user_id = $1;
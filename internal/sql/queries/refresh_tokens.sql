@@ -1,23 +1,67 @@
 -- name: CreateRefreshToken :one
-INSERT INTO refresh_tokens (token, user_id, created_at, updated_at, expires_at, revoked_at)
+INSERT INTO refresh_tokens (id, token_hash, family_id, user_id, created_at, updated_at, expires_at, revoked_at, user_agent, ip, signed_in_at, last_used_at, access_jti, access_expires_at)
 VALUES (
+    gen_random_uuid (),
     $1,
     $2,
+    $3,
     NOW(),
     NOW(),
-    $3,
-    NULL
+    $4,
+    NULL,
+    $5,
+    $6,
+    $7,
+    NOW(),
+    $8,
+    $9
 )
 RETURNING *;
 
 -- name: GetRefreshToken :one
 SELECT * FROM refresh_tokens
-WHERE token = $1;
+WHERE token_hash = $1;
+
+-- name: RotateRefreshToken :execrows
+UPDATE refresh_tokens
+SET rotated_at = NOW(), updated_at = NOW()
+WHERE id = $1 AND rotated_at IS NULL AND revoked_at IS NULL;
 
 -- name: RevokeRefreshToken :exec
 UPDATE refresh_tokens
 SET revoked_at = NOW(), updated_at = NOW()
-WHERE token = $1;
+WHERE token_hash = $1;
+
+-- name: RevokeRefreshTokenFamily :exec
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE family_id = $1 AND revoked_at IS NULL;
 
 -- name: DeleteTokens :exec
 DELETE FROM refresh_tokens;
+
+-- name: RevokeUserRefreshTokens :exec
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE user_id = $1 AND revoked_at IS NULL;
+
+-- name: GetUserRefreshTokens :many
+SELECT * FROM refresh_tokens
+WHERE user_id = $1
+ORDER BY created_at DESC;
+
+-- name: GetUserSessions :many
+SELECT * FROM refresh_tokens
+WHERE user_id = $1 AND rotated_at IS NULL AND revoked_at IS NULL AND expires_at > NOW()
+ORDER BY last_used_at DESC;
+
+-- name: RevokeUserSession :execrows
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE user_id = $1 AND family_id = $2 AND revoked_at IS NULL;
+
+-- name: GetSessionAccessTokens :many
+-- Access tokens issued alongside a session's refresh tokens that have not
+-- expired yet.
+SELECT access_jti, access_expires_at FROM refresh_tokens
+WHERE user_id = $1 AND family_id = $2 AND access_jti <> '' AND access_expires_at > NOW();
@@ -0,0 +1,18 @@
+-- name: RevokeAccessToken :exec
+INSERT INTO revoked_access_tokens (jti, user_id, expires_at, revoked_at)
+VALUES (
+    $1,
+    $2,
+    $3,
+    NOW()
+)
+ON CONFLICT (jti) DO NOTHING;
+
+-- name: GetRevokedAccessTokensSince :many
+SELECT * FROM revoked_access_tokens
+WHERE revoked_at > $1 AND expires_at > NOW()
+ORDER BY revoked_at ASC;
+
+-- name: DeleteExpiredRevokedAccessTokens :exec
+DELETE FROM revoked_access_tokens
+WHERE expires_at <= NOW();
@@ -1,11 +1,12 @@
 -- name: CreateUser :one
-INSERT INTO users (id, created_at, updated_at, email, hashed_password)
+INSERT INTO users (id, created_at, updated_at, email, hashed_password, pending_approval)
 VALUES (
     gen_random_uuid (),
     NOW(),
     NOW(),
     $1,
-    $2
+    $2,
+    $3
 )
 RETURNING *;
 
@@ -15,7 +16,7 @@ WHERE email = $1;
 
 -- name: UpdateUser :one
 UPDATE users
-SET email = $1, hashed_password = $2, updated_at = NOW()
+SET email = $1, hashed_password = $2, password_reset_required = false, updated_at = NOW()
 WHERE id = $3
 RETURNING *;
 
@@ -27,3 +28,104 @@ RETURNING *;
 
 -- name: DeleteUsers :exec
 DELETE FROM users;
+
+-- name: GetUserByID :one
+SELECT * FROM users
+WHERE id = $1;
+
+-- name: SuspendUser :one
+UPDATE users
+SET suspended_at = NOW(), suspension_reason = $2, updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: UnsuspendUser :one
+UPDATE users
+SET suspended_at = NULL, suspension_reason = NULL, updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: SetUserShadowban :one
+UPDATE users
+SET is_shadowbanned = $2, updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: SetUserRole :one
+UPDATE users
+SET role = $2, updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: SearchUsers :many
+SELECT * FROM users
+WHERE (sqlc.narg(email)::text IS NULL OR email ILIKE sqlc.narg(email))
+AND (sqlc.narg(created_after)::timestamp IS NULL OR created_at >= sqlc.narg(created_after))
+AND (sqlc.narg(created_before)::timestamp IS NULL OR created_at < sqlc.narg(created_before))
+AND (sqlc.narg(is_chirpy_red)::boolean IS NULL OR is_chirpy_red = sqlc.narg(is_chirpy_red))
+ORDER BY created_at, id
+LIMIT sqlc.arg(page_limit) OFFSET sqlc.arg(page_offset);
+
+-- name: SetChirpyRed :one
+UPDATE users
+SET is_chirpy_red = $2, updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: RequirePasswordReset :one
+UPDATE users
+SET password_reset_required = true, updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: DeleteUser :execrows
+DELETE FROM users
+WHERE id = $1;
+
+-- name: RevokeUserAccessTokens :exec
+UPDATE users
+SET tokens_valid_after = NOW()
+WHERE id = $1;
+
+-- name: SetUserPassword :exec
+UPDATE users
+SET hashed_password = $2, password_reset_required = false, updated_at = NOW()
+WHERE id = $1;
+
+-- name: RehashUserPassword :execrows
+UPDATE users
+SET hashed_password = $3
+WHERE id = $1 AND hashed_password = $2;
+
+-- name: SetPendingEmail :exec
+UPDATE users
+SET pending_email = $2, updated_at = NOW()
+WHERE id = $1;
+
+-- name: MarkEmailVerified :one
+UPDATE users
+SET email_verified_at = NOW(), updated_at = NOW()
+WHERE id = $1 AND email = $2
+RETURNING *;
+
+-- name: ConfirmEmailChange :one
+UPDATE users
+SET email = $2, pending_email = NULL, email_verified_at = NOW(), updated_at = NOW()
+WHERE id = $1 AND pending_email = $2
+RETURNING *;
+
+-- name: GetPendingUsers :many
+SELECT * FROM users
+WHERE pending_approval
+ORDER BY created_at, id
+LIMIT sqlc.arg(page_limit) OFFSET sqlc.arg(page_offset);
+
+-- name: ApproveUser :one
+UPDATE users
+SET pending_approval = false, updated_at = NOW()
+WHERE id = $1 AND pending_approval
+RETURNING *;
+
+-- name: DeletePendingUser :execrows
+DELETE FROM users
+WHERE id = $1 AND pending_approval;
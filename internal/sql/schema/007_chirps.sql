@@ -0,0 +1,15 @@
+-- +goose Up
+ALTER TABLE chirps ADD COLUMN status TEXT NOT NULL DEFAULT 'published'
+    CHECK (status IN ('published', 'held', 'rejected'));
+
+CREATE TABLE chirp_spam_scores(
+    chirp_id UUID PRIMARY KEY,
+    score DOUBLE PRECISION NOT NULL,
+    reasons TEXT[] NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    FOREIGN KEY (chirp_id) REFERENCES chirps(id) ON DELETE CASCADE
+);
+
+-- +goose Down
+DROP TABLE chirp_spam_scores;
+ALTER TABLE chirps DROP COLUMN status;
@@ -0,0 +1,7 @@
+-- +goose Up
+-- Access tokens issued before this time are rejected, which signs a user out
+-- everywhere without tracking every token handed out.
+ALTER TABLE users ADD COLUMN tokens_valid_after TIMESTAMP;
+
+-- +goose Down
+ALTER TABLE users DROP COLUMN tokens_valid_after;
@@ -0,0 +1,11 @@
+-- +goose Up
+CREATE TABLE revoked_access_tokens (
+    jti TEXT PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    expires_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX revoked_access_tokens_revoked_at_idx ON revoked_access_tokens (revoked_at);
+
+-- +goose Down
+DROP TABLE revoked_access_tokens;
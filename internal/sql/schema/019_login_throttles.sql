@@ -0,0 +1,11 @@
+-- +goose Up
+CREATE TABLE login_throttles (
+    key TEXT PRIMARY KEY,
+    failed_attempts INTEGER NOT NULL,
+    last_failed_at TIMESTAMP NOT NULL,
+    locked_until TIMESTAMP
+);
+CREATE INDEX login_throttles_locked_until_idx ON login_throttles (locked_until);
+
+-- +goose Down
+DROP TABLE login_throttles;
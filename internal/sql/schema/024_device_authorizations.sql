@@ -0,0 +1,18 @@
+-- +goose Up
+CREATE TABLE device_authorizations (
+    id UUID PRIMARY KEY,
+    device_code_hash TEXT NOT NULL UNIQUE,
+    user_code TEXT NOT NULL UNIQUE,
+    -- The device that asked, shown to the user before they approve it.
+    user_agent TEXT NOT NULL,
+    ip TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending',
+    user_id UUID REFERENCES users (id) ON DELETE CASCADE,
+    interval_seconds INTEGER NOT NULL,
+    last_polled_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
+
+-- +goose Down
+DROP TABLE device_authorizations;
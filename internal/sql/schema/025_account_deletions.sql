@@ -0,0 +1,41 @@
+-- +goose Up
+CREATE TABLE account_deletions (
+    user_id UUID PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    requested_at TIMESTAMP NOT NULL,
+    -- Until then the user can sign in and cancel.
+    erase_after TIMESTAMP NOT NULL
+);
+CREATE INDEX account_deletions_erase_after_idx ON account_deletions (erase_after);
+
+-- Erasing an account redacts the personal data in its audit events. The
+-- log stays append-only otherwise: only a transaction that has turned on
+-- chirpy.audit_redaction may update a row, and only the columns holding
+-- personal data.
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION reject_audit_event_changes() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'UPDATE'
+        AND current_setting('chirpy.audit_redaction', true) = 'on'
+        AND NEW.id = OLD.id
+        AND NEW.created_at = OLD.created_at
+        AND NEW.action = OLD.action
+        AND NEW.actor_id IS NOT DISTINCT FROM OLD.actor_id
+        AND NEW.target_id IS NOT DISTINCT FROM OLD.target_id
+    THEN
+        RETURN NEW;
+    END IF;
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION reject_audit_event_changes() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+DROP TABLE account_deletions;
@@ -0,0 +1,24 @@
+-- +goose Up
+CREATE TABLE account_exports (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    -- pending, building, ready or failed.
+    status TEXT NOT NULL DEFAULT 'pending',
+    created_at TIMESTAMP NOT NULL,
+    started_at TIMESTAMP,
+    completed_at TIMESTAMP,
+    -- When the archive is deleted.
+    expires_at TIMESTAMP
+);
+CREATE INDEX account_exports_user_id_idx ON account_exports (user_id);
+
+-- Archives live apart from their exports so that listing exports does not
+-- read them.
+CREATE TABLE account_export_archives (
+    export_id UUID PRIMARY KEY REFERENCES account_exports (id) ON DELETE CASCADE,
+    data BYTEA NOT NULL
+);
+
+-- +goose Down
+DROP TABLE account_export_archives;
+DROP TABLE account_exports;
@@ -0,0 +1,19 @@
+-- +goose Up
+ALTER TABLE users ADD COLUMN pending_approval BOOLEAN NOT NULL DEFAULT false;
+CREATE INDEX users_pending_approval_idx ON users (created_at) WHERE pending_approval;
+
+CREATE TABLE invite_codes (
+    id UUID PRIMARY KEY,
+    created_by UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    code_hash TEXT NOT NULL UNIQUE,
+    max_uses INTEGER NOT NULL CHECK (max_uses > 0),
+    uses INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP
+);
+CREATE INDEX invite_codes_created_by_idx ON invite_codes (created_by);
+
+-- +goose Down
+DROP TABLE invite_codes;
+DROP INDEX users_pending_approval_idx;
+ALTER TABLE users DROP COLUMN pending_approval;
@@ -0,0 +1,34 @@
+-- +goose Up
+-- Anonymized chirps belong to a placeholder account with a fixed ID. It used
+-- to be created on first use and found by email, so anyone who signed up
+-- with its address first would have received them. Whichever accounts hold
+-- that address are moved aside; the one the server created hands its chirps
+-- to the new placeholder and goes away.
+UPDATE users SET email = 'erased-user+' || id || '@chirpy.invalid'
+WHERE email = 'erased-user@chirpy.invalid';
+
+-- The password hash matches no password, and the account is suspended.
+INSERT INTO users (id, created_at, updated_at, email, hashed_password, email_verified_at, suspended_at, suspension_reason)
+VALUES (
+    '00000000-0000-4000-8000-000000000001',
+    NOW(),
+    NOW(),
+    'erased-user@chirpy.invalid',
+    '!',
+    NOW(),
+    NOW(),
+    'Placeholder author of erased accounts'' chirps'
+);
+
+UPDATE chirps SET user_id = '00000000-0000-4000-8000-000000000001'
+WHERE user_id IN (
+    SELECT id FROM users
+    WHERE email LIKE 'erased-user+%@chirpy.invalid'
+        AND suspension_reason = 'Placeholder author of erased accounts'' chirps'
+);
+DELETE FROM users
+WHERE email LIKE 'erased-user+%@chirpy.invalid'
+    AND suspension_reason = 'Placeholder author of erased accounts'' chirps';
+
+-- +goose Down
+DELETE FROM users WHERE id = '00000000-0000-4000-8000-000000000001';
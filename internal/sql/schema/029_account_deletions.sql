@@ -0,0 +1,9 @@
+-- +goose Up
+-- An erasure that fails is retried later, backing off, so it does not hold
+-- up the accounts due after it.
+ALTER TABLE account_deletions ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE account_deletions ADD COLUMN retry_after TIMESTAMP;
+
+-- +goose Down
+ALTER TABLE account_deletions DROP COLUMN retry_after;
+ALTER TABLE account_deletions DROP COLUMN attempts;
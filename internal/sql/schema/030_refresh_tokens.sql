@@ -0,0 +1,10 @@
+-- +goose Up
+-- Each refresh token remembers the access token issued alongside it, so
+-- revoking a session can reject that too instead of leaving it valid until
+-- it expires.
+ALTER TABLE refresh_tokens ADD COLUMN access_jti TEXT NOT NULL DEFAULT '';
+ALTER TABLE refresh_tokens ADD COLUMN access_expires_at TIMESTAMP;
+
+-- +goose Down
+ALTER TABLE refresh_tokens DROP COLUMN access_expires_at;
+ALTER TABLE refresh_tokens DROP COLUMN access_jti;
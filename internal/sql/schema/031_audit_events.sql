@@ -0,0 +1,43 @@
+-- +goose Up
+-- Any session can turn chirpy.audit_redaction on, so the setting alone must
+-- not let personal data be rewritten. The only update allowed is the one
+-- RedactUserAuditEvents makes: blanking it.
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION reject_audit_event_changes() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'UPDATE'
+        AND current_setting('chirpy.audit_redaction', true) = 'on'
+        AND NEW.id = OLD.id
+        AND NEW.created_at = OLD.created_at
+        AND NEW.action = OLD.action
+        AND NEW.actor_id IS NOT DISTINCT FROM OLD.actor_id
+        AND NEW.target_id IS NOT DISTINCT FROM OLD.target_id
+        AND NEW.ip = ''
+        AND NEW.user_agent = ''
+        AND NEW.metadata = '{}'::jsonb
+    THEN
+        RETURN NEW;
+    END IF;
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION reject_audit_event_changes() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'UPDATE'
+        AND current_setting('chirpy.audit_redaction', true) = 'on'
+        AND NEW.id = OLD.id
+        AND NEW.created_at = OLD.created_at
+        AND NEW.action = OLD.action
+        AND NEW.actor_id IS NOT DISTINCT FROM OLD.actor_id
+        AND NEW.target_id IS NOT DISTINCT FROM OLD.target_id
+    THEN
+        RETURN NEW;
+    END IF;
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
@@ -5,6 +5,7 @@ import (
 	"net/http"
 
 	"github.com/charlesaraya/chirpy/internal/api"
+	"github.com/charlesaraya/chirpy/internal/auth"
 )
 
 func main() {
@@ -22,12 +23,54 @@ func main() {
 	// 2. Set up handlers
 	mux.Handle("/app/", api.GetHomeHandler(apiCfg, "./app", "/app"))
 
+	mux.HandleFunc("GET /api/registration", api.GetRegistrationHandler(apiCfg))
+
 	mux.HandleFunc("POST /api/users", api.CreateUserHandler(apiCfg))
 
 	mux.HandleFunc("PUT /api/users", api.UpdateUserHandler(apiCfg))
 
+	mux.HandleFunc("DELETE /api/users/me", api.DeleteAccountHandler(apiCfg))
+
+	mux.HandleFunc("GET /api/users/me/deletion", api.GetAccountDeletionHandler(apiCfg))
+
+	mux.HandleFunc("DELETE /api/users/me/deletion", api.CancelAccountDeletionHandler(apiCfg))
+
+	mux.HandleFunc("POST /api/users/me/export", api.RequestAccountExportHandler(apiCfg))
+
+	mux.HandleFunc("GET /api/users/me/export/{exportID}", api.GetAccountExportHandler(apiCfg))
+
+	mux.HandleFunc("GET /api/exports/{exportID}/download", api.DownloadAccountExportHandler(apiCfg))
+
+	mux.HandleFunc("POST /api/users/me/import", api.ImportAccountHandler(apiCfg))
+
 	mux.HandleFunc("POST /api/login", api.LoginUserHandler(apiCfg))
 
+	mux.HandleFunc("POST /api/email/verify", api.VerifyEmailHandler(apiCfg))
+
+	mux.HandleFunc("POST /api/email/verify/resend", api.ResendEmailVerificationHandler(apiCfg))
+
+	mux.HandleFunc("POST /api/password/forgot", api.ForgotPasswordHandler(apiCfg))
+
+	mux.HandleFunc("POST /api/password/reset", api.ResetPasswordHandler(apiCfg))
+
+	mux.HandleFunc("POST /api/login/mfa", api.LoginMFAHandler(apiCfg))
+
+	mux.HandleFunc("POST /api/login/magic", api.RequestMagicLinkHandler(apiCfg))
+
+	mux.HandleFunc("POST /api/login/magic/redeem", api.RedeemMagicLinkHandler(apiCfg))
+
+	mux.HandleFunc("GET /api/login/oidc/{provider}", api.OIDCLoginHandler(apiCfg))
+
+	mux.HandleFunc("GET /api/login/oidc/{provider}/callback", api.OIDCCallbackHandler(apiCfg))
+
+	mux.HandleFunc("POST /api/mfa/totp", api.EnrollTOTPHandler(apiCfg))
+
+	mux.HandleFunc("POST /api/mfa/totp/confirm", api.ConfirmTOTPHandler(apiCfg))
+
+	mux.HandleFunc("POST /api/mfa/totp/disable", api.DisableTOTPHandler(apiCfg))
+
+	mux.HandleFunc("POST /api/mfa/recovery-codes", api.RegenerateRecoveryCodesHandler(apiCfg))
+
 	mux.HandleFunc("POST /api/chirps", api.CreateChirpHandler(apiCfg))
 
 	mux.HandleFunc("GET /api/chirps", api.GetChirpsHandler(apiCfg))
@@ -40,13 +83,109 @@ func main() {
 
 	mux.HandleFunc("POST /api/revoke", api.RevokeTokenHandler(apiCfg))
 
+	mux.HandleFunc("POST /api/logout", api.LogoutHandler(apiCfg))
+
+	mux.HandleFunc("GET /api/sessions", api.GetSessionsHandler(apiCfg))
+
+	mux.HandleFunc("DELETE /api/sessions/{sessionID}", api.RevokeSessionHandler(apiCfg))
+
+	mux.HandleFunc("POST /api/sessions/revoke-all", api.RevokeAllSessionsHandler(apiCfg))
+
+	mux.HandleFunc("POST /api/tokens", api.CreateAccessTokenHandler(apiCfg))
+
+	mux.HandleFunc("GET /api/tokens", api.GetAccessTokensHandler(apiCfg))
+
+	mux.HandleFunc("DELETE /api/tokens/{tokenID}", api.RevokeAccessTokenHandler(apiCfg))
+
+	mux.HandleFunc("POST /api/invites", api.CreateInviteHandler(apiCfg))
+
+	mux.HandleFunc("GET /api/invites", api.GetInvitesHandler(apiCfg))
+
+	mux.HandleFunc("DELETE /api/invites/{inviteID}", api.RevokeInviteHandler(apiCfg))
+
+	mux.HandleFunc("POST /api/oauth/clients", api.CreateOAuthClientHandler(apiCfg))
+
+	mux.HandleFunc("GET /api/oauth/clients", api.GetOAuthClientsHandler(apiCfg))
+
+	mux.HandleFunc("DELETE /api/oauth/clients/{clientID}", api.DeleteOAuthClientHandler(apiCfg))
+
+	mux.HandleFunc("GET /api/oauth/authorize", api.GetConsentHandler(apiCfg))
+
+	mux.HandleFunc("POST /api/oauth/authorize", api.ConsentHandler(apiCfg))
+
+	mux.HandleFunc("GET /api/apps", api.GetAppsHandler(apiCfg))
+
+	mux.HandleFunc("DELETE /api/apps/{clientID}", api.RevokeAppHandler(apiCfg))
+
+	mux.HandleFunc("GET /api/device", api.GetDeviceAuthorizationHandler(apiCfg))
+
+	mux.HandleFunc("POST /api/device", api.DecideDeviceAuthorizationHandler(apiCfg))
+
+	mux.HandleFunc("GET /oauth/authorize", api.AuthorizeHandler(apiCfg))
+
+	mux.HandleFunc("POST /oauth/token", api.OAuthTokenHandler(apiCfg))
+
+	mux.HandleFunc("POST /oauth/device_authorization", api.DeviceAuthorizationHandler(apiCfg))
+
+	mux.HandleFunc("POST /oauth/introspect", api.IntrospectHandler(apiCfg))
+
+	mux.HandleFunc("POST /oauth/revoke", api.OAuthRevokeHandler(apiCfg))
+
 	mux.HandleFunc("POST /api/validate_chirp", api.ValidateChirpHandler)
 
 	mux.HandleFunc("GET /api/healthz", api.GetHealthHandler)
 
-	mux.HandleFunc("GET /admin/metrics", api.GetMetricsHandler(apiCfg, api.MetricsTemplatePath))
+	mux.HandleFunc("GET /.well-known/jwks.json", api.GetJWKSHandler(apiCfg))
+
+	mux.HandleFunc("GET /admin/metrics", apiCfg.RequireRole(auth.RoleAdmin, api.GetMetricsHandler(apiCfg, api.MetricsTemplatePath)))
+
+	mux.HandleFunc("POST /admin/reset", apiCfg.RequireRole(auth.RoleAdmin, api.ResetMetricsHandler(apiCfg)))
+
+	mux.HandleFunc("GET /admin/users", apiCfg.RequireRole(auth.RoleAdmin, api.ListUsersHandler(apiCfg)))
+
+	mux.HandleFunc("GET /admin/users/{userID}", apiCfg.RequireRole(auth.RoleAdmin, api.GetUserHandler(apiCfg)))
+
+	mux.HandleFunc("DELETE /admin/users/{userID}", apiCfg.RequireRoleOver(auth.RoleAdmin, api.DeleteUserHandler(apiCfg)))
+
+	mux.HandleFunc("GET /admin/users/{userID}/chirps", apiCfg.RequireRole(auth.RoleModerator, api.GetUserChirpsHandler(apiCfg)))
+
+	mux.HandleFunc("GET /admin/users/{userID}/sessions", apiCfg.RequireRole(auth.RoleAdmin, api.GetUserSessionsHandler(apiCfg)))
+
+	mux.HandleFunc("POST /admin/users/{userID}/password-reset", apiCfg.RequireRoleOver(auth.RoleAdmin, api.ForcePasswordResetHandler(apiCfg)))
+
+	mux.HandleFunc("PUT /admin/users/{userID}/chirpy-red", apiCfg.RequireRoleOver(auth.RoleAdmin, api.SetChirpyRedHandler(apiCfg)))
+
+	mux.HandleFunc("GET /admin/audit", apiCfg.RequireRole(auth.RoleAdmin, api.GetAuditEventsHandler(apiCfg)))
+
+	mux.HandleFunc("GET /admin/audit/export", apiCfg.RequireRole(auth.RoleAdmin, api.ExportAuditEventsHandler(apiCfg)))
+
+	mux.HandleFunc("PUT /admin/users/{userID}/role", apiCfg.RequireRoleOver(auth.RoleAdmin, api.SetUserRoleHandler(apiCfg)))
+
+	mux.HandleFunc("POST /admin/users/{userID}/suspend", apiCfg.RequireRoleOver(auth.RoleModerator, api.SuspendUserHandler(apiCfg)))
+
+	mux.HandleFunc("POST /admin/users/{userID}/unsuspend", apiCfg.RequireRoleOver(auth.RoleModerator, api.UnsuspendUserHandler(apiCfg)))
+
+	mux.HandleFunc("POST /admin/users/{userID}/shadowban", apiCfg.RequireRoleOver(auth.RoleModerator, api.ShadowbanUserHandler(apiCfg)))
+
+	mux.HandleFunc("POST /admin/users/{userID}/unlock", apiCfg.RequireRoleOver(auth.RoleModerator, api.UnlockUserHandler(apiCfg)))
+
+	mux.HandleFunc("GET /admin/lockouts", apiCfg.RequireRole(auth.RoleModerator, api.GetLockedAccountsHandler(apiCfg)))
+
+	mux.HandleFunc("GET /admin/chirps/held", apiCfg.RequireRole(auth.RoleModerator, api.GetHeldChirpsHandler(apiCfg)))
+
+	mux.HandleFunc("POST /admin/chirps/{chirpID}/approve", apiCfg.RequireRole(auth.RoleModerator, api.ApproveChirpHandler(apiCfg)))
+
+	mux.HandleFunc("POST /admin/chirps/{chirpID}/reject", apiCfg.RequireRole(auth.RoleModerator, api.RejectChirpHandler(apiCfg)))
+
+	mux.HandleFunc("GET /admin/signups", apiCfg.RequireRole(auth.RoleModerator, api.GetPendingSignupsHandler(apiCfg)))
+
+	mux.HandleFunc("POST /admin/signups/{userID}/approve", apiCfg.RequireRole(auth.RoleModerator, api.ApproveSignupHandler(apiCfg)))
+
+	mux.HandleFunc("POST /admin/signups/{userID}/reject", apiCfg.RequireRole(auth.RoleModerator, api.RejectSignupHandler(apiCfg)))
+
+	mux.HandleFunc("GET /admin/invites", apiCfg.RequireRole(auth.RoleAdmin, api.ListInvitesHandler(apiCfg)))
 
-	mux.HandleFunc("POST /admin/reset", api.ResetMetricsHandler(apiCfg))
+	mux.HandleFunc("DELETE /admin/invites/{inviteID}", apiCfg.RequireRole(auth.RoleAdmin, api.DeleteInviteHandler(apiCfg)))
 
 	// Webhooks
 	mux.HandleFunc("POST /api/polka/webhooks", api.PolkaWebhookHandler(apiCfg))